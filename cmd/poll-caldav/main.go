@@ -17,17 +17,26 @@
 package main
 
 import (
-	"launchpad.net/account-polld/gettext"
+	"sync"
+
 	"launchpad.net/account-polld/plugins"
 	"launchpad.net/account-polld/plugins/caldav"
+	"launchpad.net/account-polld/qtcontact"
 )
 
-func main() {
-	// Initialize i18n
-	gettext.SetLocale(gettext.LC_ALL, "")
-	gettext.Textdomain("account-polld")
-	gettext.BindTextdomain("account-polld", "/usr/share/locale")
+var mainLoopOnce sync.Once
+
+func init() {
+	startMainLoop()
+}
 
+func startMainLoop() {
+	mainLoopOnce.Do(func() {
+		go qtcontact.MainLoopStart()
+	})
+}
+
+func main() {
 	runner := plugins.NewPluginRunner(caldav.New())
 	runner.Run()
 }
@@ -19,7 +19,6 @@ package main
 import (
 	"sync"
 
-	"launchpad.net/account-polld/gettext"
 	"launchpad.net/account-polld/plugins"
 	"launchpad.net/account-polld/plugins/dekko"
 	"launchpad.net/account-polld/qtcontact"
@@ -38,11 +37,6 @@ func startMainLoop() {
 }
 
 func main() {
-	// Initialize i18n
-	gettext.SetLocale(gettext.LC_ALL, "")
-	gettext.Textdomain("account-polld")
-	gettext.BindTextdomain("account-polld", "/usr/share/locale")
-
 	runner := plugins.NewPluginRunner(dekko.New())
 	runner.Run()
 }
@@ -17,17 +17,11 @@
 package main
 
 import (
-	"launchpad.net/account-polld/gettext"
 	"launchpad.net/account-polld/plugins"
 	"launchpad.net/account-polld/plugins/gcalendar"
 )
 
 func main() {
-	// Initialize i18n
-	gettext.SetLocale(gettext.LC_ALL, "")
-	gettext.Textdomain("account-polld")
-	gettext.BindTextdomain("account-polld", "/usr/share/locale")
-
 	runner := plugins.NewPluginRunner(gcalendar.New())
 	runner.Run()
 }
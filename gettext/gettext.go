@@ -1,3 +1,6 @@
+//go:build cgo && !puregettext
+// +build cgo,!puregettext
+
 /*
   Copyright (c) 2012 José Carlos Nieto, http://xiam.menteslibres.org/
 
@@ -32,8 +35,6 @@ package gettext
 import "C"
 
 import (
-	"fmt"
-	"strings"
 	"unsafe"
 )
 
@@ -158,23 +159,6 @@ func NGettext(msgid string, msgid_plural string, n uint64) string {
 	return res
 }
 
-// Like fmt.Sprintf() but without %!(EXTRA) errors.
-func Sprintf(format string, a ...interface{}) string {
-	expects := strings.Count(format, "%") - strings.Count(format, "%%")
-
-	if expects > 0 {
-		arguments := make([]interface{}, expects)
-		for i := 0; i < expects; i++ {
-			if len(a) > i {
-				arguments[i] = a[i]
-			}
-		}
-		return fmt.Sprintf(format, arguments...)
-	}
-
-	return format
-}
-
 // Like NGettext(), but looking up the message in the specified domain.
 func DNGettext(domainname string, msgid string, msgid_plural string, n uint64) string {
 	cdomainname := C.CString(domainname)
@@ -0,0 +1,235 @@
+//go:build !cgo || puregettext
+// +build !cgo puregettext
+
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// This file implements the package API in pure Go, reading the .mo message
+// catalogs directly. It is used when cgo is not available, or when building
+// with the "puregettext" tag.
+
+package gettext
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The values of the locale categories match the GNU C library ones.
+var (
+	// For all of the locale.
+	LC_ALL = uint(6)
+
+	// For regular expression matching (it determines the meaning of range
+	// expressions and equivalence classes) and string collation.
+	LC_COLATE = uint(6)
+
+	// For regular expression matching, character classification, conversion,
+	// case-sensitive comparison, and wide character functions.
+	LC_CTYPE = uint(0)
+
+	// For localizable natural-language messages.
+	LC_MESSAGES = uint(5)
+
+	// For monetary formatting.
+	LC_MONETARY = uint(4)
+
+	// For number formatting (such as the decimal point and the thousands
+	// separator).
+	LC_NUMERIC = uint(1)
+
+	// For time and date formatting.
+	LC_TIME = uint(2)
+)
+
+var categoryNames = map[uint]string{
+	0: "LC_CTYPE",
+	1: "LC_NUMERIC",
+	2: "LC_TIME",
+	3: "LC_COLLATE",
+	4: "LC_MONETARY",
+	5: "LC_MESSAGES",
+}
+
+const defaultDirname = "/usr/share/locale"
+
+var state = struct {
+	sync.Mutex
+	locales  map[uint]string
+	domain   string
+	dirs     map[string]string
+	codesets map[string]string
+}{
+	locales:  make(map[uint]string),
+	domain:   "messages",
+	dirs:     make(map[string]string),
+	codesets: make(map[string]string),
+}
+
+// Sets or queries the program's current locale. An empty locale selects
+// the one set in the environment, looking at LC_ALL, then at the variable
+// named after the category and finally at LANG.
+func SetLocale(category uint, locale string) string {
+	state.Lock()
+	defer state.Unlock()
+
+	categories := []uint{category}
+	if category == LC_ALL {
+		categories = categories[:0]
+		for c := range categoryNames {
+			categories = append(categories, c)
+		}
+	} else if _, ok := categoryNames[category]; !ok {
+		return ""
+	}
+
+	result := locale
+	for _, c := range categories {
+		l := locale
+		if l == "" {
+			l = localeFromEnv(c)
+		}
+		state.locales[c] = l
+		if c == LC_MESSAGES || category != LC_ALL {
+			result = l
+		}
+	}
+	return result
+}
+
+// localeFromEnv returns the locale the environment sets for the category.
+func localeFromEnv(category uint) string {
+	for _, name := range []string{"LC_ALL", categoryNames[category], "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return "C"
+}
+
+// Sets directory containing message catalogs.
+func BindTextdomain(domainname string, dirname string) string {
+	state.Lock()
+	defer state.Unlock()
+	if dirname != "" {
+		state.dirs[domainname] = dirname
+	}
+	if dir, ok := state.dirs[domainname]; ok {
+		return dir
+	}
+	return defaultDirname
+}
+
+// Sets the output codeset for message catalogs for domain domainname.
+// Catalogs are expected to be encoded in UTF-8, which is also the output
+// codeset: the value is only stored.
+func BindTextdomainCodeset(domainname string, codeset string) string {
+	state.Lock()
+	defer state.Unlock()
+	if codeset != "" {
+		state.codesets[domainname] = codeset
+	}
+	return state.codesets[domainname]
+}
+
+// Sets or retrieves the current message domain.
+func Textdomain(domainname string) string {
+	state.Lock()
+	defer state.Unlock()
+	if domainname != "" {
+		state.domain = domainname
+	}
+	return state.domain
+}
+
+// Attempt to translate a text string into the user's native language, by
+// looking up the translation in a message catalog.
+func Gettext(msgid string) string {
+	return DCGettext("", msgid, LC_MESSAGES)
+}
+
+// Like Gettext(), but looking up the message in the specified domain.
+func DGettext(domain string, msgid string) string {
+	return DCGettext(domain, msgid, LC_MESSAGES)
+}
+
+// Like Gettext(), but looking up the message in the specified domain and
+// category.
+func DCGettext(domain string, msgid string, category uint) string {
+	for _, cat := range catalogs(domain, category) {
+		if res, ok := cat.gettext(msgid); ok {
+			return res
+		}
+	}
+	return msgid
+}
+
+// Attempt to translate a text string into the user's native language, by
+// looking up the appropriate plural form of the translation in a message
+// catalog.
+func NGettext(msgid string, msgid_plural string, n uint64) string {
+	return DCNGettext("", msgid, msgid_plural, n, LC_MESSAGES)
+}
+
+// Like NGettext(), but looking up the message in the specified domain.
+func DNGettext(domainname string, msgid string, msgid_plural string, n uint64) string {
+	return DCNGettext(domainname, msgid, msgid_plural, n, LC_MESSAGES)
+}
+
+// Like NGettext(), but looking up the message in the specified domain and
+// category.
+func DCNGettext(domainname string, msgid string, msgid_plural string, n uint64, category uint) string {
+	for _, cat := range catalogs(domainname, category) {
+		if res, ok := cat.ngettext(msgid, n); ok {
+			return res
+		}
+	}
+	if n == 1 {
+		return msgid
+	}
+	return msgid_plural
+}
+
+// catalogs returns the catalogs of the domain to search, in order of
+// preference.
+func catalogs(domain string, category uint) []*moCatalog {
+	state.Lock()
+	defer state.Unlock()
+
+	if domain == "" {
+		domain = state.domain
+	}
+	categoryName, ok := categoryNames[category]
+	if !ok {
+		return nil
+	}
+	dir, ok := state.dirs[domain]
+	if !ok {
+		dir = defaultDirname
+	}
+
+	var result []*moCatalog
+	for _, language := range languages(state.locales[category]) {
+		for _, variant := range localeVariants(language) {
+			path := filepath.Join(dir, variant, categoryName, domain+".mo")
+			if cat := cachedCatalog(path); cat != nil {
+				result = append(result, cat)
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,49 @@
+//go:build !cgo || puregettext
+// +build !cgo puregettext
+
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gettext
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSetLocaleFromEnv(t *testing.T) {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+	defer SetLocale(LC_ALL, "C")
+
+	if locale := SetLocale(LC_ALL, ""); locale != "C" {
+		t.Errorf("expected C locale, got %q", locale)
+	}
+	os.Setenv("LANG", "de_DE.UTF-8")
+	if locale := SetLocale(LC_ALL, ""); locale != "de_DE.UTF-8" {
+		t.Errorf("expected LANG locale, got %q", locale)
+	}
+	os.Setenv("LC_MESSAGES", "it_IT.UTF-8")
+	if locale := SetLocale(LC_MESSAGES, ""); locale != "it_IT.UTF-8" {
+		t.Errorf("expected LC_MESSAGES locale, got %q", locale)
+	}
+	os.Setenv("LC_ALL", "fr_FR.UTF-8")
+	if locale := SetLocale(LC_ALL, ""); locale != "fr_FR.UTF-8" {
+		t.Errorf("expected LC_ALL locale, got %q", locale)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gettext
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// catalog is the content of a message catalog: msgids are mapped to their
+// translations; plural entries use "singular\x00plural" as key and list all
+// the plural forms separated by "\x00".
+type catalog struct {
+	pluralForms string
+	messages    map[string]string
+}
+
+// writeMo compiles the catalog into a GNU .mo file.
+func writeMo(path string, cat catalog) error {
+	messages := map[string]string{
+		"": "Content-Type: text/plain; charset=UTF-8\n" +
+			"Plural-Forms: " + cat.pluralForms + "\n",
+	}
+	for k, v := range cat.messages {
+		messages[k] = v
+	}
+	keys := make([]string, 0, len(messages))
+	for k := range messages {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	n := uint32(len(keys))
+	const headerSize = 28
+	origTable := uint32(headerSize)
+	transTable := origTable + 8*n
+	offset := transTable + 8*n
+
+	header := []uint32{0x950412de, 0, n, origTable, transTable, 0, offset}
+	var origs, trans []uint32
+	var data []byte
+	for _, k := range keys {
+		origs = append(origs, uint32(len(k)), offset+uint32(len(data)))
+		data = append(append(data, k...), 0)
+	}
+	for _, k := range keys {
+		v := messages[k]
+		trans = append(trans, uint32(len(v)), offset+uint32(len(data)))
+		data = append(append(data, v...), 0)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	for _, table := range [][]uint32{header, origs, trans} {
+		if err := binary.Write(file, binary.LittleEndian, table); err != nil {
+			return err
+		}
+	}
+	_, err = file.Write(data)
+	return err
+}
+
+var pluralCatalogs = map[string]catalog{
+	"cs": {
+		"nplurals=3; plural=(n==1) ? 0 : (n>=2 && n<=4) ? 1 : 2;",
+		map[string]string{
+			"%d new message\x00%d new messages": "%d nová zpráva\x00%d nové zprávy\x00%d nových zpráv",
+		},
+	},
+	"ar": {
+		"nplurals=6; plural=n==0 ? 0 : n==1 ? 1 : n==2 ? 2 : n%100>=3 && n%100<=10 ? 3 : n%100>=11 ? 4 : 5;",
+		map[string]string{
+			"%d new message\x00%d new messages": "form0 %d\x00form1 %d\x00form2 %d\x00form3 %d\x00form4 %d\x00form5 %d",
+		},
+	},
+}
+
+var pluralTests = []struct {
+	language string
+	n        uint64
+	expected string
+}{
+	{"cs", 0, "%d nových zpráv"},
+	{"cs", 1, "%d nová zpráva"},
+	{"cs", 2, "%d nové zprávy"},
+	{"cs", 4, "%d nové zprávy"},
+	{"cs", 5, "%d nových zpráv"},
+	{"cs", 22, "%d nových zpráv"},
+	{"ar", 0, "form0 %d"},
+	{"ar", 1, "form1 %d"},
+	{"ar", 2, "form2 %d"},
+	{"ar", 3, "form3 %d"},
+	{"ar", 10, "form3 %d"},
+	{"ar", 11, "form4 %d"},
+	{"ar", 99, "form4 %d"},
+	{"ar", 100, "form5 %d"},
+	{"ar", 102, "form5 %d"},
+	{"ar", 103, "form3 %d"},
+	{"ar", 111, "form4 %d"},
+}
+
+func TestNGettextPluralForms(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gettext-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Using a different domain for each language lets us switch language
+	// without being affected by the translations cached by libintl.
+	for language, cat := range pluralCatalogs {
+		domain := "test-" + language
+		path := filepath.Join(dir, language, "LC_MESSAGES", domain+".mo")
+		if err := writeMo(path, cat); err != nil {
+			t.Fatal(err)
+		}
+		BindTextdomain(domain, dir)
+		BindTextdomainCodeset(domain, "UTF-8")
+	}
+
+	// LANGUAGE is ignored in the "C" locale
+	if SetLocale(LC_ALL, "C.UTF-8") == "" {
+		t.Skip("C.UTF-8 locale not available")
+	}
+	defer SetLocale(LC_ALL, "C")
+
+	for _, test := range pluralTests {
+		os.Setenv("LANGUAGE", test.language)
+		// Let libintl notice the change of LANGUAGE
+		SetLocale(LC_ALL, "C.UTF-8")
+		domain := "test-" + test.language
+		result := DNGettext(domain, "%d new message", "%d new messages", test.n)
+		if result != test.expected {
+			t.Errorf("%s, n = %d: expected %q, got %q", test.language, test.n, test.expected, result)
+		}
+	}
+	os.Unsetenv("LANGUAGE")
+}
+
+func TestNGettextUntranslated(t *testing.T) {
+	for _, n := range []uint64{0, 1, 2} {
+		expected := "%d new messages"
+		if n == 1 {
+			expected = "%d new message"
+		}
+		result := NGettext("%d new message", "%d new messages", n)
+		if result != expected {
+			t.Errorf("n = %d: expected %q, got %q", n, expected, result)
+		}
+		if strings.Contains(result, "\x00") {
+			t.Errorf("n = %d: unexpected NUL in %q", n, result)
+		}
+	}
+}
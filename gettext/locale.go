@@ -0,0 +1,204 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gettext
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Locale is a handle translating the messages of a domain into a given
+// language, independently of the process locale. Creating a new handle is
+// cheap, since the catalogs are cached. The handles always read the .mo
+// catalogs in Go: the "puregettext" tag only selects the implementation of
+// the package functions, such as SetLocale and Gettext.
+type Locale struct {
+	domain    string
+	dirname   string
+	languages []string
+}
+
+// NewLocale returns a handle for the messages of domain, whose catalogs
+// are installed under dirname. The locale can be a colon separated list
+// of locales, as in the LANGUAGE environment variable; if empty, the
+// locale is taken from the environment, looking at LANGUAGE, LC_ALL,
+// LC_MESSAGES and LANG.
+func NewLocale(domain, dirname, locale string) *Locale {
+	var langs []string
+	if locale == "" {
+		langs = languages(messagesLocaleFromEnv())
+	} else {
+		langs = splitLanguages(locale)
+	}
+	return &Locale{domain: domain, dirname: dirname, languages: langs}
+}
+
+// Language returns the preferred language of the handle, or an empty
+// string if messages are not translated.
+func (l *Locale) Language() string {
+	if len(l.languages) == 0 {
+		return ""
+	}
+	return l.languages[0]
+}
+
+// Gettext returns the translation of msgid.
+func (l *Locale) Gettext(msgid string) string {
+	for _, cat := range l.catalogs() {
+		if res, ok := cat.gettext(msgid); ok {
+			return res
+		}
+	}
+	return msgid
+}
+
+// NGettext returns the appropriate plural form of the translation of
+// msgid for n.
+func (l *Locale) NGettext(msgid string, msgid_plural string, n uint64) string {
+	for _, cat := range l.catalogs() {
+		if res, ok := cat.ngettext(msgid, n); ok {
+			return res
+		}
+	}
+	if n == 1 {
+		return msgid
+	}
+	return msgid_plural
+}
+
+func (l *Locale) catalogs() []*moCatalog {
+	var result []*moCatalog
+	for _, language := range l.languages {
+		for _, variant := range localeVariants(language) {
+			path := filepath.Join(l.dirname, variant, "LC_MESSAGES", l.domain+".mo")
+			if cat := cachedCatalog(path); cat != nil {
+				result = append(result, cat)
+			}
+		}
+	}
+	return result
+}
+
+var catalogCache = struct {
+	sync.Mutex
+	// catalogs holds the loaded catalogs by path; nil marks the missing
+	// ones.
+	catalogs map[string]*moCatalog
+}{catalogs: make(map[string]*moCatalog)}
+
+// cachedCatalog returns the catalog at path, or nil if it can't be loaded.
+func cachedCatalog(path string) *moCatalog {
+	catalogCache.Lock()
+	defer catalogCache.Unlock()
+	cat, ok := catalogCache.catalogs[path]
+	if !ok {
+		cat, _ = loadMo(path)
+		catalogCache.catalogs[path] = cat
+	}
+	return cat
+}
+
+// messagesLocaleFromEnv returns the locale the environment sets for
+// messages.
+func messagesLocaleFromEnv() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return "C"
+}
+
+// languages returns the languages to use for the given locale: LANGUAGE
+// takes precedence unless the locale is "C", as in GNU gettext.
+func languages(locale string) []string {
+	if locale == "" || locale == "C" || locale == "POSIX" {
+		return nil
+	}
+	if value := os.Getenv("LANGUAGE"); value != "" {
+		return splitLanguages(value)
+	}
+	return []string{locale}
+}
+
+func splitLanguages(value string) []string {
+	var result []string
+	for _, l := range strings.Split(value, ":") {
+		if l != "" && l != "C" && l != "POSIX" {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// localeVariants returns the names under which the catalogs for a locale
+// of the form language[_territory][.codeset][@modifier] might be installed,
+// from the most to the least specific.
+func localeVariants(locale string) []string {
+	var territory, codeset, modifier string
+	language := locale
+	if i := strings.IndexByte(language, '@'); i >= 0 {
+		language, modifier = language[:i], language[i:]
+	}
+	if i := strings.IndexByte(language, '.'); i >= 0 {
+		language, codeset = language[:i], language[i:]
+	}
+	if i := strings.IndexByte(language, '_'); i >= 0 {
+		language, territory = language[:i], language[i:]
+	}
+
+	var codesets []string
+	if codeset != "" {
+		codesets = append(codesets, codeset)
+		if normalized := normalizeCodeset(codeset); normalized != codeset {
+			codesets = append(codesets, normalized)
+		}
+	}
+	codesets = append(codesets, "")
+
+	var variants []string
+	seen := make(map[string]bool)
+	add := func(v string) {
+		if !seen[v] {
+			seen[v] = true
+			variants = append(variants, v)
+		}
+	}
+	for _, mod := range []string{modifier, ""} {
+		for _, t := range []string{territory, ""} {
+			for _, cs := range codesets {
+				add(language + t + cs + mod)
+			}
+		}
+	}
+	return variants
+}
+
+// normalizeCodeset turns e.g. ".UTF-8" into ".utf8", as GNU libc does.
+func normalizeCodeset(codeset string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		case r == '.' || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9'):
+			return r
+		}
+		return -1
+	}, codeset)
+}
@@ -0,0 +1,98 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gettext
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocaleVariants(t *testing.T) {
+	expected := []string{
+		"pt_BR.UTF-8@euro", "pt_BR.utf8@euro", "pt_BR@euro",
+		"pt.UTF-8@euro", "pt.utf8@euro", "pt@euro",
+		"pt_BR.UTF-8", "pt_BR.utf8", "pt_BR",
+		"pt.UTF-8", "pt.utf8", "pt",
+	}
+	if variants := localeVariants("pt_BR.UTF-8@euro"); !reflect.DeepEqual(variants, expected) {
+		t.Errorf("unexpected variants %q", variants)
+	}
+	if variants := localeVariants("cs"); !reflect.DeepEqual(variants, []string{"cs"}) {
+		t.Errorf("unexpected variants %q", variants)
+	}
+}
+
+func TestLocaleHandle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gettext-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for language, cat := range pluralCatalogs {
+		path := filepath.Join(dir, language, "LC_MESSAGES", "test.mo")
+		if err := writeMo(path, cat); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The handles don't depend on the process locale, so they can be
+	// used at the same time.
+	handles := map[string]*Locale{
+		"cs": NewLocale("test", dir, "cs_CZ.UTF-8"),
+		"ar": NewLocale("test", dir, "ar"),
+	}
+	for _, test := range pluralTests {
+		result := handles[test.language].NGettext("%d new message", "%d new messages", test.n)
+		if result != test.expected {
+			t.Errorf("%s, n = %d: expected %q, got %q", test.language, test.n, test.expected, result)
+		}
+	}
+
+	l := NewLocale("test", dir, "de:cs")
+	if l.Language() != "de" {
+		t.Errorf("unexpected language %q", l.Language())
+	}
+	if result := l.NGettext("%d new message", "%d new messages", 1); result != "%d nová zpráva" {
+		t.Errorf("expected fallback to Czech, got %q", result)
+	}
+	if result := l.Gettext("untranslated"); result != "untranslated" {
+		t.Errorf("unexpected translation %q", result)
+	}
+}
+
+func TestLocaleHandleFromEnv(t *testing.T) {
+	for _, name := range []string{"LANGUAGE", "LC_ALL", "LC_MESSAGES", "LANG"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	if l := NewLocale("test", "", ""); l.Language() != "" {
+		t.Errorf("expected no language, got %q", l.Language())
+	}
+	os.Setenv("LANG", "cs_CZ.UTF-8")
+	if l := NewLocale("test", "", ""); l.Language() != "cs_CZ.UTF-8" {
+		t.Errorf("expected LANG language, got %q", l.Language())
+	}
+	os.Setenv("LANGUAGE", "ar:cs")
+	if l := NewLocale("test", "", ""); l.Language() != "ar" {
+		t.Errorf("expected LANGUAGE language, got %q", l.Language())
+	}
+}
@@ -0,0 +1,414 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gettext
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	moMagic        = 0x950412de
+	moMagicSwapped = 0xde120495
+)
+
+var errInvalidMo = errors.New("invalid .mo file")
+
+// moCatalog holds the translations of a GNU .mo message catalog.
+type moCatalog struct {
+	// translations maps each msgid to its translations; there is more
+	// than one only for plural entries.
+	translations map[string][]string
+	nplurals     int
+	plural       pluralFunc
+}
+
+// loadMo reads the message catalog at path.
+func loadMo(path string) (*moCatalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMo(data)
+}
+
+// parseMo decodes the content of a .mo file, whose format is described in
+// https://www.gnu.org/software/gettext/manual/html_node/MO-Files.html
+func parseMo(data []byte) (*moCatalog, error) {
+	if len(data) < 28 {
+		return nil, errInvalidMo
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	switch order.Uint32(data) {
+	case moMagic:
+	case moMagicSwapped:
+		order = binary.BigEndian
+	default:
+		return nil, errInvalidMo
+	}
+	n := order.Uint32(data[8:])
+	origTable := order.Uint32(data[12:])
+	transTable := order.Uint32(data[16:])
+
+	// str returns the i-th string described in the table at offset
+	str := func(table, i uint32) (string, error) {
+		entry := uint64(table) + 8*uint64(i)
+		if entry+8 > uint64(len(data)) {
+			return "", errInvalidMo
+		}
+		length := uint64(order.Uint32(data[entry:]))
+		offset := uint64(order.Uint32(data[entry+4:]))
+		if offset+length > uint64(len(data)) {
+			return "", errInvalidMo
+		}
+		return string(data[offset : offset+length]), nil
+	}
+
+	cat := &moCatalog{
+		translations: make(map[string][]string, n),
+		nplurals:     2,
+		plural:       germanicPlural,
+	}
+	for i := uint32(0); i < n; i++ {
+		orig, err := str(origTable, i)
+		if err != nil {
+			return nil, err
+		}
+		trans, err := str(transTable, i)
+		if err != nil {
+			return nil, err
+		}
+		if orig == "" {
+			cat.parseHeader(trans)
+			continue
+		}
+		// Plural entries are stored as "msgid\x00msgid_plural"
+		if i := strings.IndexByte(orig, 0); i >= 0 {
+			orig = orig[:i]
+		}
+		cat.translations[orig] = strings.Split(trans, "\x00")
+	}
+	return cat, nil
+}
+
+// parseHeader reads the plural forms definition from the catalog header.
+func (cat *moCatalog) parseHeader(header string) {
+	for _, line := range strings.Split(header, "\n") {
+		const key = "plural-forms:"
+		if !strings.HasPrefix(strings.ToLower(line), key) {
+			continue
+		}
+		nplurals, plural, err := parsePluralForms(line[len(key):])
+		if err != nil {
+			// Keep the default, as GNU gettext does
+			return
+		}
+		cat.nplurals = nplurals
+		cat.plural = plural
+	}
+}
+
+// gettext returns the translation of msgid, if any.
+func (cat *moCatalog) gettext(msgid string) (string, bool) {
+	trans, ok := cat.translations[msgid]
+	if !ok || len(trans) == 0 || trans[0] == "" {
+		return "", false
+	}
+	return trans[0], true
+}
+
+// ngettext returns the plural form of the translation of msgid for n.
+func (cat *moCatalog) ngettext(msgid string, n uint64) (string, bool) {
+	trans, ok := cat.translations[msgid]
+	if !ok {
+		return "", false
+	}
+	index := cat.plural(n)
+	if index >= uint64(cat.nplurals) || index >= uint64(len(trans)) {
+		index = 0
+	}
+	if trans[index] == "" {
+		return "", false
+	}
+	return trans[index], true
+}
+
+// pluralFunc maps a number to the index of its plural form.
+type pluralFunc func(n uint64) uint64
+
+// germanicPlural is used when the catalog doesn't define its plural forms.
+func germanicPlural(n uint64) uint64 {
+	if n == 1 {
+		return 0
+	}
+	return 1
+}
+
+// parsePluralForms parses the value of the Plural-Forms header, e.g.
+// "nplurals=2; plural=(n != 1);".
+func parsePluralForms(value string) (int, pluralFunc, error) {
+	var nplurals int
+	var plural pluralFunc
+	for _, field := range strings.Split(value, ";") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(parts[0]) {
+		case "nplurals":
+			n, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+			if err != nil || n < 1 {
+				return 0, nil, fmt.Errorf("invalid nplurals %q", parts[1])
+			}
+			nplurals = n
+		case "plural":
+			f, err := parsePluralExpression(parts[1])
+			if err != nil {
+				return 0, nil, err
+			}
+			plural = f
+		}
+	}
+	if nplurals == 0 || plural == nil {
+		return 0, nil, fmt.Errorf("incomplete plural forms %q", value)
+	}
+	return nplurals, plural, nil
+}
+
+// pluralParser is a recursive descent parser for the C expressions used in
+// plural forms, with the usual C operator precedence.
+type pluralParser struct {
+	tokens []string
+	pos    int
+}
+
+// parsePluralExpression compiles the expression into a function.
+func parsePluralExpression(expr string) (f pluralFunc, err error) {
+	tokens, err := tokenizePlural(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &pluralParser{tokens: tokens}
+	f, err = p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q in plural expression", p.tokens[p.pos])
+	}
+	return f, nil
+}
+
+func tokenizePlural(expr string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(expr) && expr[j] >= '0' && expr[j] <= '9' {
+				j++
+			}
+			tokens = append(tokens, expr[i:j])
+			i = j
+		case c == 'n' || strings.IndexByte("?:()+-*/%", c) >= 0:
+			tokens = append(tokens, expr[i:i+1])
+			i++
+		case strings.IndexByte("=!<>&|", c) >= 0:
+			if i+1 < len(expr) && strings.IndexByte("=&|", expr[i+1]) >= 0 {
+				tokens = append(tokens, expr[i:i+2])
+				i += 2
+			} else {
+				tokens = append(tokens, expr[i:i+1])
+				i++
+			}
+		default:
+			return nil, fmt.Errorf("invalid character %q in plural expression", c)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *pluralParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *pluralParser) expect(token string) error {
+	if p.peek() != token {
+		return fmt.Errorf("expected %q in plural expression", token)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *pluralParser) ternary() (pluralFunc, error) {
+	cond, err := p.binary(0)
+	if err != nil || p.peek() != "?" {
+		return cond, err
+	}
+	p.pos++
+	a, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.ternary()
+	if err != nil {
+		return nil, err
+	}
+	return func(n uint64) uint64 {
+		if cond(n) != 0 {
+			return a(n)
+		}
+		return b(n)
+	}, nil
+}
+
+// binaryLevels lists the binary operators from the lowest precedence
+var binaryLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *pluralParser) binary(level int) (pluralFunc, error) {
+	if level == len(binaryLevels) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if !containsString(binaryLevels[level], op) {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryOp(op, left, right)
+	}
+}
+
+func (p *pluralParser) unary() (pluralFunc, error) {
+	switch token := p.peek(); {
+	case token == "!":
+		p.pos++
+		f, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(n uint64) uint64 { return boolToUint(f(n) == 0) }, nil
+	case token == "(":
+		p.pos++
+		f, err := p.ternary()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	case token == "n":
+		p.pos++
+		return func(n uint64) uint64 { return n }, nil
+	case token != "" && unicode.IsDigit(rune(token[0])):
+		p.pos++
+		v, err := strconv.ParseUint(token, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return func(uint64) uint64 { return v }, nil
+	case token == "":
+		return nil, errors.New("unexpected end of plural expression")
+	default:
+		return nil, fmt.Errorf("unexpected %q in plural expression", token)
+	}
+}
+
+func binaryOp(op string, a, b pluralFunc) pluralFunc {
+	switch op {
+	case "||":
+		return func(n uint64) uint64 { return boolToUint(a(n) != 0 || b(n) != 0) }
+	case "&&":
+		return func(n uint64) uint64 { return boolToUint(a(n) != 0 && b(n) != 0) }
+	case "==":
+		return func(n uint64) uint64 { return boolToUint(a(n) == b(n)) }
+	case "!=":
+		return func(n uint64) uint64 { return boolToUint(a(n) != b(n)) }
+	case "<":
+		return func(n uint64) uint64 { return boolToUint(a(n) < b(n)) }
+	case ">":
+		return func(n uint64) uint64 { return boolToUint(a(n) > b(n)) }
+	case "<=":
+		return func(n uint64) uint64 { return boolToUint(a(n) <= b(n)) }
+	case ">=":
+		return func(n uint64) uint64 { return boolToUint(a(n) >= b(n)) }
+	case "+":
+		return func(n uint64) uint64 { return a(n) + b(n) }
+	case "-":
+		return func(n uint64) uint64 { return a(n) - b(n) }
+	case "*":
+		return func(n uint64) uint64 { return a(n) * b(n) }
+	case "/":
+		return func(n uint64) uint64 {
+			if d := b(n); d != 0 {
+				return a(n) / d
+			}
+			return 0
+		}
+	case "%":
+		return func(n uint64) uint64 {
+			if d := b(n); d != 0 {
+				return a(n) % d
+			}
+			return 0
+		}
+	}
+	panic("unknown operator " + op)
+}
+
+func boolToUint(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
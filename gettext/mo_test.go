@@ -0,0 +1,101 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gettext
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var pluralExpressionTests = []struct {
+	expr     string
+	values   []uint64
+	expected []uint64
+}{
+	{"n != 1", []uint64{0, 1, 2}, []uint64{1, 0, 1}},
+	{"n>1", []uint64{0, 1, 2}, []uint64{0, 0, 1}},
+	{"0", []uint64{0, 1, 2}, []uint64{0, 0, 0}},
+	{
+		// Russian
+		"(n%10==1 && n%100!=11 ? 0 : n%10>=2 && n%10<=4 && (n%100<10 || n%100>=20) ? 1 : 2)",
+		[]uint64{1, 2, 5, 11, 21, 22, 25, 111, 112},
+		[]uint64{0, 1, 2, 2, 0, 1, 2, 2, 2},
+	},
+	{"!(n == 1)", []uint64{1, 3}, []uint64{0, 1}},
+	{"n / 0 + 2 * 3 - 1", []uint64{7}, []uint64{5}},
+}
+
+func TestPluralExpressions(t *testing.T) {
+	for _, test := range pluralExpressionTests {
+		f, err := parsePluralExpression(test.expr)
+		if err != nil {
+			t.Errorf("%q: %s", test.expr, err)
+			continue
+		}
+		for i, n := range test.values {
+			if result := f(n); result != test.expected[i] {
+				t.Errorf("%q, n = %d: expected %d, got %d", test.expr, n, test.expected[i], result)
+			}
+		}
+	}
+}
+
+func TestInvalidPluralExpressions(t *testing.T) {
+	for _, expr := range []string{"", "n ==", "(n", "n ? 1", "x", "n 1"} {
+		if _, err := parsePluralExpression(expr); err == nil {
+			t.Errorf("%q: expected an error", expr)
+		}
+	}
+}
+
+func TestParseMo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gettext-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "cs.mo")
+	if err := writeMo(path, pluralCatalogs["cs"]); err != nil {
+		t.Fatal(err)
+	}
+	cat, err := loadMo(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cat.nplurals != 3 {
+		t.Errorf("expected 3 plural forms, got %d", cat.nplurals)
+	}
+	for _, test := range pluralTests {
+		if test.language != "cs" {
+			continue
+		}
+		result, ok := cat.ngettext("%d new message", test.n)
+		if !ok || result != test.expected {
+			t.Errorf("n = %d: expected %q, got %q", test.n, test.expected, result)
+		}
+	}
+	if _, ok := cat.gettext("missing"); ok {
+		t.Error("unexpected translation for a missing message")
+	}
+
+	if _, err := parseMo([]byte("not a catalog, really")); err == nil {
+		t.Error("expected an error for an invalid catalog")
+	}
+}
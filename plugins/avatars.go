@@ -0,0 +1,255 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"launchpad.net/go-xdg/v0"
+)
+
+var XdgCacheEnsure = xdg.Cache.Ensure
+
+// FileIconUrl returns the URL of a local image to be used as the icon of a
+// notification card. The path is encoded with the file scheme defined in
+// RFC 1738.
+func FileIconUrl(path string) string {
+	return "file://" + url.QueryEscape(path)
+}
+
+// AvatarCache keeps local copies of remote profile images, so that the
+// notifications don't need to load them from the network.
+type AvatarCache struct {
+	// MaxImageSize is the maximum size in bytes of a single image;
+	// larger ones are not used.
+	MaxImageSize int64
+	// MaxTotalSize is the size in bytes the cache is kept within, by
+	// removing the images which were used least recently.
+	MaxTotalSize int64
+	// RevalidateAfter is how long an image is used before checking
+	// whether it changed.
+	RevalidateAfter time.Duration
+	// Client is the HTTP client used for the downloads; if nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// Avatars is the cache shared by the plugins
+var Avatars = &AvatarCache{
+	MaxImageSize:    512 * 1024,
+	MaxTotalSize:    16 * 1024 * 1024,
+	RevalidateAfter: 24 * time.Hour,
+}
+
+var errImageTooLarge = errors.New("image too large")
+
+type avatarEntry struct {
+	File string `json:"file"`
+	ETag string `json:"etag,omitempty"`
+	Size int64  `json:"size"`
+	// Used and Checked are the last time the image was used and
+	// downloaded or revalidated, in seconds since the epoch
+	Used    int64 `json:"used"`
+	Checked int64 `json:"checked"`
+}
+
+type avatarIndex map[string]*avatarEntry
+
+// Get returns the file:// URL of the local copy of the image at
+// imageUrl, downloading it if needed. It returns an empty string if the
+// image is not available, rather than the remote URL.
+func (c *AvatarCache) Get(imageUrl string) string {
+	if imageUrl == "" {
+		return ""
+	}
+	indexPath, err := XdgCacheEnsure(filepath.Join(cmdName, "avatars", "index.json"))
+	if err != nil {
+		log.Print("Cannot create the avatar cache: ", err)
+		return ""
+	}
+	dir := filepath.Dir(indexPath)
+	index := loadAvatarIndex(indexPath)
+	defer index.save(indexPath)
+
+	now := time.Now()
+	e, ok := index[imageUrl]
+	if ok {
+		if _, err := os.Stat(filepath.Join(dir, e.File)); err != nil {
+			delete(index, imageUrl)
+			ok = false
+		}
+	}
+	if !ok {
+		e = &avatarEntry{File: avatarFileName(imageUrl)}
+	}
+	if !ok || now.Sub(time.Unix(e.Checked, 0)) >= c.RevalidateAfter {
+		if err := c.download(imageUrl, filepath.Join(dir, e.File), e); err != nil {
+			log.Print("Cannot download avatar ", imageUrl, ": ", err)
+			if !ok {
+				return ""
+			}
+			// Keep using the old copy
+		} else {
+			e.Checked = now.Unix()
+		}
+	}
+	e.Used = now.Unix()
+	index[imageUrl] = e
+	index.evict(dir, c.MaxTotalSize, imageUrl)
+	return FileIconUrl(filepath.Join(dir, e.File))
+}
+
+// download fetches the image into path, unless it didn't change since the
+// version described by e, and updates e.
+func (c *AvatarCache) download(imageUrl, path string, e *avatarEntry) error {
+	req, err := http.NewRequest("GET", imageUrl, nil)
+	if err != nil {
+		return err
+	}
+	if e.ETag != "" {
+		req.Header.Set("If-None-Match", e.ETag)
+	}
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && e.ETag != "" {
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "image/") {
+		return fmt.Errorf("unexpected content type %q", contentType)
+	}
+	if c.MaxImageSize > 0 && resp.ContentLength > c.MaxImageSize {
+		return errImageTooLarge
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	var body io.Reader = resp.Body
+	if c.MaxImageSize > 0 {
+		body = io.LimitReader(resp.Body, c.MaxImageSize+1)
+	}
+	size, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if c.MaxImageSize > 0 && size > c.MaxImageSize {
+		return errImageTooLarge
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+	e.ETag = resp.Header.Get("ETag")
+	e.Size = size
+	return nil
+}
+
+// avatarFileName returns the name of the cached copy of an image
+func avatarFileName(imageUrl string) string {
+	sum := sha1.Sum([]byte(imageUrl))
+	return hex.EncodeToString(sum[:])
+}
+
+func loadAvatarIndex(path string) avatarIndex {
+	index := make(avatarIndex)
+	file, err := os.Open(path)
+	if err != nil {
+		return index
+	}
+	defer file.Close()
+	if err := json.NewDecoder(file).Decode(&index); err != nil {
+		log.Print("Discarding the corrupted avatar cache index: ", err)
+		return make(avatarIndex)
+	}
+	return index
+}
+
+func (index avatarIndex) save(path string) {
+	data, err := json.Marshal(index)
+	if err == nil {
+		err = ioutil.WriteFile(path, data, 0600)
+	}
+	if err != nil {
+		log.Print("Cannot save the avatar cache index: ", err)
+	}
+}
+
+type byLastUse struct {
+	urls  []string
+	index avatarIndex
+}
+
+func (b byLastUse) Len() int      { return len(b.urls) }
+func (b byLastUse) Swap(i, j int) { b.urls[i], b.urls[j] = b.urls[j], b.urls[i] }
+func (b byLastUse) Less(i, j int) bool {
+	return b.index[b.urls[i]].Used < b.index[b.urls[j]].Used
+}
+
+// evict removes the least recently used images until the cache fits in
+// maxSize, but never the one being returned.
+func (index avatarIndex) evict(dir string, maxSize int64, keep string) {
+	if maxSize <= 0 {
+		return
+	}
+	var total int64
+	urls := make([]string, 0, len(index))
+	for u, e := range index {
+		total += e.Size
+		urls = append(urls, u)
+	}
+	sort.Sort(byLastUse{urls, index})
+	for _, u := range urls {
+		if total <= maxSize {
+			break
+		}
+		if u == keep {
+			continue
+		}
+		os.Remove(filepath.Join(dir, index[u].File))
+		total -= index[u].Size
+		delete(index, u)
+	}
+}
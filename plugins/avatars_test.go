@@ -0,0 +1,150 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+// avatarServer serves images whose content is their path
+type avatarServer struct {
+	*httptest.Server
+	requests    int
+	revalidated int
+}
+
+func newAvatarServer() *avatarServer {
+	s := &avatarServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests++
+		etag := `"` + r.URL.Path + `"`
+		if r.Header.Get("If-None-Match") == etag {
+			s.revalidated++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.URL.Path == "/page" {
+			w.Header().Set("Content-Type", "text/html")
+		} else {
+			w.Header().Set("Content-Type", "image/png")
+		}
+		w.Header().Set("ETag", etag)
+		w.Write([]byte(r.URL.Path))
+	}))
+	return s
+}
+
+// avatarPath returns the local path of the file:// URL returned by the cache
+func avatarPath(c *C, iconUrl string) string {
+	c.Assert(strings.HasPrefix(iconUrl, "file://"), Equals, true, Commentf("%q", iconUrl))
+	path, err := url.QueryUnescape(strings.TrimPrefix(iconUrl, "file://"))
+	c.Assert(err, IsNil)
+	return path
+}
+
+func (s *S) avatarIndexPath() string {
+	return filepath.Join(s.cacheDir, cmdName, "avatars", "index.json")
+}
+
+func (s *S) TestAvatarCacheGet(c *C) {
+	server := newAvatarServer()
+	defer server.Close()
+	cache := &AvatarCache{MaxImageSize: 100, RevalidateAfter: time.Hour}
+
+	icon := cache.Get(server.URL + "/alice.png")
+	path := avatarPath(c, icon)
+	c.Check(strings.HasPrefix(path, s.cacheDir), Equals, true)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(string(data), Equals, "/alice.png")
+
+	// The local copy is used without asking the server again
+	c.Check(cache.Get(server.URL+"/alice.png"), Equals, icon)
+	c.Check(server.requests, Equals, 1)
+
+	c.Check(cache.Get(""), Equals, "")
+}
+
+func (s *S) TestAvatarCacheRevalidate(c *C) {
+	server := newAvatarServer()
+	defer server.Close()
+	cache := &AvatarCache{MaxImageSize: 100}
+
+	icon := cache.Get(server.URL + "/alice.png")
+	c.Check(cache.Get(server.URL+"/alice.png"), Equals, icon)
+	c.Check(server.requests, Equals, 2)
+	c.Check(server.revalidated, Equals, 1)
+
+	// Failures fall back to the old copy
+	server.Close()
+	c.Check(cache.Get(server.URL+"/alice.png"), Equals, icon)
+}
+
+func (s *S) TestAvatarCacheRejected(c *C) {
+	server := newAvatarServer()
+	defer server.Close()
+	cache := &AvatarCache{MaxImageSize: 5}
+
+	// Too large
+	c.Check(cache.Get(server.URL+"/alice.png"), Equals, "")
+	// Not an image
+	cache.MaxImageSize = 100
+	c.Check(cache.Get(server.URL+"/page"), Equals, "")
+	// Unreachable
+	c.Check(cache.Get("http://127.0.0.1:0/none.png"), Equals, "")
+}
+
+func (s *S) TestAvatarCacheEviction(c *C) {
+	server := newAvatarServer()
+	defer server.Close()
+	// Each image is 6 bytes, there's room for two
+	cache := &AvatarCache{MaxImageSize: 100, MaxTotalSize: 12, RevalidateAfter: time.Hour}
+
+	a := avatarPath(c, cache.Get(server.URL+"/a.png"))
+	b := avatarPath(c, cache.Get(server.URL+"/b.png"))
+	// Only the order of use matters, which is tracked in seconds
+	index := loadAvatarIndex(s.avatarIndexPath())
+	index[server.URL+"/a.png"].Used -= 10
+	index[server.URL+"/b.png"].Used -= 5
+	index.save(s.avatarIndexPath())
+
+	third := avatarPath(c, cache.Get(server.URL+"/c.png"))
+	_, err := os.Stat(a)
+	c.Check(os.IsNotExist(err), Equals, true)
+	_, err = os.Stat(b)
+	c.Check(err, IsNil)
+	_, err = os.Stat(third)
+	c.Check(err, IsNil)
+
+	index = loadAvatarIndex(s.avatarIndexPath())
+	c.Check(index, HasLen, 2)
+	_, ok := index[server.URL+"/a.png"]
+	c.Check(ok, Equals, false)
+}
+
+func (s *S) TestFileIconUrl(c *C) {
+	c.Check(FileIconUrl("/home/user/My Pictures/a.png"), Equals, "file://%2Fhome%2Fuser%2FMy+Pictures%2Fa.png")
+}
@@ -0,0 +1,109 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"fmt"
+	"time"
+)
+
+const budgetOverflowTag = "overflow"
+
+// NotificationBudget limits how intrusive the notifications of a single
+// poll can be, across all of its batches. A zero value means no limit.
+type NotificationBudget struct {
+	// Popups is the maximum number of bubbles shown, including the
+	// summary bubble added when the budget is exceeded.
+	Popups int
+	// Sounds is the maximum number of notifications playing a sound or
+	// vibrating.
+	Sounds int
+}
+
+// DefaultBudget is the budget used by the Ipc unless told otherwise.
+var DefaultBudget = NotificationBudget{Popups: 3, Sounds: 1}
+
+// apply enforces the budget on the notifications, which must be sorted by
+// priority. If there are more popups than allowed, the ones exceeding the
+// budget are turned into a single summary bubble.
+func (b NotificationBudget) apply(notifications []*PushMessage) []*PushMessage {
+	sounds := 0
+	popups := 0
+	for _, n := range notifications {
+		if n.Notification.Sound != "" || n.Notification.Vibrate {
+			if b.Sounds > 0 && sounds >= b.Sounds {
+				n.Notification.Sound = ""
+				n.Notification.Vibrate = false
+			} else {
+				sounds++
+			}
+		}
+		if n.Notification.Card != nil && n.Notification.Card.Popup {
+			popups++
+		}
+	}
+
+	if b.Popups <= 0 || popups <= b.Popups {
+		return notifications
+	}
+
+	// Leave room for the summary
+	allowed := b.Popups - 1
+	count := 0
+	result := make([]*PushMessage, 0, len(notifications)+1)
+	for _, n := range notifications {
+		card := n.Notification.Card
+		if card != nil && card.Popup {
+			if allowed > 0 {
+				allowed--
+			} else {
+				card.Popup = false
+				if !card.Persist {
+					// Nothing would be left to show
+					continue
+				}
+			}
+		}
+		if card != nil && card.Persist {
+			count++
+		}
+		result = append(result, n)
+	}
+	return append(result, budgetSummary(count))
+}
+
+// budgetSummary creates the bubble shown in place of the ones exceeding
+// the budget.
+func budgetSummary(count int) *PushMessage {
+	// TRANSLATORS: the %d refers to the number of notifications received in a single check
+	summary := fmt.Sprintf(NGettext("%d new notification", "%d new notifications", uint64(count)), count)
+	pm := NewStandardPushMessage(summary, "", "", "", time.Now().Unix())
+	pm.Notification.Card.Actions = nil
+	pm.Notification.Card.Persist = false
+	pm.Notification.Sound = ""
+	pm.Notification.Vibrate = false
+	pm.Notification.Tag = budgetOverflowTag
+	return pm
+}
+
+// byPriority implements sort.Interface for []*PushMessageBatch based on
+// the Priority field.
+type byPriority []*PushMessageBatch
+
+func (b byPriority) Len() int           { return len(b) }
+func (b byPriority) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
+func (b byPriority) Less(i, j int) bool { return b[i].Priority < b[j].Priority }
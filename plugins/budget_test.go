@@ -0,0 +1,106 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"fmt"
+
+	. "launchpad.net/gocheck"
+)
+
+func newBudgetTestBatch(tag string, priority, count, limit int) *PushMessageBatch {
+	batch := &PushMessageBatch{
+		Limit:    limit,
+		Tag:      tag,
+		Priority: priority,
+		OverflowHandler: func(msgs []*PushMessage) *PushMessage {
+			return NewStandardPushMessage(tag+" overflow", "", "action", "", 1)
+		},
+	}
+	for i := 0; i < count; i++ {
+		summary := fmt.Sprintf("%s %d", tag, i)
+		batch.Messages = append(batch.Messages, NewStandardPushMessage(summary, "", "action", "", 1))
+	}
+	return batch
+}
+
+type postedNotification struct {
+	Summary string
+	Popup   bool
+	Sound   bool
+	Persist bool
+}
+
+func postedNotifications(notifs []*PushMessage) []postedNotification {
+	posted := make([]postedNotification, len(notifs))
+	for i, n := range notifs {
+		posted[i] = postedNotification{
+			Summary: n.Notification.Card.Summary,
+			Popup:   n.Notification.Card.Popup,
+			Sound:   n.Notification.Sound != "",
+			Persist: n.Notification.Card.Persist,
+		}
+	}
+	return posted
+}
+
+func (s *S) TestBudgetWithinLimits(c *C) {
+	w, buf := newTestIpc()
+	w.Budget = NotificationBudget{Popups: 3, Sounds: 1}
+	w.PostMessages([]*PushMessageBatch{
+		newBudgetTestBatch("mention", PRIORITY_DEFAULT, 1, 2),
+		newBudgetTestBatch("dm", PRIORITY_HIGH, 1, 2),
+	}, PrivacyFull)
+	c.Check(postedNotifications(decodeNotifications(c, buf)), DeepEquals, []postedNotification{
+		{"dm 0", true, true, true},
+		{"mention 0", true, false, true},
+	})
+}
+
+func (s *S) TestBudgetOverflow(c *C) {
+	w, buf := newTestIpc()
+	w.Budget = NotificationBudget{Popups: 2, Sounds: 1}
+	w.PostMessages([]*PushMessageBatch{
+		newBudgetTestBatch("mention", PRIORITY_DEFAULT, 2, 2),
+		newBudgetTestBatch("dm", PRIORITY_HIGH, 3, 2),
+	}, PrivacyFull)
+	c.Check(postedNotifications(decodeNotifications(c, buf)), DeepEquals, []postedNotification{
+		{"dm 0", false, true, true},
+		{"dm 1", false, false, true},
+		{"dm 2", false, false, true},
+		// the dm overflow bubble fits in the budget
+		{"dm overflow", true, false, false},
+		{"mention 0", false, false, true},
+		{"mention 1", false, false, true},
+		{"5 new notifications", true, false, false},
+	})
+}
+
+func (s *S) TestBudgetUnlimited(c *C) {
+	w, buf := newTestIpc()
+	w.Budget = NotificationBudget{}
+	w.PostMessages([]*PushMessageBatch{
+		newBudgetTestBatch("mention", PRIORITY_DEFAULT, 2, 2),
+		newBudgetTestBatch("dm", PRIORITY_HIGH, 2, 2),
+	}, PrivacyFull)
+	c.Check(postedNotifications(decodeNotifications(c, buf)), DeepEquals, []postedNotification{
+		{"dm 0", true, true, true},
+		{"dm 1", true, false, true},
+		{"mention 0", true, true, true},
+		{"mention 1", true, false, true},
+	})
+}
@@ -17,7 +17,10 @@
 package caldav
 
 import (
+	"encoding/xml"
 	"fmt"
+	"strconv"
+	"strings"
 )
 
 // eventList holds a response to call to Calendar.events: list
@@ -41,14 +44,126 @@ func (e event) String() string {
 	return fmt.Sprintf("Id: %s, snippet: '%s'\n", e.Etag, e.Summary)
 }
 
-type errorResp struct {
-	Err struct {
-		Code    uint64 `json:"code"`
-		Message string `json:"message"`
-		Errors  []struct {
-			Domain  string `json:"domain"`
-			Reason  string `json:"reason"`
-			Message string `json:"message"`
-		} `json:"errors"`
-	} `json:"error"`
+// multistatus holds a 207 Multi-Status response, defined in
+// https://tools.ietf.org/html/rfc4918#section-13
+type multistatus struct {
+	XMLName   xml.Name      `xml:"DAV: multistatus"`
+	Responses []davResponse `xml:"DAV: response"`
+	// SyncToken is only set in the responses to sync-collection reports
+	SyncToken string `xml:"DAV: sync-token"`
+}
+
+// davResponse holds the status of a single resource. Resources which
+// could not be found carry a status but no properties.
+type davResponse struct {
+	Href      string     `xml:"DAV: href"`
+	Status    string     `xml:"DAV: status"`
+	Propstats []propstat `xml:"DAV: propstat"`
+}
+
+type propstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	ETag         string `xml:"DAV: getetag"`
+	CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+	CTag         string `xml:"http://calendarserver.org/ns/ getctag"`
+
+	// The properties used by the discovery, see
+	// https://tools.ietf.org/html/rfc6764#section-6
+	CurrentUserPrincipal davHref         `xml:"DAV: current-user-principal"`
+	CalendarHomeSet      davHref         `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+	ResourceType         davResourceType `xml:"DAV: resourcetype"`
+	DisplayName          string          `xml:"DAV: displayname"`
+	CalendarColor        string          `xml:"http://apple.com/ns/ical/ calendar-color"`
+
+	// The scheduling inbox, see https://tools.ietf.org/html/rfc6638#section-2.2
+	ScheduleInboxUrl davHref `xml:"urn:ietf:params:xml:ns:caldav schedule-inbox-URL"`
+}
+
+type davHref struct {
+	Href string `xml:"DAV: href"`
+}
+
+type davResourceType struct {
+	Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+}
+
+// davError holds the precondition which failed in an error response, see
+// https://tools.ietf.org/html/rfc4918#section-16
+type davError struct {
+	XMLName         xml.Name  `xml:"DAV: error"`
+	ValidSyncToken  *struct{} `xml:"DAV: valid-sync-token"`
+	SupportedReport *struct{} `xml:"DAV: supported-report"`
+}
+
+// calendarObject is a resource reported in a multistatus response
+type calendarObject struct {
+	Href string
+	// StatusCode is the status of the resource, or of its properties
+	// if they were found
+	StatusCode   int
+	ETag         string
+	CalendarData string
+}
+
+// objects returns the resources reported in the response
+func (m *multistatus) objects() []calendarObject {
+	objects := make([]calendarObject, 0, len(m.Responses))
+	for _, r := range m.Responses {
+		obj := calendarObject{
+			Href:       strings.TrimSpace(r.Href),
+			StatusCode: parseStatusLine(r.Status),
+		}
+		for _, ps := range r.Propstats {
+			code := parseStatusLine(ps.Status)
+			if code/100 != 2 {
+				// Properties the server doesn't have
+				if obj.StatusCode == 0 {
+					obj.StatusCode = code
+				}
+				continue
+			}
+			obj.StatusCode = code
+			obj.ETag = strings.TrimSpace(ps.Prop.ETag)
+			obj.CalendarData = ps.Prop.CalendarData
+		}
+		objects = append(objects, obj)
+	}
+	return objects
+}
+
+// parseStatusLine returns the code of a status line such as
+// "HTTP/1.1 200 OK", or 0 if it is malformed
+func parseStatusLine(line string) int {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || !strings.HasPrefix(fields[0], "HTTP/") {
+		return 0
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0
+	}
+	return code
+}
+
+// ForbiddenError is returned when the server denies access to a calendar
+type ForbiddenError struct {
+	Url string
+}
+
+func (err *ForbiddenError) Error() string {
+	return fmt.Sprintf("Access to %s is forbidden", err.Url)
+}
+
+// ServerError is returned when the server fails to handle a request
+type ServerError struct {
+	Url        string
+	StatusCode int
+}
+
+func (err *ServerError) Error() string {
+	return fmt.Sprintf("Server error %d for %s", err.StatusCode, err.Url)
 }
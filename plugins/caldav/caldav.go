@@ -18,13 +18,13 @@ package caldav
 
 import (
 	"bytes"
+	"encoding/xml"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
+	"sort"
 	"time"
 
 	"launchpad.net/account-polld/plugins"
@@ -38,10 +38,16 @@ const (
 
 type CalDavPlugin struct {
 	accountId uint
+	config    caldavConfig
+	// newSyncMonitor connects to the sync monitor, returning nil if
+	// it's not available
+	newSyncMonitor func() syncmonitor.SyncMonitor
+	// syncMonitor is kept connected between the polls
+	syncMonitor syncmonitor.SyncMonitor
 }
 
 func New() *CalDavPlugin {
-	return &CalDavPlugin{accountId: 0}
+	return &CalDavPlugin{accountId: 0, newSyncMonitor: syncmonitor.NewSyncMonitor}
 }
 
 func (p *CalDavPlugin) ApplicationId() plugins.ApplicationId {
@@ -58,38 +64,107 @@ func (p *CalDavPlugin) Poll(authData *plugins.AuthData) ([]*plugins.PushMessageB
 	if p.accountId != authData.AccountId {
 		p.accountId = authData.AccountId
 	}
+	defer p.savePersistentData(p.accountId)
+	p.loadPersistentData(p.accountId)
 
 	log.Print("Check calendar changes for account:", p.accountId)
 
-	syncMonitor := syncmonitor.NewSyncMonitor()
-	if syncMonitor == nil {
-		log.Print("Sync monitor not available yet.")
-		return nil, nil
+	if p.syncMonitor == nil {
+		p.syncMonitor = p.newSyncMonitor()
+	}
+	var calendars []string
+	var err error
+	if p.syncMonitor == nil {
+		if authData.ServerUrl == "" {
+			log.Print("Sync monitor not available yet.")
+			return nil, &plugins.SyncError{Kind: plugins.SyncUnavailable}
+		}
+		calendars, err = p.pollStandalone(authData)
+	} else {
+		calendars, err = p.pollSyncMonitor(authData)
+	}
+	// The reminders and invitations are still shown when the calendars
+	// can't be synced
+	syncErr, ok := err.(*plugins.SyncError)
+	if err != nil && !ok {
+		return nil, err
 	}
 
-	state, err := syncMonitor.State()
-	if err != nil {
-		log.Print("Fail to retrieve sync monitor state ", err)
-		return nil, nil
+	var batches []*plugins.PushMessageBatch
+	// The inbox is looked up from a calendar, which must always be the
+	// same one: another could belong to another user sharing it
+	sort.Strings(calendars)
+	var inboxErr error
+	if settings := plugins.LoadInvitationSettings(); settings != nil && len(calendars) > 0 {
+		invitations, err := p.invitations(authData, calendars[0], settings)
+		if err != nil {
+			log.Print("Calendar plugin ", p.accountId, ": cannot load invitations: ", err)
+			inboxErr = err
+		}
+		batches = append(batches, invitations...)
+	}
+
+	if settings := plugins.LoadReminderSettings(); settings != nil {
+		reminders, err := p.reminders(authData, calendars, settings, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, reminders...)
+	}
+	if syncErr != nil {
+		return batches, syncErr
 	}
-	if state != "idle" {
-		log.Print("Sync monitor is not on 'idle' state, try later!")
-		return nil, nil
+	if inboxErr != nil {
+		return batches, inboxErr
 	}
+	return batches, p.config.Health.SyncError()
+}
 
+// pollSyncMonitor asks the sync monitor to sync the calendars which
+// changed, and returns the addresses of all the calendars of the account.
+func (p *CalDavPlugin) pollSyncMonitor(authData *plugins.AuthData) ([]string, error) {
+	syncMonitor := p.syncMonitor
 	calendars, err := syncMonitor.ListCalendarsByAccount(p.accountId)
 	if err != nil {
 		log.Print("Calendar plugin ", p.accountId, ": cannot load calendars: ", err)
-		return nil, nil
+		// Connect again next time, in case the connection was lost
+		syncMonitor.Close()
+		p.syncMonitor = nil
+		return nil, &plugins.SyncError{Kind: plugins.SyncUnavailable, Err: err}
+	}
+	var urls []string
+	for _, calendar := range calendars {
+		urls = append(urls, calendar)
+	}
+	p.config.Health.Prune(urls)
+
+	// Wait for the sync in progress, which may already include the
+	// changes
+	idle, err := syncMonitor.WaitIdle(syncmonitor.IdleTimeout)
+	if err != nil {
+		log.Print("Fail to retrieve sync monitor state ", err)
+		return urls, &plugins.SyncError{Kind: plugins.SyncUnavailable, Err: err}
+	}
+	if !idle {
+		log.Print("Sync monitor still busy after ", syncmonitor.IdleTimeout, ", try later!")
+		return urls, &plugins.SyncError{Kind: plugins.SyncBusy}
 	}
 
 	var calendarsToSync []string
+	// The state of the changed calendars is only stored once their sync
+	// is started, so that the changes are found again if it fails
+	pendingStates := make(map[string]calendarState)
+	// unchanged holds the addresses of the calendars which were checked
+	// and don't need to be synced
+	var unchanged []string
+	now := time.Now()
 	log.Print("Number of calendars for account:", p.accountId, " size:", len(calendars))
 
 	for id, calendar := range calendars {
 		lastSyncDate, err := syncMonitor.LastSyncDate(p.accountId, id)
 		if err != nil {
 			log.Print("\tcalendar: ", id, ", cannot load previous sync date: ", err, ". Try next time.")
+			p.config.Health.Failed(calendar, plugins.SyncUnavailable, err, now)
 			continue
 		} else {
 			log.Print("\tcalendar: ", id, " Url: ", calendar, " last sync date: ", lastSyncDate)
@@ -99,22 +174,23 @@ func (p *CalDavPlugin) Poll(authData *plugins.AuthData) ([]*plugins.PushMessageB
 		needSync = (len(lastSyncDate) == 0)
 
 		if !needSync {
-			resp, err := p.requestChanges(authData, calendar, lastSyncDate)
+			var state calendarState
+			needSync, state, err = p.calendarChanged(authData, calendar, lastSyncDate)
 			if err != nil {
 				log.Print("\tERROR: Fail to query for changes: ", err)
-				continue
-			}
-
-			needSync, err = p.containEvents(resp)
-			if err != nil {
-				log.Print("\tERROR: Fail to parse changes: ", err)
 				if err == plugins.ErrTokenExpired {
 					log.Print("\t\tAbort poll")
 					return nil, err
 				} else {
+					p.config.Health.Failed(calendar, plugins.SyncRequestFailed, err, now)
 					continue
 				}
 			}
+			if needSync {
+				pendingStates[calendar] = state
+			} else {
+				p.config.Calendars[calendar] = state
+			}
 		}
 
 		if needSync {
@@ -122,45 +198,84 @@ func (p *CalDavPlugin) Poll(authData *plugins.AuthData) ([]*plugins.PushMessageB
 			calendarsToSync = append(calendarsToSync, id)
 		} else {
 			log.Print("\tFound no calendar updates for account: ", p.accountId, " calendar: ", id)
+			unchanged = append(unchanged, calendar)
 		}
 	}
 
+	for _, calendar := range unchanged {
+		p.config.Health.Succeeded(calendar, now)
+	}
 	if len(calendarsToSync) > 0 {
+		sort.Strings(calendarsToSync)
 		log.Print("Request account sync")
 		err = syncMonitor.SyncAccount(p.accountId, calendarsToSync)
 		if err != nil {
 			log.Print("ERROR: Fail to start account sync ", p.accountId, " message: ", err)
+			for _, id := range calendarsToSync {
+				p.config.Health.Failed(calendars[id], plugins.SyncRequestFailed, err, now)
+			}
+			return urls, &plugins.SyncError{
+				Kind:      plugins.SyncRequestFailed,
+				Calendars: calendarsToSync,
+				Err:       err,
+			}
+		}
+		for calendar, state := range pendingStates {
+			p.config.Calendars[calendar] = state
+		}
+		for _, id := range calendarsToSync {
+			p.config.Health.Succeeded(calendars[id], now)
 		}
 	}
 
-	return nil, nil
+	return urls, nil
+}
+
+// parseChangesResponse decodes the multistatus response to a calendar
+// query and returns the calendar objects it reports.
+func (p *CalDavPlugin) parseChangesResponse(calendar string, resp *http.Response) ([]calendarObject, error) {
+	ms, err := p.parseMultistatus(calendar, resp)
+	if err != nil {
+		return nil, err
+	}
+	return ms.objects(), nil
 }
 
-func (p *CalDavPlugin) containEvents(resp *http.Response) (bool, error) {
+// parseMultistatus decodes a multistatus response, or returns the error
+// matching the status of the response.
+func (p *CalDavPlugin) parseMultistatus(calendar string, resp *http.Response) (*multistatus, error) {
 	defer resp.Body.Close()
-	log.Print("RESPONSE CODE ----:", resp.StatusCode)
 
-	if resp.StatusCode != 207 {
-		var errResp errorResp
-		log.Print("Invalid response:", errResp.Err.Code)
-		return false, nil
-	} else {
-		data, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return false, err
-		}
-		fmt.Printf("DATA: %s", data)
-		return strings.Contains(string(data), "BEGIN:VEVENT"), nil
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return nil, plugins.ErrTokenExpired
+	case resp.StatusCode == http.StatusForbidden:
+		return nil, &ForbiddenError{Url: calendar}
+	case resp.StatusCode >= http.StatusInternalServerError:
+		return nil, &ServerError{Url: calendar, StatusCode: resp.StatusCode}
+	case resp.StatusCode != http.StatusMultiStatus:
+		return nil, fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+
+	var ms multistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
 	}
+	return &ms, nil
+}
 
-	return false, nil
+// hasChanges tells whether any calendar object was found by the query
+func hasChanges(objects []calendarObject) bool {
+	for _, obj := range objects {
+		if obj.StatusCode/100 == 2 {
+			log.Print("\t\tchanged: ", obj.Href, " etag: ", obj.ETag)
+			return true
+		}
+	}
+	return false
 }
 
 func (p *CalDavPlugin) requestChanges(authData *plugins.AuthData, calendar string, lastSyncDate string) (*http.Response, error) {
-	u, err := url.Parse(calendar)
-	if err != nil {
-		return nil, err
-	}
 	startDate, err := time.Parse(time.RFC3339, lastSyncDate)
 	if err != nil {
 		log.Print("Fail to parse date: ", lastSyncDate)
@@ -191,14 +306,60 @@ func (p *CalDavPlugin) requestChanges(authData *plugins.AuthData, calendar strin
 	query += "</c:filter>\n"
 	query += "</c:calendar-query>\n"
 	log.Print("Query: ", query)
-	req, err := http.NewRequest("REPORT", u.String(), bytes.NewBufferString(query))
+	return p.davRequest(authData, "REPORT", calendar, "1", query)
+}
+
+// maxRedirects is the number of redirections followed by davRequest
+const maxRedirects = 5
+
+// davClient doesn't follow the redirections, since the WebDAV requests
+// must be repeated with the same method and body.
+var davClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// davRequest sends a WebDAV request with an XML body to the calendar,
+// following the redirections.
+func (p *CalDavPlugin) davRequest(authData *plugins.AuthData, method, calendar, depth, body string) (*http.Response, error) {
+	u, err := url.Parse(calendar)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Depth", "1")
-	req.Header.Set("Prefer", "return-minimal")
-	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
-	req.SetBasicAuth(authData.UserName, authData.Secret)
+	host := u.Host
+	for redirects := 0; ; redirects++ {
+		req, err := http.NewRequest(method, u.String(), bytes.NewBufferString(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Depth", depth)
+		req.Header.Set("Prefer", "return-minimal")
+		req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+		// The credentials are not sent to other hosts
+		if u.Host == host {
+			req.SetBasicAuth(authData.UserName, authData.Secret)
+		}
 
-	return http.DefaultClient.Do(req)
+		resp, err := davClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+			http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return resp, nil
+		}
+		location, err := resp.Location()
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if redirects == maxRedirects {
+			return nil, fmt.Errorf("too many redirections from %s", calendar)
+		}
+		log.Print("\tredirected to ", location)
+		u = location
+	}
 }
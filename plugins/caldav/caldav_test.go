@@ -0,0 +1,182 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+type S struct{}
+
+func init() {
+	Suite(S{})
+}
+
+func TestAll(t *testing.T) {
+	TestingT(t)
+}
+
+func (s S) SetUpTest(c *C) {
+	setConfigDir(c.MkDir())
+	dataDir := c.MkDir()
+	plugins.XdgDataFind = func(p string) (string, error) {
+		p = filepath.Join(dataDir, p)
+		_, err := os.Stat(p)
+		return p, err
+	}
+	plugins.XdgDataEnsure = func(p string) (string, error) {
+		p = filepath.Join(dataDir, p)
+		return p, os.MkdirAll(filepath.Dir(p), 0700)
+	}
+}
+
+// setConfigDir makes the plugins read their configuration from dir
+func setConfigDir(dir string) {
+	plugins.XdgConfigFind = func(p string) (string, error) {
+		p = filepath.Join(dir, p)
+		_, err := os.Stat(p)
+		return p, err
+	}
+}
+
+// closeWraper adds a dummy Close() method to a reader
+type closeWrapper struct {
+	io.Reader
+}
+
+func (r closeWrapper) Close() error {
+	return nil
+}
+
+const calendar = "https://dav.example.com/calendars/user/home/"
+
+const multistatusBody = `<?xml version="1.0" encoding="utf-8" ?>
+<d:multistatus xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav">
+  <d:response>
+    <d:href>/calendars/user/home/meeting.ics</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:getetag>"2134-314"</d:getetag>
+        <cal:calendar-data>BEGIN:VCALENDAR
+VERSION:2.0
+BEGIN:VEVENT
+UID:meeting
+SUMMARY:Meeting
+END:VEVENT
+END:VCALENDAR
+</cal:calendar-data>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/calendars/user/home/todo.ics</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:getetag>"5678"</d:getetag>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop>
+        <cal:calendar-data/>
+      </d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/calendars/user/home/deleted.ics</d:href>
+    <d:status>HTTP/1.1 404 Not Found</d:status>
+  </d:response>
+</d:multistatus>`
+
+func response(status int, body string) *http.Response {
+	return &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Body:       closeWrapper{bytes.NewReader([]byte(body))},
+	}
+}
+
+func (s S) TestParseChangesResponse(c *C) {
+	p := New()
+	objects, err := p.parseChangesResponse(calendar, response(http.StatusMultiStatus, multistatusBody))
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 3)
+
+	c.Check(objects[0].Href, Equals, "/calendars/user/home/meeting.ics")
+	c.Check(objects[0].StatusCode, Equals, http.StatusOK)
+	c.Check(objects[0].ETag, Equals, `"2134-314"`)
+	c.Check(objects[0].CalendarData, Matches, "(?s)BEGIN:VCALENDAR.*SUMMARY:Meeting.*")
+
+	// Only the properties which were found are used
+	c.Check(objects[1].StatusCode, Equals, http.StatusOK)
+	c.Check(objects[1].ETag, Equals, `"5678"`)
+	c.Check(objects[1].CalendarData, Equals, "")
+
+	c.Check(objects[2].Href, Equals, "/calendars/user/home/deleted.ics")
+	c.Check(objects[2].StatusCode, Equals, http.StatusNotFound)
+
+	c.Check(hasChanges(objects), Equals, true)
+	c.Check(hasChanges(objects[2:]), Equals, false)
+}
+
+func (s S) TestParseChangesResponseEmpty(c *C) {
+	p := New()
+	objects, err := p.parseChangesResponse(calendar, response(http.StatusMultiStatus, `<multistatus xmlns="DAV:"/>`))
+	c.Assert(err, IsNil)
+	c.Check(objects, HasLen, 0)
+	c.Check(hasChanges(objects), Equals, false)
+}
+
+func (s S) TestParseChangesResponseErrors(c *C) {
+	p := New()
+
+	_, err := p.parseChangesResponse(calendar, response(http.StatusUnauthorized, ""))
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+
+	_, err = p.parseChangesResponse(calendar, response(http.StatusForbidden, ""))
+	c.Check(err, DeepEquals, &ForbiddenError{Url: calendar})
+
+	_, err = p.parseChangesResponse(calendar, response(http.StatusServiceUnavailable, ""))
+	c.Check(err, DeepEquals, &ServerError{Url: calendar, StatusCode: http.StatusServiceUnavailable})
+
+	_, err = p.parseChangesResponse(calendar, response(http.StatusNotFound, ""))
+	c.Check(err, ErrorMatches, "unexpected response status .*")
+
+	// Malformed multistatus bodies are reported
+	_, err = p.parseChangesResponse(calendar, response(http.StatusMultiStatus, "<html>"))
+	c.Check(err, NotNil)
+	_, err = p.parseChangesResponse(calendar, response(http.StatusMultiStatus, `<d:error xmlns:d="DAV:"/>`))
+	c.Check(err, NotNil)
+}
+
+func (s S) TestParseStatusLine(c *C) {
+	c.Check(parseStatusLine("HTTP/1.1 200 OK"), Equals, 200)
+	c.Check(parseStatusLine(" HTTP/1.1 404 Not Found\n"), Equals, 404)
+	c.Check(parseStatusLine(""), Equals, 0)
+	c.Check(parseStatusLine("200 OK"), Equals, 0)
+}
@@ -0,0 +1,234 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+const (
+	wellKnownPath = "/.well-known/caldav"
+	// discoveryInterval is how long the discovered calendars are used
+	// before looking for them again
+	discoveryInterval = 24 * time.Hour
+)
+
+// calendarInfo describes a calendar collection found by the discovery
+type calendarInfo struct {
+	Url         string `json:"url"`
+	DisplayName string `json:"displayName,omitempty"`
+	Color       string `json:"color,omitempty"`
+}
+
+// pollStandalone checks the calendars found by the discovery, when the
+// sync monitor is not available, and returns their addresses. There is
+// nothing to sync then, so the changes are only tracked.
+func (p *CalDavPlugin) pollStandalone(authData *plugins.AuthData) ([]string, error) {
+	calendars, err := p.calendars(authData)
+	if err != nil {
+		log.Print("Calendar plugin ", p.accountId, ": cannot discover calendars: ", err)
+		if err == plugins.ErrTokenExpired {
+			return nil, err
+		}
+		return nil, &plugins.SyncError{Kind: plugins.SyncUnavailable, Err: err}
+	}
+
+	pollTime := time.Now()
+	now := pollTime.UTC().Format(time.RFC3339)
+	lastPoll := p.config.LastPoll
+	if lastPoll == "" {
+		lastPoll = now
+	}
+	log.Print("Number of discovered calendars for account:", p.accountId, " size:", len(calendars))
+	var urls []string
+	for _, calendar := range calendars {
+		urls = append(urls, calendar.Url)
+		changed, state, err := p.calendarChanged(authData, calendar.Url, lastPoll)
+		if err != nil {
+			log.Print("\tERROR: Fail to query for changes: ", err)
+			if err == plugins.ErrTokenExpired {
+				log.Print("\t\tAbort poll")
+				return nil, err
+			}
+			p.config.Health.Failed(calendar.Url, plugins.SyncRequestFailed, err, pollTime)
+			continue
+		}
+		p.config.Health.Succeeded(calendar.Url, pollTime)
+		p.config.Calendars[calendar.Url] = state
+		if changed {
+			log.Print("\tCalendar changed: ", calendar.Url)
+		}
+	}
+	p.config.Health.Prune(urls)
+	p.config.LastPoll = now
+	return urls, nil
+}
+
+// calendars returns the calendars of the account, running the discovery
+// again if the last results are too old.
+func (p *CalDavPlugin) calendars(authData *plugins.AuthData) ([]calendarInfo, error) {
+	if time.Since(time.Unix(p.config.DiscoveredAt, 0)) < discoveryInterval && p.config.Discovered != nil {
+		return p.config.Discovered, nil
+	}
+	calendars, err := p.discoverCalendars(authData)
+	if err != nil {
+		return nil, err
+	}
+	p.config.Discovered = calendars
+	p.config.DiscoveredAt = time.Now().Unix()
+	return calendars, nil
+}
+
+// discoverCalendars finds the calendars of the user from the address of
+// the server, as described in RFC 6764 and in section 6.2 of RFC 4791.
+func (p *CalDavPlugin) discoverCalendars(authData *plugins.AuthData) ([]calendarInfo, error) {
+	server, err := url.Parse(authData.ServerUrl)
+	if err != nil {
+		return nil, err
+	}
+	if server.Scheme == "" || server.Host == "" {
+		return nil, fmt.Errorf("invalid server address %q", authData.ServerUrl)
+	}
+
+	// The well-known address is tried first; servers which don't
+	// support it are expected to answer at the given address.
+	wellKnown := url.URL{Scheme: server.Scheme, Host: server.Host, Path: wellKnownPath}
+	var principal *url.URL
+	for _, context := range []string{wellKnown.String(), server.String()} {
+		principal, err = p.findHref(authData, context, "<d:current-user-principal />", func(prop davProp) string {
+			return prop.CurrentUserPrincipal.Href
+		})
+		if err == nil {
+			break
+		}
+		if err == plugins.ErrTokenExpired {
+			return nil, err
+		}
+		log.Print("\tno principal at ", context, ": ", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Print("\tprincipal: ", principal)
+
+	home, err := p.findHref(authData, principal.String(), "<c:calendar-home-set />", func(prop davProp) string {
+		return prop.CalendarHomeSet.Href
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Print("\tcalendar home: ", home)
+
+	return p.listCalendars(authData, home.String())
+}
+
+// findHref reads a property holding an address, and returns the address
+// resolved against the one of the resource.
+func (p *CalDavPlugin) findHref(authData *plugins.AuthData, resource, propName string, href func(davProp) string) (*url.URL, error) {
+	query := "<d:propfind xmlns:d=\"DAV:\" xmlns:c=\"urn:ietf:params:xml:ns:caldav\">\n"
+	query += "<d:prop>\n"
+	query += propName + "\n"
+	query += "</d:prop>\n"
+	query += "</d:propfind>\n"
+
+	resp, err := p.davRequest(authData, "PROPFIND", resource, "0", query)
+	if err != nil {
+		return nil, err
+	}
+	base := resp.Request.URL
+	ms, err := p.parseMultistatus(resource, resp)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if parseStatusLine(ps.Status)/100 != 2 {
+				continue
+			}
+			if h := strings.TrimSpace(href(ps.Prop)); h != "" {
+				return base.Parse(h)
+			}
+		}
+	}
+	return nil, errors.New("property not found")
+}
+
+// listCalendars returns the calendar collections in the calendar home
+func (p *CalDavPlugin) listCalendars(authData *plugins.AuthData, home string) ([]calendarInfo, error) {
+	query := "<d:propfind xmlns:d=\"DAV:\" xmlns:ic=\"http://apple.com/ns/ical/\">\n"
+	query += "<d:prop>\n"
+	query += "<d:resourcetype />\n"
+	query += "<d:displayname />\n"
+	query += "<ic:calendar-color />\n"
+	query += "</d:prop>\n"
+	query += "</d:propfind>\n"
+
+	resp, err := p.davRequest(authData, "PROPFIND", home, "1", query)
+	if err != nil {
+		return nil, err
+	}
+	base := resp.Request.URL
+	ms, err := p.parseMultistatus(home, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	calendars := []calendarInfo{}
+	for _, r := range ms.Responses {
+		isCalendar := false
+		var info calendarInfo
+		// The properties which were found can be split among several
+		// propstat elements
+		for _, ps := range r.Propstats {
+			if parseStatusLine(ps.Status)/100 != 2 {
+				continue
+			}
+			if ps.Prop.ResourceType.Calendar != nil {
+				isCalendar = true
+			}
+			if name := strings.TrimSpace(ps.Prop.DisplayName); name != "" {
+				info.DisplayName = name
+			}
+			if color := strings.TrimSpace(ps.Prop.CalendarColor); color != "" {
+				info.Color = color
+			}
+		}
+		if !isCalendar {
+			continue
+		}
+		u, err := base.Parse(strings.TrimSpace(r.Href))
+		if err != nil {
+			log.Print("\tinvalid calendar address ", r.Href, ": ", err)
+			continue
+		}
+		info.Url = u.String()
+		// Colors can include the opacity, as in #RRGGBBAA
+		if len(info.Color) == 9 && info.Color[0] == '#' {
+			info.Color = info.Color[:7]
+		}
+		log.Print("\tfound calendar: ", info.Url, " (", info.DisplayName, ")")
+		calendars = append(calendars, info)
+	}
+	return calendars, nil
+}
@@ -0,0 +1,270 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+const (
+	principalBody = `<d:multistatus xmlns:d="DAV:">
+  <d:response>
+    <d:href>/dav/</d:href>
+    <d:propstat>
+      <d:prop><d:current-user-principal><d:href>/dav/principals/alice/</d:href></d:current-user-principal></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+	homeSetBody = `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:response>
+    <d:href>/dav/principals/alice/</d:href>
+    <d:propstat>
+      <d:prop><c:calendar-home-set><d:href>../../calendars/alice/</d:href></c:calendar-home-set></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+	inboxUrlBody = `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:response>
+    <d:href>/dav/principals/alice/</d:href>
+    <d:propstat>
+      <d:prop><c:schedule-inbox-URL><d:href>/dav/calendars/alice/inbox/</d:href></c:schedule-inbox-URL></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+	calendarsBody = `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:ic="http://apple.com/ns/ical/">
+  <d:response>
+    <d:href>/dav/calendars/alice/</d:href>
+    <d:propstat>
+      <d:prop><d:resourcetype><d:collection/></d:resourcetype></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/dav/calendars/alice/work/</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:resourcetype><d:collection/><c:calendar/></d:resourcetype>
+        <d:displayname>Work</d:displayname>
+        <ic:calendar-color>#FF0000FF</ic:calendar-color>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/dav/calendars/alice/inbox/</d:href>
+    <d:propstat>
+      <d:prop><d:resourcetype><d:collection/><c:schedule-inbox/></d:resourcetype></d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+  <d:response>
+    <d:href>/dav/calendars/alice/personal/</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:resourcetype><d:collection/><c:calendar/></d:resourcetype>
+        <d:displayname>Personal</d:displayname>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+    <d:propstat>
+      <d:prop><ic:calendar-color/></d:prop>
+      <d:status>HTTP/1.1 404 Not Found</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`
+)
+
+// davServer is a fake DAV server for the discovery
+type davServer struct {
+	*httptest.Server
+	// wellKnown tells whether .well-known/caldav redirects to the
+	// context path
+	wellKnown bool
+	// requests records the method and path of the requests
+	requests []string
+	// events is the calendar data of the events returned by the
+	// queries for the upcoming events
+	events []string
+	// inbox is the calendar data of the scheduling messages, keyed by
+	// their etag
+	inbox map[string]string
+	// changed tells whether the sync-collection reports list a change
+	changed bool
+}
+
+func newDavServer() *davServer {
+	s := &davServer{wellKnown: true}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		body := string(data)
+		switch {
+		case r.URL.Path == "/.well-known/caldav" && s.wellKnown:
+			http.Redirect(w, r, "/dav/", http.StatusMovedPermanently)
+		case r.Method != "PROPFIND" && r.Method != "REPORT":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case strings.HasPrefix(r.URL.Path, "/dav/") && strings.Contains(body, "current-user-principal"):
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, principalBody)
+		case r.URL.Path == "/dav/principals/alice/" && strings.Contains(body, "calendar-home-set"):
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, homeSetBody)
+		case r.URL.Path == "/dav/principals/alice/" && strings.Contains(body, "schedule-inbox-URL") && s.inbox != nil:
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, inboxUrlBody)
+		case r.URL.Path == "/dav/calendars/alice/inbox/" && strings.Contains(body, "calendar-query"):
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`)
+			for etag, data := range s.inbox {
+				fmt.Fprintf(w, `<d:response><d:href>%s%s.ics</d:href><d:propstat><d:prop><d:getetag>"%s"</d:getetag><c:calendar-data>`, r.URL.Path, etag, etag)
+				xml.EscapeText(w, []byte(data))
+				fmt.Fprint(w, `</c:calendar-data></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+			}
+			fmt.Fprint(w, `</d:multistatus>`)
+		case r.URL.Path == "/dav/calendars/alice/" && r.Header.Get("Depth") == "1":
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, calendarsBody)
+		case strings.HasPrefix(r.URL.Path, "/dav/calendars/alice/") && strings.Contains(body, "sync-collection"):
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:">`)
+			if s.changed {
+				fmt.Fprintf(w, `<d:response><d:href>%sevent.ics</d:href><d:propstat><d:prop><d:getetag>"1"</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`, r.URL.Path)
+			}
+			fmt.Fprint(w, `<d:sync-token>token-1</d:sync-token></d:multistatus>`)
+		case strings.HasPrefix(r.URL.Path, "/dav/calendars/alice/") && strings.Contains(body, "<c:comp-filter name=\"VEVENT\">\n<c:time-range "):
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`)
+			for i, data := range s.events {
+				fmt.Fprintf(w, `<d:response><d:href>%sevent%d.ics</d:href><d:propstat><d:prop><c:calendar-data>`, r.URL.Path, i)
+				xml.EscapeText(w, []byte(data))
+				fmt.Fprint(w, `</c:calendar-data></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>`)
+			}
+			fmt.Fprint(w, `</d:multistatus>`)
+		case strings.HasPrefix(r.URL.Path, "/dav/calendars/alice/") && strings.Contains(body, "calendar-query"):
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:"/>`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return s
+}
+
+func (s *davServer) authData() *plugins.AuthData {
+	return &plugins.AuthData{UserName: "alice", Secret: "secret", ServerUrl: s.URL}
+}
+
+func (s S) TestDiscoverCalendars(c *C) {
+	server := newDavServer()
+	defer server.Close()
+
+	p := New()
+	calendars, err := p.discoverCalendars(server.authData())
+	c.Assert(err, IsNil)
+	c.Check(calendars, DeepEquals, []calendarInfo{
+		{Url: server.URL + "/dav/calendars/alice/work/", DisplayName: "Work", Color: "#FF0000"},
+		{Url: server.URL + "/dav/calendars/alice/personal/", DisplayName: "Personal"},
+	})
+	// The redirection is followed with the same method
+	c.Check(server.requests, DeepEquals, []string{
+		"PROPFIND /.well-known/caldav",
+		"PROPFIND /dav/",
+		"PROPFIND /dav/principals/alice/",
+		"PROPFIND /dav/calendars/alice/",
+	})
+}
+
+func (s S) TestDiscoverCalendarsWithoutWellKnown(c *C) {
+	server := newDavServer()
+	defer server.Close()
+	server.wellKnown = false
+
+	p := New()
+	authData := server.authData()
+	authData.ServerUrl = server.URL + "/dav/"
+	calendars, err := p.discoverCalendars(authData)
+	c.Assert(err, IsNil)
+	c.Check(calendars, HasLen, 2)
+	c.Check(server.requests[:2], DeepEquals, []string{
+		"PROPFIND /.well-known/caldav",
+		"PROPFIND /dav/",
+	})
+}
+
+func (s S) TestDiscoverCalendarsErrors(c *C) {
+	server := newDavServer()
+	defer server.Close()
+	p := New()
+
+	authData := server.authData()
+	authData.Secret = "wrong"
+	_, err := p.discoverCalendars(authData)
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+
+	// No principal anywhere
+	server.wellKnown = false
+	_, err = p.discoverCalendars(server.authData())
+	c.Check(err, NotNil)
+
+	authData.ServerUrl = "dav.example.com"
+	_, err = p.discoverCalendars(authData)
+	c.Check(err, ErrorMatches, "invalid server address .*")
+}
+
+func (s S) TestPollStandalone(c *C) {
+	server := newDavServer()
+	defer server.Close()
+
+	p := New()
+	p.loadPersistentData(1)
+	calendars, err := p.pollStandalone(server.authData())
+	c.Assert(err, IsNil)
+	c.Check(calendars, DeepEquals, []string{
+		server.URL + "/dav/calendars/alice/work/",
+		server.URL + "/dav/calendars/alice/personal/",
+	})
+	c.Check(p.config.Discovered, HasLen, 2)
+	c.Check(p.config.LastPoll, Not(Equals), "")
+	c.Check(p.config.Calendars, DeepEquals, map[string]calendarState{
+		server.URL + "/dav/calendars/alice/work/":     {SyncToken: "token-1"},
+		server.URL + "/dav/calendars/alice/personal/": {SyncToken: "token-1"},
+	})
+
+	// The discovered calendars are reused
+	server.requests = nil
+	_, err = p.pollStandalone(server.authData())
+	c.Assert(err, IsNil)
+	for _, r := range server.requests {
+		c.Check(r, Matches, "REPORT /dav/calendars/alice/.*")
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"launchpad.net/account-polld/plugins"
+	"launchpad.net/account-polld/plugins/ical"
+	"launchpad.net/account-polld/qtcontact"
+)
+
+const (
+	invitationTag         = "invitation"
+	invitationDispatchUrl = "calendar://eventid=%s"
+	maxInvitations        = 4
+)
+
+// contactAvatar returns the avatar of the contact with the given email
+// address; it is replaced in the tests.
+var contactAvatar = qtcontact.GetAvatar
+
+// inboxState is what is remembered of the scheduling inbox between polls
+type inboxState struct {
+	// Url is the address of the scheduling inbox, empty if the server
+	// doesn't support scheduling
+	Url string `json:"url,omitempty"`
+	// LookedUpAt is when the address was looked for, in seconds since
+	// the epoch
+	LookedUpAt int64 `json:"lookedUpAt,omitempty"`
+	// Listed is set once the inbox was listed, and the messages found
+	// then are not notified
+	Listed bool `json:"listed,omitempty"`
+	// Seen holds the etags of the messages in the inbox which were
+	// already notified, keyed by their address.
+	Seen map[string]string `json:"seen,omitempty"`
+}
+
+// invitations returns the batch of the iTIP messages which arrived in the
+// scheduling inbox (RFC 6638) since the last poll. The address of the
+// inbox is found from the one of a calendar.
+func (p *CalDavPlugin) invitations(authData *plugins.AuthData, calendar string, settings *plugins.InvitationSettings) ([]*plugins.PushMessageBatch, error) {
+	inbox, err := p.scheduleInbox(authData, calendar)
+	if err != nil || inbox == "" {
+		return nil, err
+	}
+
+	messages, err := p.inboxMessages(authData, inbox)
+	if err != nil {
+		log.Print("\tERROR: Fail to list the scheduling inbox: ", err)
+		if err == plugins.ErrTokenExpired {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	// The messages found in the inbox the first time are not new
+	firstTime := !p.config.Inbox.Listed
+	seen := make(map[string]string)
+	var pushMsgs []*plugins.PushMessage
+	for _, obj := range messages {
+		seen[obj.Href] = obj.ETag
+		if etag, ok := p.config.Inbox.Seen[obj.Href]; firstTime || ok && etag == obj.ETag {
+			continue
+		}
+		cal, err := ical.Parse(strings.NewReader(obj.CalendarData))
+		if err != nil {
+			log.Print("\t\tinvalid calendar data in ", obj.Href, ": ", err)
+			continue
+		}
+		if pm := invitationMessage(cal, settings); pm != nil {
+			pushMsgs = append(pushMsgs, pm)
+		}
+	}
+	p.config.Inbox.Seen = seen
+	p.config.Inbox.Listed = true
+
+	if len(pushMsgs) == 0 {
+		return nil, nil
+	}
+	log.Print("New scheduling messages for account ", p.accountId, ": ", len(pushMsgs))
+	return []*plugins.PushMessageBatch{{
+		Messages:        pushMsgs,
+		Limit:           maxInvitations,
+		OverflowHandler: invitationOverflow,
+		Tag:             invitationTag,
+		Priority:        plugins.PRIORITY_HIGH,
+	}}, nil
+}
+
+// scheduleInbox returns the address of the scheduling inbox of the user
+// owning the calendar, looking for it again once a day.
+func (p *CalDavPlugin) scheduleInbox(authData *plugins.AuthData, calendar string) (string, error) {
+	inbox := &p.config.Inbox
+	if time.Since(time.Unix(inbox.LookedUpAt, 0)) < discoveryInterval {
+		return inbox.Url, nil
+	}
+
+	principal, err := p.findHref(authData, calendar, "<d:current-user-principal />", func(prop davProp) string {
+		return prop.CurrentUserPrincipal.Href
+	})
+	var u *url.URL
+	if err == nil {
+		u, err = p.findHref(authData, principal.String(), "<c:schedule-inbox-URL />", func(prop davProp) string {
+			return prop.ScheduleInboxUrl.Href
+		})
+	}
+	if err == plugins.ErrTokenExpired {
+		return "", err
+	}
+	inbox.LookedUpAt = time.Now().Unix()
+	if err != nil {
+		log.Print("\tno scheduling inbox: ", err)
+		inbox.Url = ""
+		return "", nil
+	}
+	if u.String() != inbox.Url {
+		inbox.Url = u.String()
+		inbox.Listed = false
+		inbox.Seen = nil
+	}
+	log.Print("\tscheduling inbox: ", inbox.Url)
+	return inbox.Url, nil
+}
+
+// inboxMessages returns the calendar objects in the scheduling inbox
+func (p *CalDavPlugin) inboxMessages(authData *plugins.AuthData, inbox string) ([]calendarObject, error) {
+	query := "<c:calendar-query xmlns:d=\"DAV:\" xmlns:c=\"urn:ietf:params:xml:ns:caldav\">\n"
+	query += "<d:prop>\n"
+	query += "<d:getetag />\n"
+	query += "<c:calendar-data />\n"
+	query += "</d:prop>\n"
+	query += "<c:filter>\n"
+	query += "<c:comp-filter name=\"VCALENDAR\" />\n"
+	query += "</c:filter>\n"
+	query += "</c:calendar-query>\n"
+
+	resp, err := p.davRequest(authData, "REPORT", inbox, "1", query)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := p.parseChangesResponse(inbox, resp)
+	if err != nil {
+		return nil, err
+	}
+	var messages []calendarObject
+	for _, obj := range objects {
+		if obj.StatusCode/100 == 2 && obj.CalendarData != "" {
+			messages = append(messages, obj)
+		}
+	}
+	return messages, nil
+}
+
+// invitationMessage creates the card for an iTIP message (RFC 5546), or
+// returns nil for the messages which aren't shown.
+func invitationMessage(cal *ical.Component, settings *plugins.InvitationSettings) *plugins.PushMessage {
+	events, err := ical.Events(cal, time.Local)
+	if err != nil || len(events) == 0 {
+		return nil
+	}
+	// All the events of a message are instances of the same one
+	e := events[0]
+	title := e.Summary
+	if title == "" {
+		title = plugins.Gettext("Untitled event")
+	}
+
+	var sender *ical.Address
+	var summary string
+	method := strings.ToUpper(strings.TrimSpace(cal.Text("METHOD")))
+	switch method {
+	case "REQUEST":
+		sender = e.Organizer
+		// TRANSLATORS: the first %s is the organizer of the event, the second its title
+		summary = plugins.Gettext("%s invited you to %s")
+	case "CANCEL":
+		sender = e.Organizer
+		// TRANSLATORS: the first %s is the organizer of the event, the second its title
+		summary = plugins.Gettext("%s cancelled %s")
+	case "REPLY":
+		if len(e.Attendees) == 0 {
+			return nil
+		}
+		sender = &e.Attendees[0]
+		switch sender.PartStat {
+		case "ACCEPTED":
+			// TRANSLATORS: the first %s is an attendee of the event, the second its title
+			summary = plugins.Gettext("%s accepted %s")
+		case "DECLINED":
+			// TRANSLATORS: the first %s is an attendee of the event, the second its title
+			summary = plugins.Gettext("%s declined %s")
+		case "TENTATIVE":
+			// TRANSLATORS: the first %s is an attendee of the event, the second its title
+			summary = plugins.Gettext("%s tentatively accepted %s")
+		default:
+			return nil
+		}
+	default:
+		return nil
+	}
+	if sender == nil {
+		return nil
+	}
+	summary = fmt.Sprintf(summary, sender, title)
+
+	var body string
+	if e.AllDay {
+		body = e.Start.Format("Mon 2 Jan")
+	} else {
+		body = e.Start.Local().Format("Mon 15:04")
+	}
+	if e.Location != "" {
+		body += "\n" + e.Location
+	}
+
+	icon := ""
+	if sender.Email != "" {
+		icon = contactAvatar(sender.Email)
+		// If icon path starts with a path separator, assume local file path.
+		if strings.HasPrefix(icon, string(os.PathSeparator)) {
+			icon = plugins.FileIconUrl(icon)
+		}
+	}
+
+	action := fmt.Sprintf(invitationDispatchUrl, url.QueryEscape(e.Uid))
+	pm := plugins.NewStandardPushMessage(summary, body, action, icon, time.Now().Unix())
+	if method == "REQUEST" && settings.ReplyActions {
+		pm.Notification.Card.Actions = append(pm.Notification.Card.Actions,
+			action+"&reply=accept", action+"&reply=decline")
+	}
+	pm.Fields = &plugins.MessageFields{
+		Senders: []string{sender.Email},
+		Subject: e.Summary,
+	}
+	return pm
+}
+
+func invitationOverflow(pushMsg []*plugins.PushMessage) *plugins.PushMessage {
+	count := len(pushMsg)
+	// TRANSLATORS: the %d refers to the number of invitations and replies to them
+	summary := fmt.Sprintf(plugins.NGettext("%d new calendar message", "%d new calendar messages", uint64(count)), count)
+	return plugins.NewStandardPushMessage(summary, "", "calendar:///", "", time.Now().Unix())
+}
@@ -0,0 +1,171 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"strings"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+	"launchpad.net/account-polld/plugins/ical"
+)
+
+// testMessage returns an iTIP message about the standup meeting
+func testMessage(method, attendee string) string {
+	start := time.Date(2017, 6, 6, 10, 0, 0, 0, time.Local)
+	data := "BEGIN:VCALENDAR\nVERSION:2.0\nMETHOD:" + method + "\nBEGIN:VEVENT\n"
+	data += "UID:standup\nSUMMARY:Standup\nLOCATION:Room 2\n"
+	data += "DTSTART:" + start.UTC().Format(davTimeFormat) + "\n"
+	data += "ORGANIZER;CN=Alice:mailto:alice@example.com\n"
+	data += "ATTENDEE;CN=Bob;" + attendee + ":mailto:bob@example.com\n"
+	return data + "END:VEVENT\nEND:VCALENDAR\n"
+}
+
+func (s S) TestInvitationMessage(c *C) {
+	defer func(avatar func(string) string) { contactAvatar = avatar }(contactAvatar)
+	contactAvatar = func(email string) string {
+		if email == "alice@example.com" {
+			return "/avatars/alice.png"
+		}
+		return ""
+	}
+
+	message := func(data string, settings *plugins.InvitationSettings) *plugins.PushMessage {
+		cal, err := ical.Parse(strings.NewReader(data))
+		c.Assert(err, IsNil)
+		return invitationMessage(cal, settings)
+	}
+
+	pm := message(testMessage("REQUEST", "PARTSTAT=NEEDS-ACTION"), &plugins.InvitationSettings{})
+	c.Assert(pm, NotNil)
+	card := pm.Notification.Card
+	c.Check(card.Summary, Equals, "Alice invited you to Standup")
+	c.Check(card.Body, Equals, "Tue 10:00\nRoom 2")
+	c.Check(card.Icon, Equals, plugins.FileIconUrl("/avatars/alice.png"))
+	c.Check(card.Actions, DeepEquals, []string{"calendar://eventid=standup"})
+	c.Check(pm.Fields.Senders, DeepEquals, []string{"alice@example.com"})
+
+	pm = message(testMessage("REQUEST", "PARTSTAT=NEEDS-ACTION"), &plugins.InvitationSettings{ReplyActions: true})
+	c.Check(pm.Notification.Card.Actions, DeepEquals, []string{
+		"calendar://eventid=standup",
+		"calendar://eventid=standup&reply=accept",
+		"calendar://eventid=standup&reply=decline",
+	})
+
+	pm = message(testMessage("CANCEL", "PARTSTAT=NEEDS-ACTION"), &plugins.InvitationSettings{ReplyActions: true})
+	c.Check(pm.Notification.Card.Summary, Equals, "Alice cancelled Standup")
+	c.Check(pm.Notification.Card.Actions, HasLen, 1)
+
+	pm = message(testMessage("REPLY", "PARTSTAT=DECLINED"), &plugins.InvitationSettings{})
+	c.Check(pm.Notification.Card.Summary, Equals, "Bob declined Standup")
+	c.Check(pm.Notification.Card.Icon, Equals, "")
+	pm = message(testMessage("REPLY", "PARTSTAT=TENTATIVE"), &plugins.InvitationSettings{})
+	c.Check(pm.Notification.Card.Summary, Equals, "Bob tentatively accepted Standup")
+
+	// Replies which don't change the participation and other methods
+	// are not shown
+	c.Check(message(testMessage("REPLY", "PARTSTAT=NEEDS-ACTION"), &plugins.InvitationSettings{}), IsNil)
+	c.Check(message(testMessage("PUBLISH", "PARTSTAT=NEEDS-ACTION"), &plugins.InvitationSettings{}), IsNil)
+}
+
+func (s S) TestInvitations(c *C) {
+	defer func(avatar func(string) string) { contactAvatar = avatar }(contactAvatar)
+	contactAvatar = func(string) string { return "" }
+
+	server := newDavServer()
+	defer server.Close()
+	server.inbox = map[string]string{"old": testMessage("REQUEST", "PARTSTAT=NEEDS-ACTION")}
+
+	p := New()
+	p.loadPersistentData(1)
+	calendar := server.URL + "/dav/calendars/alice/work/"
+	settings := &plugins.InvitationSettings{}
+
+	// The messages already in the inbox are not notified
+	batches, err := p.invitations(server.authData(), calendar, settings)
+	c.Assert(err, IsNil)
+	c.Check(batches, HasLen, 0)
+	c.Check(p.config.Inbox.Url, Equals, server.URL+"/dav/calendars/alice/inbox/")
+	c.Check(p.config.Inbox.Seen, HasLen, 1)
+
+	server.inbox["new"] = testMessage("REPLY", "PARTSTAT=ACCEPTED")
+	p.savePersistentData(1)
+	p.loadPersistentData(1)
+	server.requests = nil
+	batches, err = p.invitations(server.authData(), calendar, settings)
+	c.Assert(err, IsNil)
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Tag, Equals, invitationTag)
+	c.Assert(batches[0].Messages, HasLen, 1)
+	c.Check(batches[0].Messages[0].Notification.Card.Summary, Equals, "Bob accepted Standup")
+	// The address of the inbox is remembered
+	c.Check(server.requests, DeepEquals, []string{"REPORT /dav/calendars/alice/inbox/"})
+
+	// Removed messages are forgotten
+	delete(server.inbox, "old")
+	batches, err = p.invitations(server.authData(), calendar, settings)
+	c.Assert(err, IsNil)
+	c.Check(batches, HasLen, 0)
+	c.Check(p.config.Inbox.Seen, HasLen, 1)
+}
+
+func (s S) TestInvitationsEmptyInbox(c *C) {
+	defer func(avatar func(string) string) { contactAvatar = avatar }(contactAvatar)
+	contactAvatar = func(string) string { return "" }
+
+	server := newDavServer()
+	defer server.Close()
+	server.inbox = map[string]string{}
+
+	p := New()
+	p.loadPersistentData(1)
+	calendar := server.URL + "/dav/calendars/alice/work/"
+	settings := &plugins.InvitationSettings{}
+	batches, err := p.invitations(server.authData(), calendar, settings)
+	c.Assert(err, IsNil)
+	c.Check(batches, HasLen, 0)
+
+	// The first invitation into the empty inbox is new
+	p.savePersistentData(1)
+	p.loadPersistentData(1)
+	server.inbox["new"] = testMessage("REQUEST", "PARTSTAT=NEEDS-ACTION")
+	batches, err = p.invitations(server.authData(), calendar, settings)
+	c.Assert(err, IsNil)
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Messages, HasLen, 1)
+}
+
+func (s S) TestInvitationsWithoutInbox(c *C) {
+	server := newDavServer()
+	defer server.Close()
+
+	p := New()
+	p.loadPersistentData(1)
+	batches, err := p.invitations(server.authData(), server.URL+"/dav/calendars/alice/work/", &plugins.InvitationSettings{})
+	c.Assert(err, IsNil)
+	c.Check(batches, HasLen, 0)
+	c.Check(p.config.Inbox.Url, Equals, "")
+	c.Check(p.config.Inbox.LookedUpAt, Not(Equals), int64(0))
+
+	// The server isn't asked again until the next discovery
+	server.requests = nil
+	_, err = p.invitations(server.authData(), server.URL+"/dav/calendars/alice/work/", &plugins.InvitationSettings{})
+	c.Assert(err, IsNil)
+	c.Check(server.requests, HasLen, 0)
+}
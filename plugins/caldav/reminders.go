@@ -0,0 +1,139 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"launchpad.net/account-polld/plugins"
+	"launchpad.net/account-polld/plugins/ical"
+)
+
+// reminderLookAhead is how far the events are looked for: alarms set
+// further before the event are missed
+const reminderLookAhead = 7 * 24 * time.Hour
+
+const davTimeFormat = "20060102T150405Z"
+
+// reminders returns the batch of the reminders due in the calendars
+func (p *CalDavPlugin) reminders(authData *plugins.AuthData, calendars []string, settings *plugins.ReminderSettings, now time.Time) ([]*plugins.PushMessageBatch, error) {
+	var reminders []plugins.Reminder
+	for _, calendar := range calendars {
+		events, err := p.upcomingEvents(authData, calendar, now, now.Add(reminderLookAhead))
+		if err != nil {
+			log.Print("\tERROR: Fail to load the events of ", calendar, ": ", err)
+			if err == plugins.ErrTokenExpired {
+				return nil, err
+			}
+			continue
+		}
+		for _, e := range events {
+			reminders = append(reminders, eventReminders(e, settings.LeadTime())...)
+		}
+	}
+
+	if p.config.Reminders == nil {
+		p.config.Reminders = make(plugins.RemindersSent)
+	}
+	due := p.config.Reminders.Due(reminders, now, time.Duration(settings.Window)*time.Minute)
+	log.Print("Reminders due for account ", p.accountId, ": ", len(due))
+	if batch := plugins.ReminderBatch(due); batch != nil {
+		return []*plugins.PushMessageBatch{batch}, nil
+	}
+	return nil, nil
+}
+
+// upcomingEvents returns the instances of the events of the calendar
+// starting between start and end.
+func (p *CalDavPlugin) upcomingEvents(authData *plugins.AuthData, calendar string, start, end time.Time) ([]*ical.Event, error) {
+	timeRange := "start=\"" + start.UTC().Format(davTimeFormat) + "\" end=\"" + end.UTC().Format(davTimeFormat) + "\""
+
+	query := "<c:calendar-query xmlns:d=\"DAV:\" xmlns:c=\"urn:ietf:params:xml:ns:caldav\">\n"
+	query += "<d:prop>\n"
+	query += "<c:calendar-data />\n"
+	query += "</d:prop>\n"
+	query += "<c:filter>\n"
+	query += "<c:comp-filter name=\"VCALENDAR\">\n"
+	query += "<c:comp-filter name=\"VEVENT\">\n"
+	query += "<c:time-range " + timeRange + "/>\n"
+	query += "</c:comp-filter>\n"
+	query += "</c:comp-filter>\n"
+	query += "</c:filter>\n"
+	query += "</c:calendar-query>\n"
+
+	resp, err := p.davRequest(authData, "REPORT", calendar, "1", query)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := p.parseChangesResponse(calendar, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []*ical.Event
+	for _, obj := range objects {
+		if obj.StatusCode/100 != 2 || obj.CalendarData == "" {
+			continue
+		}
+		cal, err := ical.Parse(strings.NewReader(obj.CalendarData))
+		if err != nil {
+			log.Print("\t\tinvalid calendar data in ", obj.Href, ": ", err)
+			continue
+		}
+		objEvents, err := ical.Events(cal, time.Local)
+		if err != nil {
+			log.Print("\t\tinvalid event in ", obj.Href, ": ", err)
+			continue
+		}
+		// The recurring events are expanded here, since not all the
+		// servers support expanding them in the query
+		for _, e := range ical.Expand(objEvents, start, end) {
+			if !e.Start.Before(start) {
+				events = append(events, e)
+			}
+		}
+	}
+	return events, nil
+}
+
+// eventReminders returns a reminder for each of the alarms of the event
+// which are shown to the user, or one at leadTime before the start if the
+// event has no alarms.
+func eventReminders(e *ical.Event, leadTime time.Duration) []plugins.Reminder {
+	reminder := plugins.Reminder{
+		InstanceId: plugins.ReminderInstanceId(e.Uid, e.Start),
+		Summary:    e.Summary,
+		Location:   e.Location,
+		Start:      e.Start,
+		AllDay:     e.AllDay,
+	}
+	var reminders []plugins.Reminder
+	for _, alarm := range e.Alarms {
+		if alarm.Action != "DISPLAY" && alarm.Action != "AUDIO" {
+			continue
+		}
+		reminder.Time = alarm.Time(e.Start, e.End)
+		reminders = append(reminders, reminder)
+	}
+	if len(e.Alarms) == 0 && leadTime > 0 {
+		reminder.Time = e.Start.Add(-leadTime)
+		reminders = append(reminders, reminder)
+	}
+	return reminders
+}
@@ -0,0 +1,138 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+	"launchpad.net/account-polld/plugins/ical"
+)
+
+// testEvent returns the calendar data of an event
+func testEvent(uid string, start time.Time, alarms ...string) string {
+	data := "BEGIN:VCALENDAR\nVERSION:2.0\nBEGIN:VEVENT\n"
+	data += "UID:" + uid + "\nSUMMARY:" + uid + "\n"
+	data += "DTSTART:" + start.UTC().Format(davTimeFormat) + "\n"
+	for _, alarm := range alarms {
+		data += fmt.Sprintf("BEGIN:VALARM\nACTION:%s\nTRIGGER:-PT10M\nEND:VALARM\n", alarm)
+	}
+	return data + "END:VEVENT\nEND:VCALENDAR\n"
+}
+
+func (s S) TestEventReminders(c *C) {
+	start := time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC)
+	e := &ical.Event{
+		Uid:     "meeting",
+		Summary: "Meeting",
+		Start:   start,
+		End:     start.Add(time.Hour),
+		Alarms: []ical.Alarm{
+			{Action: "DISPLAY", Trigger: -15 * time.Minute},
+			{Action: "EMAIL", Trigger: -time.Hour},
+			{Action: "AUDIO", Trigger: -5 * time.Minute, RelatedEnd: true},
+		},
+	}
+	reminders := eventReminders(e, 10*time.Minute)
+	c.Assert(reminders, HasLen, 2)
+	c.Check(reminders[0].InstanceId, Equals, plugins.ReminderInstanceId("meeting", start))
+	c.Check(reminders[0].Summary, Equals, "Meeting")
+	c.Check(reminders[0].Time, Equals, start.Add(-15*time.Minute))
+	c.Check(reminders[1].Time, Equals, start.Add(55*time.Minute))
+
+	// Events with only other kinds of alarms are not reminded of
+	e.Alarms = e.Alarms[1:2]
+	c.Check(eventReminders(e, 10*time.Minute), HasLen, 0)
+
+	e.Alarms = nil
+	reminders = eventReminders(e, 10*time.Minute)
+	c.Assert(reminders, HasLen, 1)
+	c.Check(reminders[0].Time, Equals, start.Add(-10*time.Minute))
+	c.Check(eventReminders(e, 0), HasLen, 0)
+}
+
+func (s S) TestReminders(c *C) {
+	server := newDavServer()
+	defer server.Close()
+	now := time.Now().Truncate(time.Second)
+	server.events = []string{
+		testEvent("soon", now.Add(15*time.Minute), "DISPLAY"),
+		testEvent("later", now.Add(3*time.Hour), "DISPLAY"),
+		testEvent("default", now.Add(25*time.Minute)),
+		"BEGIN:VCALENDAR\nBROKEN\n",
+		// the instance of today is reminded of
+		strings.Replace(testEvent("daily", now.Add(10*time.Minute-24*time.Hour)),
+			"END:VEVENT", "RRULE:FREQ=DAILY\nEND:VEVENT", 1),
+	}
+
+	p := New()
+	p.loadPersistentData(1)
+	calendars := []string{server.URL + "/dav/calendars/alice/work/"}
+	settings := &plugins.ReminderSettings{DefaultLeadTime: 30, Window: 15}
+	batches, err := p.reminders(server.authData(), calendars, settings, now)
+	c.Assert(err, IsNil)
+	c.Assert(batches, HasLen, 1)
+	c.Assert(batches[0].Messages, HasLen, 3)
+	c.Check(batches[0].Messages[0].Notification.Card.Summary, Equals, "daily")
+	c.Check(batches[0].Messages[1].Notification.Card.Summary, Equals, "soon")
+	c.Check(batches[0].Messages[2].Notification.Card.Summary, Equals, "default")
+	c.Check(p.config.Reminders, HasLen, 3)
+
+	// The reminders are remembered across polls
+	p.savePersistentData(1)
+	p.loadPersistentData(1)
+	batches, err = p.reminders(server.authData(), calendars, settings, now.Add(time.Minute))
+	c.Assert(err, IsNil)
+	c.Check(batches, HasLen, 0)
+
+	authData := server.authData()
+	authData.Secret = "wrong"
+	_, err = p.reminders(authData, calendars, settings, now)
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+}
+
+func (s S) TestRemindersEachAlarm(c *C) {
+	server := newDavServer()
+	defer server.Close()
+	now := time.Now().Truncate(time.Second)
+	start := now.Add(24*time.Hour + 5*time.Minute)
+	event := testEvent("review", start, "DISPLAY")
+	server.events = []string{strings.Replace(event, "END:VALARM\n",
+		"END:VALARM\nBEGIN:VALARM\nACTION:DISPLAY\nTRIGGER:-P1D\nEND:VALARM\n", 1)}
+
+	p := New()
+	p.loadPersistentData(1)
+	calendars := []string{server.URL + "/dav/calendars/alice/work/"}
+	settings := &plugins.ReminderSettings{Window: 15}
+	batches, err := p.reminders(server.authData(), calendars, settings, now)
+	c.Assert(err, IsNil)
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Messages, HasLen, 1)
+
+	// The alarm ten minutes before the start is shown as well
+	batches, err = p.reminders(server.authData(), calendars, settings, start.Add(-15*time.Minute))
+	c.Assert(err, IsNil)
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Messages, HasLen, 1)
+	batches, err = p.reminders(server.authData(), calendars, settings, start.Add(-5*time.Minute))
+	c.Assert(err, IsNil)
+	c.Check(batches, HasLen, 0)
+}
@@ -0,0 +1,253 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+var (
+	// errSyncNotSupported is returned when the server doesn't implement
+	// the sync-collection report
+	errSyncNotSupported = errors.New("sync-collection not supported")
+	// errInvalidSyncToken is returned when the server doesn't accept the
+	// sync token anymore
+	errInvalidSyncToken = errors.New("invalid sync token")
+)
+
+// caldavConfig holds the state of the calendars, keyed by their URL
+type caldavConfig struct {
+	Calendars map[string]calendarState `json:"calendars"`
+
+	// Discovered holds the calendars found by the discovery, when the
+	// sync monitor is not available, and DiscoveredAt when they were
+	// found, in seconds since the epoch
+	Discovered   []calendarInfo `json:"discovered,omitempty"`
+	DiscoveredAt int64          `json:"discoveredAt,omitempty"`
+	// LastPoll is the time of the last poll without the sync monitor
+	LastPoll string `json:"lastPoll,omitempty"`
+
+	// Reminders holds the event instances which were reminded of
+	Reminders plugins.RemindersSent `json:"reminders,omitempty"`
+	// Inbox is the state of the scheduling inbox
+	Inbox inboxState `json:"inbox"`
+	// Health records the failures to check or sync the calendars,
+	// keyed by their URL
+	Health plugins.CalendarHealth `json:"health,omitempty"`
+}
+
+// calendarState is what is remembered of a calendar between polls
+type calendarState struct {
+	// SyncToken is the token of the last sync-collection report, see
+	// https://tools.ietf.org/html/rfc6578
+	SyncToken string `json:"syncToken,omitempty"`
+	// CTag is the last value of the getctag property, used with the
+	// servers which don't support sync-collection
+	CTag string `json:"ctag,omitempty"`
+	// NoSyncCollection is set when the server doesn't support
+	// sync-collection
+	NoSyncCollection bool `json:"noSyncCollection,omitempty"`
+}
+
+func (p *CalDavPlugin) loadPersistentData(accountId uint) error {
+	p.config = caldavConfig{}
+	err := plugins.FromPersist(pluginName, accountId, &p.config)
+	if p.config.Calendars == nil {
+		p.config.Calendars = make(map[string]calendarState)
+	}
+	if p.config.Health == nil {
+		p.config.Health = make(plugins.CalendarHealth)
+	}
+	return err
+}
+
+func (p *CalDavPlugin) savePersistentData(accountId uint) error {
+	err := plugins.Persist(pluginName, accountId, p.config)
+	if err != nil {
+		log.Print("caldav plugin ", accountId, ": failed to save state: ", err)
+	}
+	return err
+}
+
+// calendarChanged tells whether the calendar changed since the last poll.
+// It prefers the sync-collection report, then the comparison of the
+// getctag property, and falls back to querying the events modified since
+// lastSyncDate. It returns the state to be stored once the changes are
+// synced.
+func (p *CalDavPlugin) calendarChanged(authData *plugins.AuthData, calendar, lastSyncDate string) (bool, calendarState, error) {
+	state := p.config.Calendars[calendar]
+
+	if !state.NoSyncCollection {
+		changed, token, err := p.syncCollection(authData, calendar, state.SyncToken)
+		switch err {
+		case nil:
+			known := state.SyncToken != ""
+			state.SyncToken = token
+			if known {
+				return changed, state, nil
+			}
+			// The first report lists all the events: the changes since
+			// the last sync must be found another way
+		case errInvalidSyncToken:
+			// Start over, assuming that something changed
+			state.SyncToken = ""
+			return true, state, nil
+		case errSyncNotSupported:
+			log.Print("\t\tsync-collection not supported, falling back to getctag")
+			state.NoSyncCollection = true
+			state.SyncToken = ""
+		default:
+			return false, state, err
+		}
+	}
+
+	if state.NoSyncCollection {
+		ctag, err := p.getCTag(authData, calendar)
+		if err != nil {
+			return false, state, err
+		}
+		if ctag != "" {
+			known := state.CTag != ""
+			changed := ctag != state.CTag
+			state.CTag = ctag
+			if known {
+				return changed, state, nil
+			}
+		}
+	}
+
+	resp, err := p.requestChanges(authData, calendar, lastSyncDate)
+	if err != nil {
+		return false, state, err
+	}
+	objects, err := p.parseChangesResponse(calendar, resp)
+	if err != nil {
+		return false, state, err
+	}
+	return hasChanges(objects), state, nil
+}
+
+// syncCollection runs a sync-collection report, and tells whether any
+// event was added, modified or deleted since the state described by
+// token. It returns the new sync token.
+func (p *CalDavPlugin) syncCollection(authData *plugins.AuthData, calendar, token string) (bool, string, error) {
+	var escapedToken bytes.Buffer
+	xml.EscapeText(&escapedToken, []byte(token))
+
+	query := "<d:sync-collection xmlns:d=\"DAV:\">\n"
+	query += "<d:sync-token>" + escapedToken.String() + "</d:sync-token>\n"
+	query += "<d:sync-level>1</d:sync-level>\n"
+	query += "<d:prop>\n"
+	query += "<d:getetag />\n"
+	query += "</d:prop>\n"
+	query += "</d:sync-collection>\n"
+
+	resp, err := p.davRequest(authData, "REPORT", calendar, "0", query)
+	if err != nil {
+		return false, "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		resp.Body.Close()
+		return false, "", errSyncNotSupported
+	case http.StatusForbidden, http.StatusConflict:
+		// The failed precondition is described in the body, see
+		// https://tools.ietf.org/html/rfc6578#section-3.2
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return false, "", err
+		}
+		var davErr davError
+		if xml.Unmarshal(data, &davErr) == nil {
+			if davErr.ValidSyncToken != nil {
+				return false, "", errInvalidSyncToken
+			}
+			if davErr.SupportedReport != nil {
+				return false, "", errSyncNotSupported
+			}
+		}
+		if resp.StatusCode == http.StatusConflict {
+			return false, "", errInvalidSyncToken
+		}
+		return false, "", &ForbiddenError{Url: calendar}
+	}
+
+	ms, err := p.parseMultistatus(calendar, resp)
+	if err != nil {
+		return false, "", err
+	}
+	if ms.SyncToken == "" {
+		return false, "", errSyncNotSupported
+	}
+
+	collectionPath := calendar
+	if u, err := url.Parse(calendar); err == nil {
+		collectionPath = u.Path
+	}
+	changed := false
+	for _, obj := range ms.objects() {
+		if strings.TrimSuffix(obj.Href, "/") == strings.TrimSuffix(collectionPath, "/") {
+			// A 507 status on the collection tells that the results
+			// were truncated, so there are more changes
+			if obj.StatusCode == http.StatusInsufficientStorage {
+				changed = true
+			}
+			continue
+		}
+		// Deleted events are reported with a 404 status
+		log.Print("\t\tchanged: ", obj.Href, " status: ", obj.StatusCode)
+		changed = true
+	}
+	return changed, strings.TrimSpace(ms.SyncToken), nil
+}
+
+// getCTag returns the getctag property of the calendar, or an empty string
+// if the server doesn't support it.
+func (p *CalDavPlugin) getCTag(authData *plugins.AuthData, calendar string) (string, error) {
+	query := "<d:propfind xmlns:d=\"DAV:\" xmlns:cs=\"http://calendarserver.org/ns/\">\n"
+	query += "<d:prop>\n"
+	query += "<cs:getctag />\n"
+	query += "</d:prop>\n"
+	query += "</d:propfind>\n"
+
+	resp, err := p.davRequest(authData, "PROPFIND", calendar, "0", query)
+	if err != nil {
+		return "", err
+	}
+	ms, err := p.parseMultistatus(calendar, resp)
+	if err != nil {
+		return "", err
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if parseStatusLine(ps.Status)/100 == 2 && ps.Prop.CTag != "" {
+				return strings.TrimSpace(ps.Prop.CTag), nil
+			}
+		}
+	}
+	return "", nil
+}
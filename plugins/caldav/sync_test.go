@@ -0,0 +1,228 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+// syncServer is a fake CalDAV server for a single calendar
+type syncServer struct {
+	*httptest.Server
+	// syncStatus is the status of the sync-collection reports, and
+	// syncError the precondition reported with the errors
+	syncStatus int
+	syncError  string
+	// token is the current sync token and changes the hrefs and
+	// statuses reported for the older tokens
+	token   string
+	changes map[string]int
+	// ctag is the getctag property, if supported
+	ctag string
+	// modified tells whether the calendar-query finds events
+	modified bool
+	// requests records the reports and properties requested
+	requests []string
+}
+
+var syncTokenRegexp = regexp.MustCompile("<d:sync-token>(.*)</d:sync-token>")
+
+func newSyncServer() *syncServer {
+	s := &syncServer{syncStatus: http.StatusMultiStatus, token: "token-2"}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body := string(data)
+		switch {
+		case r.Method == "REPORT" && strings.Contains(body, "sync-collection"):
+			s.requests = append(s.requests, "sync-collection")
+			if s.syncStatus != http.StatusMultiStatus {
+				w.WriteHeader(s.syncStatus)
+				if s.syncError != "" {
+					fmt.Fprintf(w, `<d:error xmlns:d="DAV:"><d:%s/></d:error>`, s.syncError)
+				}
+				return
+			}
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:">`)
+			if token := syncTokenRegexp.FindStringSubmatch(body); token[1] != s.token {
+				for href, status := range s.changes {
+					fmt.Fprintf(w, `<d:response><d:href>%s</d:href><d:status>HTTP/1.1 %d %s</d:status></d:response>`,
+						href, status, http.StatusText(status))
+				}
+			}
+			fmt.Fprintf(w, `<d:sync-token>%s</d:sync-token></d:multistatus>`, s.token)
+		case r.Method == "PROPFIND":
+			s.requests = append(s.requests, "getctag")
+			w.WriteHeader(http.StatusMultiStatus)
+			if s.ctag == "" {
+				fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/"><d:response><d:href>/cal/</d:href>
+<d:propstat><d:prop><cs:getctag/></d:prop><d:status>HTTP/1.1 404 Not Found</d:status></d:propstat></d:response></d:multistatus>`)
+				return
+			}
+			fmt.Fprintf(w, `<d:multistatus xmlns:d="DAV:" xmlns:cs="http://calendarserver.org/ns/"><d:response><d:href>/cal/</d:href>
+<d:propstat><d:prop><cs:getctag>%s</cs:getctag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`, s.ctag)
+		case r.Method == "REPORT" && strings.Contains(body, "calendar-query"):
+			s.requests = append(s.requests, "calendar-query")
+			w.WriteHeader(http.StatusMultiStatus)
+			if s.modified {
+				fmt.Fprint(w, multistatusBody)
+			} else {
+				fmt.Fprint(w, `<d:multistatus xmlns:d="DAV:"/>`)
+			}
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	return s
+}
+
+func (s *syncServer) calendar() string {
+	return s.URL + "/cal/"
+}
+
+func newSyncPlugin() *CalDavPlugin {
+	p := New()
+	p.config.Calendars = make(map[string]calendarState)
+	return p
+}
+
+var lastSyncDate = time.Now().Add(-time.Hour).Format(time.RFC3339)
+
+func (s S) TestCalendarChangedSyncCollection(c *C) {
+	server := newSyncServer()
+	defer server.Close()
+	p := newSyncPlugin()
+
+	// The first time the token is fetched, and the changes are queried
+	server.modified = true
+	changed, state, err := p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, true)
+	c.Check(state, Equals, calendarState{SyncToken: "token-2"})
+	c.Check(server.requests, DeepEquals, []string{"sync-collection", "calendar-query"})
+
+	// Then the token is enough
+	p.config.Calendars[server.calendar()] = state
+	server.requests = nil
+	changed, state, err = p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, false)
+	c.Check(state.SyncToken, Equals, "token-2")
+	c.Check(server.requests, DeepEquals, []string{"sync-collection"})
+
+	// Both deletions and modifications are changes
+	for _, status := range []int{http.StatusNotFound, http.StatusOK} {
+		server.token = "token-3"
+		server.changes = map[string]int{"/cal/event.ics": status}
+		changed, state, err = p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+		c.Assert(err, IsNil)
+		c.Check(changed, Equals, true, Commentf("status %d", status))
+		c.Check(state.SyncToken, Equals, "token-3")
+	}
+
+	// Truncated results are changes too
+	server.changes = map[string]int{"/cal/": http.StatusInsufficientStorage}
+	changed, _, err = p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, true)
+}
+
+func (s S) TestCalendarChangedInvalidSyncToken(c *C) {
+	server := newSyncServer()
+	defer server.Close()
+	server.syncStatus = http.StatusForbidden
+	server.syncError = "valid-sync-token"
+	p := newSyncPlugin()
+	p.config.Calendars[server.calendar()] = calendarState{SyncToken: "expired"}
+
+	changed, state, err := p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, true)
+	c.Check(state, Equals, calendarState{})
+}
+
+func (s S) TestCalendarChangedCTag(c *C) {
+	server := newSyncServer()
+	defer server.Close()
+	server.syncStatus = http.StatusForbidden
+	server.syncError = "supported-report"
+	server.ctag = "ctag-1"
+	p := newSyncPlugin()
+
+	changed, state, err := p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, false)
+	c.Check(state, Equals, calendarState{CTag: "ctag-1", NoSyncCollection: true})
+	c.Check(server.requests, DeepEquals, []string{"sync-collection", "getctag", "calendar-query"})
+
+	// sync-collection isn't tried again
+	p.config.Calendars[server.calendar()] = state
+	server.requests = nil
+	server.ctag = "ctag-2"
+	changed, state, err = p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, true)
+	c.Check(state.CTag, Equals, "ctag-2")
+	c.Check(server.requests, DeepEquals, []string{"getctag"})
+}
+
+func (s S) TestCalendarChangedTimeRange(c *C) {
+	server := newSyncServer()
+	defer server.Close()
+	server.syncStatus = http.StatusNotImplemented
+	p := newSyncPlugin()
+	p.config.Calendars[server.calendar()] = calendarState{NoSyncCollection: true}
+
+	changed, _, err := p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, false)
+
+	server.requests = nil
+	server.modified = true
+	changed, _, err = p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Assert(err, IsNil)
+	c.Check(changed, Equals, true)
+	c.Check(server.requests, DeepEquals, []string{"getctag", "calendar-query"})
+}
+
+func (s S) TestCalendarChangedErrors(c *C) {
+	server := newSyncServer()
+	defer server.Close()
+	p := newSyncPlugin()
+
+	server.syncStatus = http.StatusUnauthorized
+	_, _, err := p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+
+	server.syncStatus = http.StatusForbidden
+	_, _, err = p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Check(err, DeepEquals, &ForbiddenError{Url: server.calendar()})
+
+	server.syncStatus = http.StatusBadGateway
+	_, _, err = p.calendarChanged(&plugins.AuthData{}, server.calendar(), lastSyncDate)
+	c.Check(err, FitsTypeOf, &ServerError{})
+}
@@ -0,0 +1,257 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package caldav
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+	"launchpad.net/account-polld/syncmonitor"
+)
+
+func (s S) TestPollSyncMonitor(c *C) {
+	server := newDavServer()
+	defer server.Close()
+	work := server.URL + "/dav/calendars/alice/work/"
+	personal := server.URL + "/dav/calendars/alice/personal/"
+	synced := map[string]string{"work": "2017-06-05T10:00:00Z", "personal": "2017-06-05T10:00:00Z"}
+
+	checks := []struct {
+		name          string
+		state         string
+		finishesSync  bool
+		lastSyncDates map[string]string
+		changed       bool
+		secret        string
+		monitorErr    error
+		syncErr       error
+		err           string
+		calendars     int
+		syncRequests  [][]string
+		// tokens are the sync tokens stored for work and personal
+		tokens [2]string
+		// failures is the number of failures recorded for work
+		failures int
+	}{{
+		name:          "busy",
+		state:         "syncing",
+		lastSyncDates: synced,
+		changed:       true,
+		err:           "Sync service busy",
+		calendars:     2,
+		tokens:        [2]string{"token-0", "token-0"},
+	}, {
+		name:          "busy until the sync finishes",
+		state:         "syncing",
+		finishesSync:  true,
+		lastSyncDates: synced,
+		changed:       true,
+		calendars:     2,
+		syncRequests:  [][]string{{"personal", "work"}},
+		tokens:        [2]string{"token-1", "token-1"},
+	}, {
+		name:          "unchanged",
+		lastSyncDates: synced,
+		calendars:     2,
+		tokens:        [2]string{"token-1", "token-1"},
+	}, {
+		name:          "never synced",
+		lastSyncDates: map[string]string{"work": "2017-06-05T10:00:00Z"},
+		calendars:     2,
+		syncRequests:  [][]string{{"personal"}},
+		tokens:        [2]string{"token-1", "token-0"},
+	}, {
+		name:          "changed",
+		lastSyncDates: synced,
+		changed:       true,
+		calendars:     2,
+		syncRequests:  [][]string{{"personal", "work"}},
+		tokens:        [2]string{"token-1", "token-1"},
+	}, {
+		name:          "sync request failed",
+		lastSyncDates: synced,
+		changed:       true,
+		syncErr:       errors.New("no reply"),
+		err:           "Sync request failed for personal, work: no reply",
+		calendars:     2,
+		tokens:        [2]string{"token-0", "token-0"},
+		failures:      1,
+	}, {
+		name:          "token expired",
+		lastSyncDates: synced,
+		secret:        "wrong",
+		err:           "Token expired",
+		tokens:        [2]string{"token-0", "token-0"},
+	}, {
+		name:       "unavailable",
+		monitorErr: errors.New("service unknown"),
+		err:        "Sync service unavailable: service unknown",
+		tokens:     [2]string{"token-0", "token-0"},
+	}}
+	for _, check := range checks {
+		comment := Commentf(check.name)
+		server.changed = check.changed
+		monitor := &syncmonitor.Fake{
+			Calendars:     map[uint]map[string]string{1: {"work": work, "personal": personal}},
+			LastSyncDates: check.lastSyncDates,
+			CurrentState:  check.state,
+			FinishesSync:  check.finishesSync,
+			Err:           check.monitorErr,
+			SyncErr:       check.syncErr,
+		}
+		authData := server.authData()
+		if check.secret != "" {
+			authData.Secret = check.secret
+		}
+
+		p := New()
+		p.accountId = 1
+		p.loadPersistentData(1)
+		p.config.Calendars[work] = calendarState{SyncToken: "token-0"}
+		p.config.Calendars[personal] = calendarState{SyncToken: "token-0"}
+		p.syncMonitor = monitor
+		calendars, err := p.pollSyncMonitor(authData)
+		if check.err == "" {
+			c.Check(err, IsNil, comment)
+		} else {
+			c.Check(err, ErrorMatches, check.err, comment)
+		}
+		c.Check(calendars, HasLen, check.calendars, comment)
+		c.Check(monitor.SyncRequests, DeepEquals, check.syncRequests, comment)
+		c.Check(p.config.Calendars[work].SyncToken, Equals, check.tokens[0], comment)
+		c.Check(p.config.Calendars[personal].SyncToken, Equals, check.tokens[1], comment)
+		c.Check(p.config.Health[work].Failures, Equals, check.failures, comment)
+		// The connection is only dropped when the service can't be
+		// reached
+		c.Check(monitor.Closed, Equals, check.monitorErr != nil, comment)
+		c.Check(p.syncMonitor == nil, Equals, check.monitorErr != nil, comment)
+	}
+}
+
+func (s S) TestPollWithSyncMonitor(c *C) {
+	server := newDavServer()
+	defer server.Close()
+	monitor := &syncmonitor.Fake{
+		Calendars: map[uint]map[string]string{1: {"work": server.URL + "/dav/calendars/alice/work/"}},
+	}
+
+	p := New()
+	p.newSyncMonitor = func() syncmonitor.SyncMonitor { return monitor }
+	authData := server.authData()
+	authData.AccountId = 1
+	_, err := p.Poll(authData)
+	c.Assert(err, IsNil)
+	c.Check(monitor.SyncRequests, DeepEquals, [][]string{{"work"}})
+
+	// The same connection is used for the next polls
+	p.newSyncMonitor = func() syncmonitor.SyncMonitor {
+		c.Error("connected again")
+		return nil
+	}
+	_, err = p.Poll(authData)
+	c.Assert(err, IsNil)
+	c.Check(p.syncMonitor, Equals, monitor)
+}
+
+func (s S) TestPollReportsFailingCalendars(c *C) {
+	server := newDavServer()
+	defer server.Close()
+	missing := server.URL + "/missing/"
+	monitor := &syncmonitor.Fake{
+		Calendars:     map[uint]map[string]string{1: {"work": server.URL + "/dav/calendars/alice/work/", "missing": missing}},
+		LastSyncDates: map[string]string{"work": "2017-06-05T10:00:00Z", "missing": "2017-06-05T10:00:00Z"},
+	}
+
+	p := New()
+	p.newSyncMonitor = func() syncmonitor.SyncMonitor { return monitor }
+	authData := server.authData()
+	authData.AccountId = 1
+	for i := 1; i < plugins.RepeatedFailures; i++ {
+		_, err := p.Poll(authData)
+		c.Assert(err, IsNil)
+	}
+	_, err := p.Poll(authData)
+	c.Assert(err, FitsTypeOf, &plugins.SyncError{})
+	syncErr := err.(*plugins.SyncError)
+	c.Check(syncErr.Kind, Equals, plugins.SyncRequestFailed)
+	c.Check(syncErr.Calendars, DeepEquals, []string{missing})
+	c.Check(p.config.Health[missing].Failures, Equals, plugins.RepeatedFailures)
+	c.Check(p.config.Health[server.URL+"/dav/calendars/alice/work/"].Failures, Equals, 0)
+
+	// The failures are forgotten once the calendar works again
+	delete(monitor.Calendars[1], "missing")
+	monitor.Calendars[1]["personal"] = server.URL + "/dav/calendars/alice/personal/"
+	_, err = p.Poll(authData)
+	c.Check(err, IsNil)
+	c.Check(p.config.Health, HasLen, 2)
+}
+
+func (s S) TestPollInvitationsFailure(c *C) {
+	configDir := c.MkDir()
+	setConfigDir(configDir)
+	c.Assert(os.MkdirAll(filepath.Join(configDir, "account-polld"), 0700), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(configDir, "account-polld", "invitations.json"), []byte("{}"), 0600), IsNil)
+
+	server := newDavServer()
+	defer server.Close()
+	monitor := &syncmonitor.Fake{
+		Calendars:    map[uint]map[string]string{1: {"work": server.URL + "/dav/calendars/alice/work/"}},
+		CurrentState: "busy",
+	}
+
+	p := New()
+	p.newSyncMonitor = func() syncmonitor.SyncMonitor { return monitor }
+	authData := server.authData()
+	authData.AccountId = 1
+	authData.Secret = "wrong"
+	// The sync error is still reported when the inbox can't be read
+	_, err := p.Poll(authData)
+	c.Assert(err, FitsTypeOf, &plugins.SyncError{})
+	c.Check(err.(*plugins.SyncError).Kind, Equals, plugins.SyncBusy)
+
+	monitor.CurrentState = ""
+	monitor.LastSyncDates = map[string]string{}
+	_, err = p.Poll(authData)
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+}
+
+func (s S) TestPollWithoutSyncMonitor(c *C) {
+	server := newDavServer()
+	defer server.Close()
+
+	p := New()
+	p.newSyncMonitor = func() syncmonitor.SyncMonitor { return nil }
+	authData := server.authData()
+	authData.AccountId = 1
+	authData.ServerUrl = ""
+	batches, err := p.Poll(authData)
+	c.Check(batches, HasLen, 0)
+	c.Assert(err, FitsTypeOf, &plugins.SyncError{})
+	c.Check(err.(*plugins.SyncError).Kind, Equals, plugins.SyncUnavailable)
+
+	// Without the sync monitor, the calendars must be discovered
+	server.wellKnown = false
+	_, err = p.Poll(server.authData())
+	c.Assert(err, FitsTypeOf, &plugins.SyncError{})
+	c.Check(err.(*plugins.SyncError).Kind, Equals, plugins.SyncUnavailable)
+	c.Check(err.(*plugins.SyncError).Err, NotNil)
+}
@@ -0,0 +1,147 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	changesConfigName = "calendar-changes"
+	changeTag         = "change"
+	changeDispatchUrl = "calendar://eventid=%s"
+	maxChanges        = 3
+)
+
+// ChangeSettings enables the cards naming the calendar events which were
+// created, updated or cancelled on the server. Example configuration:
+//
+//	{
+//	  "notify": true
+//	}
+type ChangeSettings struct {
+	Notify bool `json:"notify"`
+}
+
+// LoadChangeSettings reads the change settings from the configuration
+// file; nil is returned if the change cards are disabled.
+func LoadChangeSettings() *ChangeSettings {
+	var s ChangeSettings
+	if err := loadConfig(changesConfigName, &s); err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("Cannot load calendar change settings: ", err)
+		}
+		return nil
+	}
+	if !s.Notify {
+		return nil
+	}
+	return &s
+}
+
+// ChangeKind tells how an event changed
+type ChangeKind int
+
+const (
+	EventCreated ChangeKind = iota
+	EventUpdated
+	EventCancelled
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case EventCreated:
+		return "created"
+	case EventUpdated:
+		return "updated"
+	case EventCancelled:
+		return "cancelled"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// EventChange describes an event which changed since the last poll
+type EventChange struct {
+	// Calendar is the id of the calendar holding the event
+	Calendar string
+	// EventId identifies the event in the calendar
+	EventId string
+	Kind    ChangeKind
+	Summary string
+	// Start is zero when it's unknown, as for the cancelled events
+	Start  time.Time
+	AllDay bool
+}
+
+// ChangeBatch creates the batch of the cards naming the changed events,
+// or returns nil if there are no changes.
+func ChangeBatch(changes []EventChange) *PushMessageBatch {
+	if len(changes) == 0 {
+		return nil
+	}
+	messages := make([]*PushMessage, len(changes))
+	for i, change := range changes {
+		messages[i] = changeMessage(change)
+	}
+	return &PushMessageBatch{
+		Messages:        messages,
+		Limit:           maxChanges,
+		OverflowHandler: changeOverflow,
+		Tag:             changeTag,
+		Priority:        PRIORITY_DEFAULT,
+	}
+}
+
+func changeMessage(change EventChange) *PushMessage {
+	title := change.Summary
+	if title == "" {
+		title = Gettext("Untitled event")
+	}
+	var summary string
+	switch change.Kind {
+	case EventCreated:
+		// TRANSLATORS: the %s is the title of an event added to the calendar
+		summary = fmt.Sprintf(Gettext("New event: %s"), title)
+	case EventCancelled:
+		// TRANSLATORS: the %s is the title of an event removed from the calendar
+		summary = fmt.Sprintf(Gettext("Event cancelled: %s"), title)
+	default:
+		// TRANSLATORS: the %s is the title of an event modified in the calendar
+		summary = fmt.Sprintf(Gettext("Event changed: %s"), title)
+	}
+	var body string
+	switch {
+	case change.Start.IsZero():
+	case change.AllDay:
+		body = change.Start.Format("Mon 2 Jan")
+	default:
+		body = change.Start.Local().Format("Mon 2 Jan 15:04")
+	}
+	action := fmt.Sprintf(changeDispatchUrl, url.QueryEscape(change.EventId))
+	return NewStandardPushMessage(summary, body, action, "", time.Now().Unix())
+}
+
+func changeOverflow(pushMsg []*PushMessage) *PushMessage {
+	count := len(pushMsg)
+	// TRANSLATORS: the %d refers to the number of events changed in the calendar
+	summary := fmt.Sprintf(NGettext("%d calendar event changed", "%d calendar events changed", uint64(count)), count)
+	return NewStandardPushMessage(summary, "", "calendar:///", "", time.Now().Unix())
+}
@@ -0,0 +1,60 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestChangeSettings(c *C) {
+	c.Check(LoadChangeSettings(), IsNil)
+
+	s.writeConfig(c, changesConfigName, `{"notify": false}`)
+	c.Check(LoadChangeSettings(), IsNil)
+
+	s.writeConfig(c, changesConfigName, `{"notify": true}`)
+	c.Check(LoadChangeSettings(), NotNil)
+}
+
+func (s *S) TestChangeBatch(c *C) {
+	c.Check(ChangeBatch(nil), IsNil)
+
+	start := time.Date(2017, 6, 6, 10, 0, 0, 0, time.Local)
+	batch := ChangeBatch([]EventChange{
+		{EventId: "standup", Kind: EventCreated, Summary: "Standup", Start: start},
+		{EventId: "review", Kind: EventUpdated, Summary: "Review", Start: start, AllDay: true},
+		{EventId: "lunch", Kind: EventCancelled},
+	})
+	c.Assert(batch, NotNil)
+	c.Check(batch.Tag, Equals, changeTag)
+	c.Assert(batch.Messages, HasLen, 3)
+	card := batch.Messages[0].Notification.Card
+	c.Check(card.Summary, Equals, "New event: Standup")
+	c.Check(card.Body, Equals, "Tue 6 Jun 10:00")
+	c.Check(card.Actions, DeepEquals, []string{"calendar://eventid=standup"})
+	card = batch.Messages[1].Notification.Card
+	c.Check(card.Summary, Equals, "Event changed: Review")
+	c.Check(card.Body, Equals, "Tue 6 Jun")
+	card = batch.Messages[2].Notification.Card
+	c.Check(card.Summary, Equals, "Event cancelled: Untitled event")
+	c.Check(card.Body, Equals, "")
+
+	overflow := batch.OverflowHandler(batch.Messages)
+	c.Check(overflow.Notification.Card.Summary, Equals, "3 calendar events changed")
+}
@@ -50,6 +50,8 @@ type message struct {
 	// HistoryId is the ID of the last history record that modified
 	// this message.
 	HistoryId string `json:"historyId"`
+	// LabelIds holds the IDs of the labels applied to this message.
+	LabelIds []string `json:"labelIds"`
 	// Snippet is a short part of the message text. This text is
 	// used for the push message summary.
 	Snippet string `json:"snippet"`
@@ -30,7 +30,6 @@ import (
 
 	"log"
 
-	"launchpad.net/account-polld/gettext"
 	"launchpad.net/account-polld/plugins"
 	"launchpad.net/account-polld/qtcontact"
 )
@@ -119,7 +118,7 @@ func (p *GmailPlugin) Poll(authData *plugins.AuthData) ([]*plugins.PushMessageBa
 		p.reportedIds = reportedIds
 	}
 
-	resp, err := p.requestMessageList(authData.AccessToken)
+	resp, err := p.requestMessageList(authData)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +129,7 @@ func (p *GmailPlugin) Poll(authData *plugins.AuthData) ([]*plugins.PushMessageBa
 
 	// TODO use the batching API defined in https://developers.google.com/gmail/api/guides/batch
 	for i := range messages {
-		resp, err := p.requestMessage(messages[i].Id, authData.AccessToken)
+		resp, err := p.requestMessage(messages[i].Id, authData)
 		if err != nil {
 			return nil, err
 		}
@@ -149,6 +148,7 @@ func (p *GmailPlugin) Poll(authData *plugins.AuthData) ([]*plugins.PushMessageBa
 			Limit:           individualNotificationsLimit,
 			OverflowHandler: p.handleOverflow,
 			Tag:             "dekko",
+			Priority:        plugins.PRIORITY_HIGH,
 		}}, nil
 
 }
@@ -191,28 +191,40 @@ func (p *GmailPlugin) createNotifications(messages []message) ([]*plugins.PushMe
 
 		if emailAddress != nil {
 			avatarPath = qtcontact.GetAvatar(emailAddress.Address)
-			// If icon path starts with a path separator, assume local file path,
-			// encode it and prepend file scheme defined in RFC 1738.
+			// If icon path starts with a path separator, assume local file path.
 			if strings.HasPrefix(avatarPath, string(os.PathSeparator)) {
-				avatarPath = url.QueryEscape(avatarPath)
-				avatarPath = "file://" + avatarPath
+				avatarPath = plugins.FileIconUrl(avatarPath)
 			}
 		}
 
+		var sender string
+		if emailAddress != nil {
+			sender = emailAddress.Address
+		}
+
 		msgStamp := hdr.getTimestamp()
 
-		if _, ok := pushMsgMap[msg.ThreadId]; ok {
+		if pm, ok := pushMsgMap[msg.ThreadId]; ok {
 			// TRANSLATORS: the %s is an appended "from" corresponding to an specific email thread
-			pushMsgMap[msg.ThreadId].Notification.Card.Summary += fmt.Sprintf(gettext.Gettext(", %s"), from)
+			pm.Notification.Card.Summary += fmt.Sprintf(plugins.Gettext(", %s"), from)
+			pm.Fields.Senders = append(pm.Fields.Senders, sender)
 		} else if timestamp.Sub(msgStamp) < timeDelta {
 			// TRANSLATORS: the %s is the "from" header corresponding to a specific email
-			summary := fmt.Sprintf(gettext.Gettext("%s"), from)
+			summary := fmt.Sprintf(plugins.Gettext("%s"), from)
 			// TRANSLATORS: the first %s refers to the email "subject", the second %s refers "from"
-			body := fmt.Sprintf(gettext.Gettext("%s\n%s"), hdr[hdrSUBJECT], msg.Snippet)
+			body := fmt.Sprintf(plugins.Gettext("%s\n%s"), hdr[hdrSUBJECT], msg.Snippet)
 			// fmt with label personal and threadId
 			action := fmt.Sprintf(dekkoDispatchUrl, p.accountId, "INBOX", msg.Id)
 			epoch := hdr.getEpoch()
-			pushMsgMap[msg.ThreadId] = plugins.NewStandardPushMessage(summary, body, action, avatarPath, epoch)
+			pm := plugins.NewStandardPushMessage(summary, body, action, avatarPath, epoch)
+			pm.Fields = &plugins.MessageFields{
+				Senders: []string{sender},
+				Subject: hdr[hdrSUBJECT],
+				Body:    msg.Snippet,
+				Thread:  msg.ThreadId,
+				Labels:  msg.LabelIds,
+			}
+			pushMsgMap[msg.ThreadId] = pm
 		} else {
 			log.Print("gmail plugin ", p.accountId, ": skipping message id ", msg.Id, " with date ", msgStamp, " older than ", timeDelta)
 		}
@@ -229,7 +241,7 @@ func (p *GmailPlugin) handleOverflow(pushMsg []*plugins.PushMessage) *plugins.Pu
 	approxUnreadMessages := len(pushMsg)
 
 	// TRANSLATORS: the %d refers to the number of new email messages.
-	summary := fmt.Sprintf(gettext.Gettext("You have %d new messages"), approxUnreadMessages)
+	summary := fmt.Sprintf(plugins.NGettext("You have %d new message", "You have %d new messages", uint64(approxUnreadMessages)), approxUnreadMessages)
 
 	body := ""
 
@@ -304,7 +316,7 @@ func (p *GmailPlugin) parseMessageResponse(resp *http.Response) (message, error)
 	return msg, nil
 }
 
-func (p *GmailPlugin) requestMessage(id, accessToken string) (*http.Response, error) {
+func (p *GmailPlugin) requestMessage(id string, authData *plugins.AuthData) (*http.Response, error) {
 	u, err := baseUrl.Parse("messages/" + id)
 	if err != nil {
 		return nil, err
@@ -312,7 +324,7 @@ func (p *GmailPlugin) requestMessage(id, accessToken string) (*http.Response, er
 
 	query := u.Query()
 	// only request specific fields
-	query.Add("fields", "snippet,threadId,id,payload/headers")
+	query.Add("fields", "snippet,threadId,id,labelIds,payload/headers")
 	// get the full message to get From and Subject from headers
 	query.Add("format", "full")
 	u.RawQuery = query.Encode()
@@ -321,12 +333,11 @@ func (p *GmailPlugin) requestMessage(id, accessToken string) (*http.Response, er
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	return http.DefaultClient.Do(req)
+	return plugins.GoogleOAuth2.Do(authData, req)
 }
 
-func (p *GmailPlugin) requestMessageList(accessToken string) (*http.Response, error) {
+func (p *GmailPlugin) requestMessageList(authData *plugins.AuthData) (*http.Response, error) {
 	u, err := baseUrl.Parse("messages")
 	if err != nil {
 		return nil, err
@@ -344,7 +355,6 @@ func (p *GmailPlugin) requestMessageList(accessToken string) (*http.Response, er
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	return http.DefaultClient.Do(req)
+	return plugins.GoogleOAuth2.Do(authData, req)
 }
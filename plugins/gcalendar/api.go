@@ -25,16 +25,63 @@ import (
 type eventList struct {
 	// Messages holds a list of message.
 	Events []event `json:"items"`
+	// NextPageToken is set when there are more events to be listed
+	NextPageToken string `json:"nextPageToken"`
+	// NextSyncToken is set on the last page, to list the events
+	// which changed afterwards
+	NextSyncToken string `json:"nextSyncToken"`
 }
 
 // event holds the event data response for a Calendar.event.
 // The full definition of a message is defined in
 // https://developers.google.com/google-apps/calendar/v3/reference/events#resource-representations
 type event struct {
-	// Id is the immutable ID of the message.
+	// Id is the immutable ID of the event.
+	Id   string `json:"id"`
 	Etag string `json:"etag"`
-	// ThreadId is the ID of the thread the message belongs to.
+	// Status is "cancelled" for the deleted events
+	Status  string `json:"status"`
 	Summary string `json:"summary"`
+	// Created and Updated are the RFC 3339 times of the creation and of
+	// the last change of the event
+	Created string    `json:"created"`
+	Updated string    `json:"updated"`
+	Start   eventTime `json:"start"`
+}
+
+// upcomingEventList holds the response to the query for the upcoming
+// events, as in eventList
+type upcomingEventList struct {
+	Events []upcomingEvent `json:"items"`
+	// DefaultReminders are the reminders of the events using the
+	// default ones of the calendar
+	DefaultReminders []eventReminder `json:"defaultReminders"`
+}
+
+// upcomingEvent holds the fields of an event needed for its reminders
+type upcomingEvent struct {
+	Id        string    `json:"id"`
+	Summary   string    `json:"summary"`
+	Location  string    `json:"location"`
+	Status    string    `json:"status"`
+	Start     eventTime `json:"start"`
+	End       eventTime `json:"end"`
+	Reminders struct {
+		UseDefault bool            `json:"useDefault"`
+		Overrides  []eventReminder `json:"overrides"`
+	} `json:"reminders"`
+}
+
+// eventTime is either a date, for the all day events, or a date and time
+type eventTime struct {
+	Date     string `json:"date"`
+	DateTime string `json:"dateTime"`
+}
+
+// eventReminder is a reminder of an event, sent Minutes before its start
+type eventReminder struct {
+	Method  string `json:"method"`
+	Minutes int    `json:"minutes"`
 }
 
 func (e event) String() string {
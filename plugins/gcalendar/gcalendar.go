@@ -17,11 +17,11 @@
 package gcalendar
 
 import (
-	"encoding/json"
 	"log"
-	"net/http"
 	"net/url"
 	"os"
+	"sort"
+	"time"
 
 	"launchpad.net/account-polld/plugins"
 	"launchpad.net/account-polld/syncmonitor"
@@ -36,10 +36,16 @@ var baseUrl, _ = url.Parse("https://www.googleapis.com/calendar/v3/calendars/")
 
 type GCalendarPlugin struct {
 	accountId uint
+	config    gcalendarConfig
+	// newSyncMonitor connects to the sync monitor, returning nil if
+	// it's not available
+	newSyncMonitor func() syncmonitor.SyncMonitor
+	// syncMonitor is kept connected between the polls
+	syncMonitor syncmonitor.SyncMonitor
 }
 
 func New() *GCalendarPlugin {
-	return &GCalendarPlugin{accountId: 0}
+	return &GCalendarPlugin{accountId: 0, newSyncMonitor: syncmonitor.NewSyncMonitor}
 }
 
 func (p *GCalendarPlugin) ApplicationId() plugins.ApplicationId {
@@ -57,63 +63,138 @@ func (p *GCalendarPlugin) Poll(authData *plugins.AuthData) ([]*plugins.PushMessa
 		p.accountId = authData.AccountId
 	}
 
+	defer p.savePersistentData(p.accountId)
+	p.loadPersistentData(p.accountId)
+
 	log.Print("calendar: Check calendar changes for account:", p.accountId)
 
-	syncMonitor := syncmonitor.NewSyncMonitor()
-	if syncMonitor == nil {
+	changeSettings := plugins.LoadChangeSettings()
+	var calendars []string
+	var changes []plugins.EventChange
+	var err error
+	if p.syncMonitor == nil {
+		p.syncMonitor = p.newSyncMonitor()
+	}
+	if p.syncMonitor == nil {
 		log.Print("calendar: Sync monitor not available yet.")
-		return nil, nil
+		// The reminders and the changes can still be shown for the
+		// main calendar
+		calendars = []string{primaryCalendar}
+		if changeSettings != nil {
+			changes, _, err = p.calendarChanges(authData, primaryCalendar)
+			if err == plugins.ErrTokenExpired {
+				return nil, err
+			} else if err != nil {
+				log.Print("calendar: ERROR: Fail to query for changes: ", err)
+			}
+		}
+		err = &plugins.SyncError{Kind: plugins.SyncUnavailable}
+	} else {
+		calendars, changes, err = p.pollSyncMonitor(authData)
+	}
+	// The reminders are still shown when the calendars can't be synced
+	syncErr, ok := err.(*plugins.SyncError)
+	if err != nil && !ok {
+		return nil, err
 	}
 
-	state, err := syncMonitor.State()
-	if err != nil {
-		log.Print("calendar: Fail to retrieve sync monitor state ", err)
-		return nil, nil
+	var batches []*plugins.PushMessageBatch
+	if changeSettings != nil {
+		if batch := plugins.ChangeBatch(changes); batch != nil {
+			batches = append(batches, batch)
+		}
 	}
-	if state != "idle" {
-		log.Print("calendar: Sync monitor is not on 'idle' state, try later!")
-		return nil, nil
+	if settings := plugins.LoadReminderSettings(); settings != nil {
+		reminders, err := p.reminders(authData, calendars, settings, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, reminders...)
 	}
+	if syncErr != nil {
+		return batches, syncErr
+	}
+	return batches, p.config.Health.SyncError()
+}
 
+// pollSyncMonitor asks the sync monitor to sync the calendars which
+// changed, and returns the ids of all the calendars of the account and
+// the events which changed.
+func (p *GCalendarPlugin) pollSyncMonitor(authData *plugins.AuthData) ([]string, []plugins.EventChange, error) {
+	syncMonitor := p.syncMonitor
 	calendars, err := syncMonitor.ListCalendarsByAccount(p.accountId)
 	if err != nil {
 		log.Print("calendar: Calendar plugin ", p.accountId, ": cannot load calendars: ", err)
-		return nil, nil
+		// Connect again next time, in case the connection was lost
+		syncMonitor.Close()
+		p.syncMonitor = nil
+		return nil, nil, &plugins.SyncError{Kind: plugins.SyncUnavailable, Err: err}
+	}
+	var ids []string
+	for id := range calendars {
+		ids = append(ids, id)
+	}
+	p.config.Health.Prune(ids)
+	// Forget the calendars which were removed
+	for id := range p.config.Calendars {
+		if _, ok := calendars[id]; !ok {
+			delete(p.config.Calendars, id)
+		}
+	}
+
+	// Wait for the sync in progress, which may already include the
+	// changes
+	idle, err := syncMonitor.WaitIdle(syncmonitor.IdleTimeout)
+	if err != nil {
+		log.Print("calendar: Fail to retrieve sync monitor state ", err)
+		return ids, nil, &plugins.SyncError{Kind: plugins.SyncUnavailable, Err: err}
+	}
+	if !idle {
+		log.Print("calendar: Sync monitor still busy after ", syncmonitor.IdleTimeout, ", try later!")
+		return ids, nil, &plugins.SyncError{Kind: plugins.SyncBusy}
 	}
 
 	var calendarsToSync []string
+	var changes []plugins.EventChange
+	// The previous state of the changed calendars is restored if their
+	// sync can't be started, so that the changes are found again
+	previousStates := make(map[string]calendarState)
+	// unchanged holds the ids of the calendars which were checked and
+	// don't need to be synced, and failed those whose changes couldn't
+	// be listed
+	var unchanged []string
+	failed := make(map[string]bool)
+	now := time.Now()
 	log.Print("calendar: Number of calendars for account:", p.accountId, " size:", len(calendars))
 
 	for id, calendar := range calendars {
 		lastSyncDate, err := syncMonitor.LastSyncDate(p.accountId, id)
 		if err != nil {
 			log.Print("\tcalendar: ", calendar, ", cannot load previous sync date: ", err, ". Try next time.")
+			p.config.Health.Failed(id, plugins.SyncUnavailable, err, now)
 			continue
 		} else {
 			log.Print("\tcalendar: ", calendar, " Id: ", id, ": last sync date: ", lastSyncDate)
 		}
 
-		var needSync bool
-		needSync = (len(lastSyncDate) == 0)
+		needSync := (len(lastSyncDate) == 0)
 
-		if !needSync {
-			resp, err := p.requestChanges(authData.AccessToken, id, lastSyncDate)
-			if err != nil {
-				log.Print("\tcalendar: ERROR: Fail to query for changes: ", err)
-				continue
+		previousState := p.config.Calendars[id]
+		calendarChanges, full, err := p.calendarChanges(authData, id)
+		if err != nil {
+			log.Print("\tcalendar: ERROR: Fail to query for changes: ", err)
+			if err == plugins.ErrTokenExpired {
+				log.Print("\t\tcalendar: Abort poll")
+				return nil, nil, err
 			}
-
-			messages, err := p.parseChangesResponse(resp)
-			if err != nil {
-				log.Print("\tcalendar: ERROR: Fail to parse changes: ", err)
-				if err == plugins.ErrTokenExpired {
-					log.Print("\t\tcalendar: Abort poll")
-					return nil, err
-				} else {
-					continue
-				}
+			p.config.Health.Failed(id, plugins.SyncRequestFailed, err, now)
+			failed[id] = true
+		} else {
+			needSync = needSync || full || len(calendarChanges) > 0
+			changes = append(changes, calendarChanges...)
+			if full || len(calendarChanges) > 0 {
+				previousStates[id] = previousState
 			}
-			needSync = (len(messages) > 0)
 		}
 
 		if needSync {
@@ -121,72 +202,43 @@ func (p *GCalendarPlugin) Poll(authData *plugins.AuthData) ([]*plugins.PushMessa
 			calendarsToSync = append(calendarsToSync, id)
 		} else {
 			log.Print("\tcalendar: Found no calendar updates for account: ", p.accountId, " calendar: ", calendar)
+			if !failed[id] {
+				unchanged = append(unchanged, id)
+			}
 		}
 	}
 
+	for _, id := range unchanged {
+		p.config.Health.Succeeded(id, now)
+	}
 	if len(calendarsToSync) > 0 {
+		sort.Strings(calendarsToSync)
 		log.Print("calendar: Request account sync")
 		err = syncMonitor.SyncAccount(p.accountId, calendarsToSync)
 		if err != nil {
 			log.Print("calendar: ERROR: Fail to start account sync ", p.accountId, " message: ", err)
+			for id, state := range previousStates {
+				if state.SyncToken == "" {
+					delete(p.config.Calendars, id)
+				} else {
+					p.config.Calendars[id] = state
+				}
+			}
+			for _, id := range calendarsToSync {
+				p.config.Health.Failed(id, plugins.SyncRequestFailed, err, now)
+			}
+			return ids, nil, &plugins.SyncError{
+				Kind:      plugins.SyncRequestFailed,
+				Calendars: calendarsToSync,
+				Err:       err,
+			}
 		}
-	}
-
-	return nil, nil
-}
-
-func (p *GCalendarPlugin) parseChangesResponse(resp *http.Response) ([]event, error) {
-	defer resp.Body.Close()
-	decoder := json.NewDecoder(resp.Body)
-
-	if resp.StatusCode != http.StatusOK {
-		var errResp errorResp
-		log.Print("calendar: Invalid response:", errResp.Err.Code)
-		if err := decoder.Decode(&errResp); err != nil {
-			return nil, err
-		}
-		if errResp.Err.Code == 401 {
-			return nil, plugins.ErrTokenExpired
+		for _, id := range calendarsToSync {
+			if !failed[id] {
+				p.config.Health.Succeeded(id, now)
+			}
 		}
-		return nil, nil
-	}
-
-	var events eventList
-	if err := decoder.Decode(&events); err != nil {
-		log.Print("calendar: Fail to decode")
-		return nil, err
-	}
-
-	for _, ev := range events.Events {
-		log.Print("calendar: Found event: ", ev.Etag, ev.Summary)
-	}
-
-	return events.Events, nil
-}
-
-func (p *GCalendarPlugin) requestChanges(accessToken string, calendar string, lastSyncDate string) (*http.Response, error) {
-	u, err := baseUrl.Parse("")
-	if err != nil {
-		return nil, err
-	}
-	u.Path += calendar + "/events"
-
-	//GET https://www.googleapis.com/calendar/v3/calendars/<calendar>/events?showDeleted=true&singleEvents=true&updatedMin=2016-04-06T10%3A00%3A00.00Z&fields=description%2Citems(description%2Cetag%2Csummary)&key={YOUR_API_KEY}
-	query := u.Query()
-	query.Add("showDeleted", "true")
-	query.Add("singleEvents", "true")
-	query.Add("fields", "description,items(summary,etag)")
-	query.Add("maxResults", "1")
-	if len(lastSyncDate) > 0 {
-		query.Add("updatedMin", lastSyncDate)
-	}
-	u.RawQuery = query.Encode()
-
-	req, err := http.NewRequest("GET", u.String(), nil)
-	if err != nil {
-		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	return http.DefaultClient.Do(req)
+	return ids, changes, nil
 }
@@ -0,0 +1,158 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gcalendar
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+type S struct{}
+
+func init() {
+	Suite(S{})
+}
+
+func TestAll(t *testing.T) {
+	TestingT(t)
+}
+
+func (s S) SetUpTest(c *C) {
+	dataDir := c.MkDir()
+	plugins.XdgDataFind = func(p string) (string, error) {
+		p = filepath.Join(dataDir, p)
+		_, err := os.Stat(p)
+		return p, err
+	}
+	plugins.XdgDataEnsure = func(p string) (string, error) {
+		p = filepath.Join(dataDir, p)
+		return p, os.MkdirAll(filepath.Dir(p), 0700)
+	}
+}
+
+// eventsServer serves a list of upcoming events to the requests made
+// with the "token" access token
+func eventsServer(c *C, events string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"code": 401, "message": "Invalid Credentials"}}`)
+			return
+		}
+		c.Check(r.URL.Path, Equals, "/calendar/v3/calendars/primary/events")
+		c.Check(r.URL.Query().Get("singleEvents"), Equals, "true")
+		c.Check(r.URL.Query().Get("timeMin"), Not(Equals), "")
+		fmt.Fprint(w, events)
+	}))
+}
+
+func (s S) TestReminders(c *C) {
+	now := time.Now().Truncate(time.Second)
+	events := fmt.Sprintf(`{
+  "defaultReminders": [ { "method": "popup", "minutes": 10 } ],
+  "items": [
+    {
+      "id": "default",
+      "summary": "Default reminders",
+      "start": { "dateTime": %q },
+      "reminders": { "useDefault": true }
+    },
+    {
+      "id": "override",
+      "summary": "Overridden reminders",
+      "location": "Room 2",
+      "start": { "dateTime": %q },
+      "reminders": { "overrides": [ { "method": "email", "minutes": 30 }, { "method": "popup", "minutes": 60 } ] }
+    },
+    {
+      "id": "none",
+      "summary": "No reminders",
+      "start": { "dateTime": %q },
+      "reminders": {}
+    },
+    {
+      "id": "cancelled",
+      "status": "cancelled",
+      "start": { "dateTime": %q },
+      "reminders": { "useDefault": true }
+    },
+    {
+      "id": "later",
+      "start": { "dateTime": %q },
+      "reminders": { "useDefault": true }
+    }
+  ]
+}`, now.Add(20*time.Minute).Format(time.RFC3339),
+		now.Add(50*time.Minute).Format(time.RFC3339),
+		now.Add(40*time.Minute).Format(time.RFC3339),
+		now.Add(5*time.Minute).Format(time.RFC3339),
+		now.Add(2*time.Hour).Format(time.RFC3339))
+	server := eventsServer(c, events)
+	defer server.Close()
+	oldBaseUrl := baseUrl
+	defer func() { baseUrl = oldBaseUrl }()
+	baseUrl, _ = url.Parse(server.URL + "/calendar/v3/calendars/")
+
+	p := New()
+	p.loadPersistentData(1)
+	authData := &plugins.AuthData{AccessToken: "token"}
+	settings := &plugins.ReminderSettings{DefaultLeadTime: 30, Window: 15}
+	batches, err := p.reminders(authData, []string{primaryCalendar}, settings, now)
+	c.Assert(err, IsNil)
+	c.Assert(batches, HasLen, 1)
+	messages := batches[0].Messages
+	c.Assert(messages, HasLen, 3)
+	c.Check(messages[0].Notification.Card.Summary, Equals, "Default reminders")
+	c.Check(messages[1].Notification.Card.Summary, Equals, "No reminders")
+	c.Check(messages[2].Notification.Card.Summary, Equals, "Overridden reminders")
+	c.Check(messages[2].Notification.Card.Body, Matches, "(?s).*\nRoom 2")
+
+	// The reminders are only sent once
+	p.savePersistentData(1)
+	p.loadPersistentData(1)
+	batches, err = p.reminders(authData, []string{primaryCalendar}, settings, now)
+	c.Assert(err, IsNil)
+	c.Check(batches, HasLen, 0)
+
+	_, err = p.reminders(&plugins.AuthData{AccessToken: "expired"}, []string{primaryCalendar}, settings, now)
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+}
+
+func (s S) TestEventTime(c *C) {
+	t, allDay, err := eventTime{Date: "2017-06-05"}.parse()
+	c.Assert(err, IsNil)
+	c.Check(allDay, Equals, true)
+	c.Check(t, Equals, time.Date(2017, 6, 5, 0, 0, 0, 0, time.Local))
+
+	t, allDay, err = eventTime{DateTime: "2017-06-05T10:00:00+02:00"}.parse()
+	c.Assert(err, IsNil)
+	c.Check(allDay, Equals, false)
+	c.Check(t.UTC(), Equals, time.Date(2017, 6, 5, 8, 0, 0, 0, time.UTC))
+
+	_, _, err = eventTime{}.parse()
+	c.Check(err, NotNil)
+}
@@ -0,0 +1,158 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gcalendar
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+const (
+	// primaryCalendar is the id of the main calendar of the user
+	primaryCalendar = "primary"
+	// reminderLookAhead is how far the events are looked for: reminders
+	// set further before the event are missed
+	reminderLookAhead = 7 * 24 * time.Hour
+)
+
+// reminders returns the batch of the reminders due in the calendars
+func (p *GCalendarPlugin) reminders(authData *plugins.AuthData, calendars []string, settings *plugins.ReminderSettings, now time.Time) ([]*plugins.PushMessageBatch, error) {
+	var reminders []plugins.Reminder
+	for _, calendar := range calendars {
+		resp, err := p.requestUpcomingEvents(authData, calendar, now, now.Add(reminderLookAhead))
+		if err == nil {
+			var events *upcomingEventList
+			if events, err = p.parseUpcomingEventsResponse(resp); err == nil {
+				reminders = append(reminders, events.reminders(settings.LeadTime())...)
+			}
+		}
+		if err != nil {
+			log.Print("\tcalendar: ERROR: Fail to load the events of ", calendar, ": ", err)
+			if err == plugins.ErrTokenExpired {
+				return nil, err
+			}
+		}
+	}
+
+	due := p.config.Reminders.Due(reminders, now, time.Duration(settings.Window)*time.Minute)
+	log.Print("calendar: Reminders due for account ", p.accountId, ": ", len(due))
+	if batch := plugins.ReminderBatch(due); batch != nil {
+		return []*plugins.PushMessageBatch{batch}, nil
+	}
+	return nil, nil
+}
+
+func (p *GCalendarPlugin) requestUpcomingEvents(authData *plugins.AuthData, calendar string, start, end time.Time) (*http.Response, error) {
+	u, err := baseUrl.Parse("")
+	if err != nil {
+		return nil, err
+	}
+	u.Path += calendar + "/events"
+
+	query := u.Query()
+	query.Add("singleEvents", "true")
+	query.Add("orderBy", "startTime")
+	query.Add("timeMin", start.UTC().Format(time.RFC3339))
+	query.Add("timeMax", end.UTC().Format(time.RFC3339))
+	query.Add("fields", "defaultReminders,items(id,summary,location,status,start,end,reminders)")
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return plugins.GoogleOAuth2.Do(authData, req)
+}
+
+func (p *GCalendarPlugin) parseUpcomingEventsResponse(resp *http.Response) (*upcomingEventList, error) {
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResp
+		if err := decoder.Decode(&errResp); err != nil {
+			return nil, err
+		}
+		if errResp.Err.Code == 401 {
+			return nil, plugins.ErrTokenExpired
+		}
+		return nil, fmt.Errorf("unexpected response status %s: %s", resp.Status, errResp.Err.Message)
+	}
+
+	var events upcomingEventList
+	if err := decoder.Decode(&events); err != nil {
+		return nil, err
+	}
+	return &events, nil
+}
+
+// reminders returns a reminder for each of the popup reminders of the
+// events, or one at leadTime before the start for the events without
+// reminders.
+func (l *upcomingEventList) reminders(leadTime time.Duration) []plugins.Reminder {
+	var reminders []plugins.Reminder
+	for _, e := range l.Events {
+		if e.Status == "cancelled" {
+			continue
+		}
+		start, allDay, err := e.Start.parse()
+		if err != nil {
+			log.Print("\tcalendar: invalid start of event ", e.Id, ": ", err)
+			continue
+		}
+		reminder := plugins.Reminder{
+			InstanceId: plugins.ReminderInstanceId(e.Id, start),
+			Summary:    e.Summary,
+			Location:   e.Location,
+			Start:      start,
+			AllDay:     allDay,
+		}
+
+		eventReminders := e.Reminders.Overrides
+		if e.Reminders.UseDefault {
+			eventReminders = l.DefaultReminders
+		}
+		if len(eventReminders) == 0 && leadTime > 0 {
+			reminder.Time = start.Add(-leadTime)
+			reminders = append(reminders, reminder)
+		}
+		for _, r := range eventReminders {
+			if r.Method != "popup" {
+				continue
+			}
+			reminder.Time = start.Add(-time.Duration(r.Minutes) * time.Minute)
+			reminders = append(reminders, reminder)
+		}
+	}
+	return reminders
+}
+
+// parse returns the time, telling whether it is a date
+func (t eventTime) parse() (time.Time, bool, error) {
+	if t.DateTime != "" {
+		start, err := time.Parse(time.RFC3339, t.DateTime)
+		return start, false, err
+	}
+	start, err := time.ParseInLocation("2006-01-02", t.Date, time.Local)
+	return start, true, err
+}
@@ -0,0 +1,224 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gcalendar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+const (
+	// changesPageSize is the number of events requested in each page
+	// of the changes
+	changesPageSize = 250
+	changesFields   = "nextPageToken,nextSyncToken,items(id,etag,status,summary,created,updated,start)"
+)
+
+// errSyncTokenExpired is returned when the server doesn't accept the sync
+// token anymore, and the calendar must be synced from scratch
+var errSyncTokenExpired = errors.New("sync token expired")
+
+// gcalendarConfig is the state of the plugin kept between polls
+type gcalendarConfig struct {
+	// Calendars holds the state of the calendars, keyed by their id
+	Calendars map[string]calendarState `json:"calendars,omitempty"`
+	// Reminders holds the event instances which were reminded of
+	Reminders plugins.RemindersSent `json:"reminders,omitempty"`
+	// Health records the failures to check or sync the calendars,
+	// keyed by their id
+	Health plugins.CalendarHealth `json:"health,omitempty"`
+}
+
+// calendarState is what is remembered of a calendar between polls
+type calendarState struct {
+	// SyncToken lists the events changed since the last poll, see
+	// https://developers.google.com/google-apps/calendar/v3/sync
+	SyncToken string `json:"syncToken,omitempty"`
+	// SyncedAt is when the token was obtained, in seconds since the
+	// epoch
+	SyncedAt int64 `json:"syncedAt,omitempty"`
+}
+
+func (p *GCalendarPlugin) loadPersistentData(accountId uint) error {
+	p.config = gcalendarConfig{}
+	err := plugins.FromPersist(pluginName, accountId, &p.config)
+	if p.config.Calendars == nil {
+		p.config.Calendars = make(map[string]calendarState)
+	}
+	if p.config.Reminders == nil {
+		p.config.Reminders = make(plugins.RemindersSent)
+	}
+	if p.config.Health == nil {
+		p.config.Health = make(plugins.CalendarHealth)
+	}
+	return err
+}
+
+func (p *GCalendarPlugin) savePersistentData(accountId uint) error {
+	err := plugins.Persist(pluginName, accountId, p.config)
+	if err != nil {
+		log.Print("calendar: failed to save state for account ", accountId, ": ", err)
+	}
+	return err
+}
+
+// calendarChanges returns the events of the calendar which were created,
+// updated or cancelled since the last poll, and stores the new sync
+// token. The changes are unknown on the first poll, or when the token
+// expired: then it returns true, meaning that the whole calendar must be
+// synced.
+func (p *GCalendarPlugin) calendarChanges(authData *plugins.AuthData, calendar string) ([]plugins.EventChange, bool, error) {
+	state := p.config.Calendars[calendar]
+	syncedAt := time.Now()
+
+	events, token, err := p.listChanges(authData, calendar, state.SyncToken)
+	if err == errSyncTokenExpired {
+		log.Print("\tcalendar: sync token of ", calendar, " expired, starting over")
+		state.SyncToken = ""
+		events, token, err = p.listChanges(authData, calendar, "")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	p.config.Calendars[calendar] = calendarState{SyncToken: token, SyncedAt: syncedAt.Unix()}
+	if state.SyncToken == "" {
+		return nil, true, nil
+	}
+
+	var changes []plugins.EventChange
+	for _, e := range events {
+		change := e.change(calendar, time.Unix(state.SyncedAt, 0))
+		log.Print("\tcalendar: event ", change.Kind, ": ", e.Id, " ", e.Summary)
+		changes = append(changes, change)
+	}
+	return changes, false, nil
+}
+
+// listChanges returns the events changed since the state described by
+// the sync token, going through all the pages, and the new sync token.
+// Without a sync token, the events are only listed to obtain one, and
+// are not returned.
+func (p *GCalendarPlugin) listChanges(authData *plugins.AuthData, calendar, syncToken string) ([]event, string, error) {
+	var events []event
+	pageToken := ""
+	for {
+		resp, err := p.requestChanges(authData, calendar, syncToken, pageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		list, err := p.parseChangesResponse(resp)
+		if err != nil {
+			return nil, "", err
+		}
+		if syncToken != "" {
+			events = append(events, list.Events...)
+		}
+		if list.NextPageToken == "" {
+			if list.NextSyncToken == "" {
+				return nil, "", errors.New("no sync token in the last page of events")
+			}
+			return events, list.NextSyncToken, nil
+		}
+		pageToken = list.NextPageToken
+	}
+}
+
+func (p *GCalendarPlugin) parseChangesResponse(resp *http.Response) (*eventList, error) {
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+
+	if resp.StatusCode == http.StatusGone {
+		return nil, errSyncTokenExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp errorResp
+		if err := decoder.Decode(&errResp); err != nil {
+			return nil, err
+		}
+		log.Print("calendar: Invalid response:", errResp.Err.Code)
+		if errResp.Err.Code == 401 {
+			return nil, plugins.ErrTokenExpired
+		}
+		return nil, fmt.Errorf("unexpected response status %s: %s", resp.Status, errResp.Err.Message)
+	}
+
+	var events eventList
+	if err := decoder.Decode(&events); err != nil {
+		log.Print("calendar: Fail to decode")
+		return nil, err
+	}
+	return &events, nil
+}
+
+func (p *GCalendarPlugin) requestChanges(authData *plugins.AuthData, calendar, syncToken, pageToken string) (*http.Response, error) {
+	u, err := baseUrl.Parse("")
+	if err != nil {
+		return nil, err
+	}
+	u.Path += calendar + "/events"
+
+	//GET https://www.googleapis.com/calendar/v3/calendars/<calendar>/events?syncToken=<token>&maxResults=250&fields=nextPageToken%2CnextSyncToken%2Citems(id%2Cstatus%2C...)
+	query := u.Query()
+	query.Add("maxResults", strconv.Itoa(changesPageSize))
+	if syncToken != "" {
+		query.Add("syncToken", syncToken)
+		query.Add("fields", changesFields)
+	} else {
+		// Only the token is needed from the full listing
+		query.Add("fields", "nextPageToken,nextSyncToken")
+	}
+	if pageToken != "" {
+		query.Add("pageToken", pageToken)
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return plugins.GoogleOAuth2.Do(authData, req)
+}
+
+// change describes how the event changed since the given time
+func (e *event) change(calendar string, since time.Time) plugins.EventChange {
+	change := plugins.EventChange{
+		Calendar: calendar,
+		EventId:  e.Id,
+		Kind:     plugins.EventUpdated,
+		Summary:  e.Summary,
+	}
+	if e.Status == "cancelled" {
+		change.Kind = plugins.EventCancelled
+	} else if created, err := time.Parse(time.RFC3339, e.Created); err == nil && !created.Before(since) {
+		change.Kind = plugins.EventCreated
+	}
+	if e.Start.Date != "" || e.Start.DateTime != "" {
+		if start, allDay, err := e.Start.parse(); err == nil {
+			change.Start = start
+			change.AllDay = allDay
+		}
+	}
+	return change
+}
@@ -0,0 +1,131 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gcalendar
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+// changesServer lists the events changed since the sync token "sync-1"
+// in two pages, and gives out "sync-2" after them, which has no changes.
+// The initial listing gives out "sync-1", and the token "expired" is
+// refused.
+type changesServer struct {
+	*httptest.Server
+	// requests records the sync and page tokens of the requests
+	requests []string
+	// created is the creation time of the added event, after the
+	// last poll
+	created string
+}
+
+func newChangesServer(c *C) *changesServer {
+	s := &changesServer{created: time.Now().Add(time.Hour).UTC().Format(time.RFC3339)}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error": {"code": 401, "message": "Invalid Credentials"}}`)
+			return
+		}
+		c.Check(r.URL.Path, Equals, "/calendar/v3/calendars/work/events")
+		query := r.URL.Query()
+		c.Check(query.Get("timeMin"), Equals, "")
+		syncToken, pageToken := query.Get("syncToken"), query.Get("pageToken")
+		s.requests = append(s.requests, syncToken+"/"+pageToken)
+		switch {
+		case syncToken == "":
+			fmt.Fprint(w, `{"nextSyncToken": "sync-1"}`)
+		case syncToken == "sync-2":
+			fmt.Fprint(w, `{"nextSyncToken": "sync-2"}`)
+		case syncToken == "expired":
+			w.WriteHeader(http.StatusGone)
+			fmt.Fprint(w, `{"error": {"code": 410, "message": "Sync token is no longer valid, a full sync is required."}}`)
+		case pageToken == "":
+			fmt.Fprint(w, `{
+  "nextPageToken": "page-2",
+  "items": [
+    { "id": "moved", "summary": "Moved", "created": "2017-01-01T10:00:00Z", "start": { "dateTime": "2017-06-06T10:00:00Z" } },
+    { "id": "removed", "status": "cancelled" }
+  ]
+}`)
+		default:
+			fmt.Fprintf(w, `{
+  "nextSyncToken": "sync-2",
+  "items": [
+    { "id": "added", "summary": "Added", "created": %q, "start": { "date": "2017-06-07" } }
+  ]
+}`, s.created)
+		}
+	}))
+	return s
+}
+
+func (s S) TestCalendarChanges(c *C) {
+	server := newChangesServer(c)
+	defer server.Close()
+	oldBaseUrl := baseUrl
+	defer func() { baseUrl = oldBaseUrl }()
+	baseUrl, _ = url.Parse(server.URL + "/calendar/v3/calendars/")
+
+	p := New()
+	p.loadPersistentData(1)
+	authData := &plugins.AuthData{AccessToken: "token"}
+
+	// The first time, the calendar is synced from scratch
+	changes, full, err := p.calendarChanges(authData, "work")
+	c.Assert(err, IsNil)
+	c.Check(full, Equals, true)
+	c.Check(changes, HasLen, 0)
+	c.Check(p.config.Calendars["work"].SyncToken, Equals, "sync-1")
+
+	p.savePersistentData(1)
+	p.loadPersistentData(1)
+	server.requests = nil
+	changes, full, err = p.calendarChanges(authData, "work")
+	c.Assert(err, IsNil)
+	c.Check(full, Equals, false)
+	c.Check(server.requests, DeepEquals, []string{"sync-1/", "sync-1/page-2"})
+	c.Check(p.config.Calendars["work"].SyncToken, Equals, "sync-2")
+	c.Check(changes, DeepEquals, []plugins.EventChange{
+		{Calendar: "work", EventId: "moved", Kind: plugins.EventUpdated, Summary: "Moved", Start: time.Date(2017, 6, 6, 10, 0, 0, 0, time.UTC)},
+		{Calendar: "work", EventId: "removed", Kind: plugins.EventCancelled},
+		{Calendar: "work", EventId: "added", Kind: plugins.EventCreated, Summary: "Added", Start: time.Date(2017, 6, 7, 0, 0, 0, 0, time.Local), AllDay: true},
+	})
+
+	// An expired token starts the sync over
+	p.config.Calendars["work"] = calendarState{SyncToken: "expired"}
+	server.requests = nil
+	changes, full, err = p.calendarChanges(authData, "work")
+	c.Assert(err, IsNil)
+	c.Check(full, Equals, true)
+	c.Check(changes, HasLen, 0)
+	c.Check(server.requests, DeepEquals, []string{"expired/", "/"})
+	c.Check(p.config.Calendars["work"].SyncToken, Equals, "sync-1")
+
+	// The token is kept when the changes can't be listed
+	_, _, err = p.calendarChanges(&plugins.AuthData{AccessToken: "expired"}, "work")
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+	c.Check(p.config.Calendars["work"].SyncToken, Equals, "sync-1")
+}
@@ -0,0 +1,197 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package gcalendar
+
+import (
+	"errors"
+	"net/url"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+	"launchpad.net/account-polld/syncmonitor"
+)
+
+func (s S) TestPollSyncMonitor(c *C) {
+	server := newChangesServer(c)
+	defer server.Close()
+	oldBaseUrl := baseUrl
+	defer func() { baseUrl = oldBaseUrl }()
+	baseUrl, _ = url.Parse(server.URL + "/calendar/v3/calendars/")
+	synced := map[string]string{"work": "2017-06-05T10:00:00Z"}
+
+	checks := []struct {
+		name          string
+		state         string
+		finishesSync  bool
+		lastSyncDates map[string]string
+		// syncToken is the stored token before the poll, and newToken
+		// the one after it
+		syncToken   string
+		newToken    string
+		accessToken string
+		monitorErr  error
+		syncErr     error
+		err         string
+		calendars   int
+		changes     int
+		syncRequest bool
+		// failures is the number of failures recorded for the calendar
+		failures int
+	}{{
+		name:          "busy",
+		state:         "syncing",
+		lastSyncDates: synced,
+		syncToken:     "sync-1",
+		newToken:      "sync-1",
+		err:           "Sync service busy",
+		calendars:     1,
+	}, {
+		name:          "busy until the sync finishes",
+		state:         "syncing",
+		finishesSync:  true,
+		lastSyncDates: synced,
+		syncToken:     "sync-1",
+		newToken:      "sync-2",
+		calendars:     1,
+		changes:       3,
+		syncRequest:   true,
+	}, {
+		name:          "first poll",
+		lastSyncDates: synced,
+		newToken:      "sync-1",
+		calendars:     1,
+		syncRequest:   true,
+	}, {
+		name:          "unchanged",
+		lastSyncDates: synced,
+		syncToken:     "sync-2",
+		newToken:      "sync-2",
+		calendars:     1,
+	}, {
+		name:          "changed",
+		lastSyncDates: synced,
+		syncToken:     "sync-1",
+		newToken:      "sync-2",
+		calendars:     1,
+		changes:       3,
+		syncRequest:   true,
+	}, {
+		name:        "never synced",
+		syncToken:   "sync-2",
+		newToken:    "sync-2",
+		calendars:   1,
+		syncRequest: true,
+	}, {
+		name:          "sync request failed",
+		lastSyncDates: synced,
+		syncToken:     "sync-1",
+		newToken:      "sync-1",
+		syncErr:       errors.New("no reply"),
+		err:           "Sync request failed for work: no reply",
+		calendars:     1,
+		failures:      1,
+	}, {
+		name:          "token expired",
+		lastSyncDates: synced,
+		syncToken:     "sync-1",
+		newToken:      "sync-1",
+		accessToken:   "expired",
+		err:           "Token expired",
+	}, {
+		name:       "unavailable",
+		syncToken:  "sync-1",
+		newToken:   "sync-1",
+		monitorErr: errors.New("service unknown"),
+		err:        "Sync service unavailable: service unknown",
+	}}
+	for _, check := range checks {
+		comment := Commentf(check.name)
+		monitor := &syncmonitor.Fake{
+			Calendars:     map[uint]map[string]string{1: {"work": "Work"}},
+			LastSyncDates: check.lastSyncDates,
+			CurrentState:  check.state,
+			FinishesSync:  check.finishesSync,
+			Err:           check.monitorErr,
+			SyncErr:       check.syncErr,
+		}
+		authData := &plugins.AuthData{AccessToken: "token"}
+		if check.accessToken != "" {
+			authData.AccessToken = check.accessToken
+		}
+
+		p := New()
+		p.accountId = 1
+		p.loadPersistentData(1)
+		if check.syncToken != "" {
+			p.config.Calendars["work"] = calendarState{SyncToken: check.syncToken}
+		}
+		p.syncMonitor = monitor
+		calendars, changes, err := p.pollSyncMonitor(authData)
+		if check.err == "" {
+			c.Check(err, IsNil, comment)
+		} else {
+			c.Check(err, ErrorMatches, check.err, comment)
+		}
+		c.Check(calendars, HasLen, check.calendars, comment)
+		c.Check(changes, HasLen, check.changes, comment)
+		if check.syncRequest {
+			c.Check(monitor.SyncRequests, DeepEquals, [][]string{{"work"}}, comment)
+		} else {
+			c.Check(monitor.SyncRequests, HasLen, 0, comment)
+		}
+		c.Check(p.config.Calendars["work"].SyncToken, Equals, check.newToken, comment)
+		c.Check(p.config.Health["work"].Failures, Equals, check.failures, comment)
+		c.Check(p.syncMonitor == nil, Equals, check.monitorErr != nil, comment)
+	}
+}
+
+func (s S) TestPollWithSyncMonitor(c *C) {
+	server := newChangesServer(c)
+	defer server.Close()
+	oldBaseUrl := baseUrl
+	defer func() { baseUrl = oldBaseUrl }()
+	baseUrl, _ = url.Parse(server.URL + "/calendar/v3/calendars/")
+	monitor := &syncmonitor.Fake{
+		Calendars: map[uint]map[string]string{1: {"work": "Work"}},
+	}
+
+	p := New()
+	p.newSyncMonitor = func() syncmonitor.SyncMonitor { return monitor }
+	authData := &plugins.AuthData{AccountId: 1, AccessToken: "token"}
+	// The state of the calendars which were removed is forgotten
+	p.loadPersistentData(1)
+	p.config.Calendars["old"] = calendarState{SyncToken: "old-1"}
+	p.savePersistentData(1)
+
+	_, err := p.Poll(authData)
+	c.Assert(err, IsNil)
+	c.Check(monitor.SyncRequests, DeepEquals, [][]string{{"work"}})
+	p.loadPersistentData(1)
+	c.Check(p.config.Calendars, DeepEquals, map[string]calendarState{
+		"work": {SyncToken: "sync-1", SyncedAt: p.config.Calendars["work"].SyncedAt},
+	})
+}
+
+func (s S) TestPollWithoutSyncMonitor(c *C) {
+	p := New()
+	p.newSyncMonitor = func() syncmonitor.SyncMonitor { return nil }
+	batches, err := p.Poll(&plugins.AuthData{AccountId: 1, AccessToken: "token"})
+	c.Check(batches, HasLen, 0)
+	c.Assert(err, FitsTypeOf, &plugins.SyncError{})
+	c.Check(err.(*plugins.SyncError).Kind, Equals, plugins.SyncUnavailable)
+}
@@ -0,0 +1,116 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// RepeatedFailures is the number of consecutive failures after which a
+// calendar is reported as failing
+const RepeatedFailures = 3
+
+// CalendarStatus tells how the last polls of a calendar went
+type CalendarStatus struct {
+	// Failures counts the consecutive failures
+	Failures int `json:"failures,omitempty"`
+	// Kind classifies the last failure
+	Kind      SyncErrorKind `json:"kind,omitempty"`
+	LastError string        `json:"lastError,omitempty"`
+	// LastFailure and LastSuccess are in seconds since the epoch
+	LastFailure int64 `json:"lastFailure,omitempty"`
+	LastSuccess int64 `json:"lastSuccess,omitempty"`
+}
+
+// CalendarHealth records the status of the calendars, keyed by their id.
+// The calendar plugins keep it in their persisted state, along with the
+// rest of what they know about the calendars of the account.
+type CalendarHealth map[string]CalendarStatus
+
+// Failed records a failure to check or sync the calendar
+func (h CalendarHealth) Failed(calendar string, kind SyncErrorKind, err error, now time.Time) {
+	status := h[calendar]
+	status.Failures++
+	status.Kind = kind
+	status.LastError = err.Error()
+	status.LastFailure = now.Unix()
+	h[calendar] = status
+	if status.Failures == RepeatedFailures {
+		log.Print("Calendar ", calendar, " failed ", status.Failures, " times in a row: ", err)
+	}
+}
+
+// Succeeded records that the calendar was checked, and its sync requested
+// if needed
+func (h CalendarHealth) Succeeded(calendar string, now time.Time) {
+	status := h[calendar]
+	if status.Failures >= RepeatedFailures {
+		log.Print("Calendar ", calendar, " recovered after ", status.Failures, " failures")
+	}
+	h[calendar] = CalendarStatus{LastSuccess: now.Unix()}
+}
+
+// Failing returns the calendars which failed at least RepeatedFailures
+// times in a row, sorted
+func (h CalendarHealth) Failing() []string {
+	var failing []string
+	for calendar, status := range h {
+		if status.Failures >= RepeatedFailures {
+			failing = append(failing, calendar)
+		}
+	}
+	sort.Strings(failing)
+	return failing
+}
+
+// Prune forgets the calendars which are not in the list
+func (h CalendarHealth) Prune(calendars []string) {
+	known := make(map[string]bool, len(calendars))
+	for _, calendar := range calendars {
+		known[calendar] = true
+	}
+	for calendar := range h {
+		if !known[calendar] {
+			delete(h, calendar)
+		}
+	}
+}
+
+// SyncError returns a SyncError for the calendars failing repeatedly, or
+// nil if there are none. When they don't fail the same way, it reports
+// the ones failing like the first.
+func (h CalendarHealth) SyncError() error {
+	failing := h.Failing()
+	if len(failing) == 0 {
+		return nil
+	}
+	first := h[failing[0]]
+	var calendars []string
+	for _, calendar := range failing {
+		if h[calendar].Kind == first.Kind {
+			calendars = append(calendars, calendar)
+		}
+	}
+	return &SyncError{
+		Kind:      first.Kind,
+		Calendars: calendars,
+		Err:       errors.New(first.LastError),
+	}
+}
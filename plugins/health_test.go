@@ -0,0 +1,80 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"errors"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestCalendarHealth(c *C) {
+	health := make(CalendarHealth)
+	now := time.Unix(1496743200, 0)
+	health.Succeeded("work", now)
+	for i := 0; i < RepeatedFailures-1; i++ {
+		health.Failed("personal", SyncRequestFailed, errors.New("not found"), now)
+	}
+	c.Check(health.Failing(), HasLen, 0)
+	c.Check(health.SyncError(), IsNil)
+
+	health.Failed("personal", SyncRequestFailed, errors.New("timeout"), now)
+	c.Check(health["personal"], DeepEquals, CalendarStatus{
+		Failures:    RepeatedFailures,
+		Kind:        SyncRequestFailed,
+		LastError:   "timeout",
+		LastFailure: now.Unix(),
+	})
+	c.Check(health.Failing(), DeepEquals, []string{"personal"})
+	c.Check(health.SyncError(), ErrorMatches, "Sync request failed for personal: timeout")
+
+	health.Succeeded("personal", now)
+	c.Check(health["personal"], DeepEquals, CalendarStatus{LastSuccess: now.Unix()})
+	c.Check(health.SyncError(), IsNil)
+
+	// The kind of the failures is reported
+	for i := 0; i < RepeatedFailures; i++ {
+		health.Failed("holidays", SyncUnavailable, errors.New("no reply"), now)
+		health.Failed("personal", SyncRequestFailed, errors.New("timeout"), now)
+		health.Failed("work", SyncUnavailable, errors.New("no reply"), now)
+	}
+	err := health.SyncError()
+	c.Assert(err, FitsTypeOf, &SyncError{})
+	c.Check(err.(*SyncError).Kind, Equals, SyncUnavailable)
+	c.Check(err.(*SyncError).Calendars, DeepEquals, []string{"holidays", "work"})
+	c.Check(err, ErrorMatches, "Sync service unavailable for holidays, work: no reply")
+	health.Succeeded("work", now)
+
+	health.Prune([]string{"personal", "work"})
+	c.Check(health, HasLen, 2)
+	c.Check(health["work"], DeepEquals, CalendarStatus{LastSuccess: now.Unix()})
+}
+
+func (s *S) TestSyncError(c *C) {
+	err := &SyncError{Kind: SyncUnavailable, Err: errors.New("service unknown")}
+	c.Check(err, ErrorMatches, "Sync service unavailable: service unknown")
+	c.Check(err.Kind.code(), Equals, "ERR_SYNC_UNAVAILABLE")
+
+	err = &SyncError{Kind: SyncBusy}
+	c.Check(err, ErrorMatches, "Sync service busy")
+	c.Check(err.Kind.code(), Equals, "ERR_SYNC_BUSY")
+
+	err = &SyncError{Kind: SyncRequestFailed, Calendars: []string{"personal", "work"}, Err: errors.New("no reply")}
+	c.Check(err, ErrorMatches, "Sync request failed for personal, work: no reply")
+	c.Check(err.Kind.code(), Equals, "ERR_SYNC_FAILED")
+}
@@ -0,0 +1,475 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	dateFormat     = "20060102"
+	dateTimeFormat = "20060102T150405"
+)
+
+// Event is a VEVENT component
+type Event struct {
+	Uid      string
+	Summary  string
+	Location string
+	Status   string
+	Start    time.Time
+	End      time.Time
+	// AllDay is set for the events which have dates without times
+	AllDay bool
+	// RecurrenceId is set for the instances of recurring events
+	RecurrenceId time.Time
+	Recurrence   Recurrence
+	Alarms       []Alarm
+	// Organizer is nil for the events without attendees
+	Organizer *Address
+	Attendees []Address
+
+	// zone is the time zone of the start, in which the recurrences are
+	// computed
+	zone zoneFunc
+}
+
+// Todo is a VTODO component
+type Todo struct {
+	Uid      string
+	Summary  string
+	Status   string
+	Priority int
+	// Start, Due and Completed are zero when they are not set
+	Start     time.Time
+	Due       time.Time
+	Completed time.Time
+	// AllDay is set when the due date has no time
+	AllDay     bool
+	Recurrence Recurrence
+	Alarms     []Alarm
+}
+
+// Address is a calendar user, such as the organizer or an attendee of an
+// event
+type Address struct {
+	Name  string
+	Email string
+	// PartStat is the participation status of an attendee, such as
+	// "ACCEPTED" or "DECLINED"
+	PartStat string
+}
+
+// String returns the name of the user, or the email address if there's
+// none
+func (a Address) String() string {
+	if a.Name != "" {
+		return a.Name
+	}
+	return a.Email
+}
+
+func parseAddress(p *Property) Address {
+	a := Address{
+		Name:     p.Param("CN"),
+		PartStat: strings.ToUpper(p.Param("PARTSTAT")),
+	}
+	value := strings.TrimSpace(p.Value)
+	if strings.HasPrefix(strings.ToLower(value), "mailto:") {
+		a.Email = value[len("mailto:"):]
+	} else {
+		a.Email = value
+	}
+	return a
+}
+
+// Recurrence holds the properties which make a component repeat
+type Recurrence struct {
+	// Rule is nil if there is no RRULE
+	Rule    *Rule
+	RDates  []time.Time
+	ExDates []time.Time
+}
+
+// IsZero tells whether the component doesn't repeat
+func (r *Recurrence) IsZero() bool {
+	return r.Rule == nil && len(r.RDates) == 0
+}
+
+// Alarm is a VALARM component
+type Alarm struct {
+	Action string
+	// Trigger is the offset of the alarm from the start of the event,
+	// or from the end if RelatedEnd is set
+	Trigger    time.Duration
+	RelatedEnd bool
+	// Absolute is the time of the alarms with an absolute trigger
+	Absolute time.Time
+}
+
+// Time returns when the alarm fires for a component with the given start
+// and end; the end of a todo is its due time.
+func (a Alarm) Time(start, end time.Time) time.Time {
+	if !a.Absolute.IsZero() {
+		return a.Absolute
+	}
+	if a.RelatedEnd {
+		return end.Add(a.Trigger)
+	}
+	return start.Add(a.Trigger)
+}
+
+// Events returns the events in the calendar. Floating times and dates are
+// interpreted in loc.
+func Events(cal *Component, loc *time.Location) ([]*Event, error) {
+	d := newDecoder(cal, loc)
+	var events []*Event
+	for _, c := range cal.Children("VEVENT") {
+		e, err := d.parseEvent(c)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// Todos returns the todos in the calendar. Floating times and dates are
+// interpreted in loc.
+func Todos(cal *Component, loc *time.Location) ([]*Todo, error) {
+	d := newDecoder(cal, loc)
+	var todos []*Todo
+	for _, c := range cal.Children("VTODO") {
+		t, err := d.parseTodo(c)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, t)
+	}
+	return todos, nil
+}
+
+func (d *decoder) parseEvent(c *Component) (*Event, error) {
+	e := &Event{
+		Uid:      c.Text("UID"),
+		Summary:  c.Text("SUMMARY"),
+		Location: c.Text("LOCATION"),
+		Status:   strings.ToUpper(c.Text("STATUS")),
+	}
+	start := c.Prop("DTSTART")
+	if start == nil {
+		return nil, fmt.Errorf("ical: event %s has no start", e.Uid)
+	}
+	var err error
+	if e.Start, e.AllDay, e.zone, err = d.parseTime(start); err != nil {
+		return nil, err
+	}
+
+	if end := c.Prop("DTEND"); end != nil {
+		if e.End, _, _, err = d.parseTime(end); err != nil {
+			return nil, err
+		}
+	} else if duration := c.Prop("DURATION"); duration != nil {
+		dur, err := ParseDuration(duration.Value)
+		if err != nil {
+			return nil, err
+		}
+		e.End = e.Start.Add(dur)
+	} else if e.AllDay {
+		e.End = e.Start.AddDate(0, 0, 1)
+	} else {
+		e.End = e.Start
+	}
+
+	if id := c.Prop("RECURRENCE-ID"); id != nil {
+		if e.RecurrenceId, _, _, err = d.parseTime(id); err != nil {
+			return nil, err
+		}
+	}
+	if e.Recurrence, err = d.parseRecurrence(c); err != nil {
+		return nil, err
+	}
+	if e.Alarms, err = d.parseAlarms(c); err != nil {
+		return nil, err
+	}
+	if p := c.Prop("ORGANIZER"); p != nil {
+		organizer := parseAddress(p)
+		e.Organizer = &organizer
+	}
+	for _, p := range c.Props("ATTENDEE") {
+		e.Attendees = append(e.Attendees, parseAddress(p))
+	}
+	return e, nil
+}
+
+func (d *decoder) parseTodo(c *Component) (*Todo, error) {
+	t := &Todo{
+		Uid:     c.Text("UID"),
+		Summary: c.Text("SUMMARY"),
+		Status:  strings.ToUpper(c.Text("STATUS")),
+	}
+	var err error
+	if p := c.Prop("PRIORITY"); p != nil {
+		if t.Priority, err = strconv.Atoi(strings.TrimSpace(p.Value)); err != nil {
+			return nil, fmt.Errorf("ical: invalid priority %q", p.Value)
+		}
+	}
+	if p := c.Prop("DTSTART"); p != nil {
+		if t.Start, _, _, err = d.parseTime(p); err != nil {
+			return nil, err
+		}
+	}
+	if p := c.Prop("DUE"); p != nil {
+		if t.Due, t.AllDay, _, err = d.parseTime(p); err != nil {
+			return nil, err
+		}
+	} else if p := c.Prop("DURATION"); p != nil && !t.Start.IsZero() {
+		dur, err := ParseDuration(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		t.Due = t.Start.Add(dur)
+	}
+	if p := c.Prop("COMPLETED"); p != nil {
+		if t.Completed, _, _, err = d.parseTime(p); err != nil {
+			return nil, err
+		}
+	}
+	if t.Recurrence, err = d.parseRecurrence(c); err != nil {
+		return nil, err
+	}
+	if t.Alarms, err = d.parseAlarms(c); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (d *decoder) parseRecurrence(c *Component) (Recurrence, error) {
+	var r Recurrence
+	var err error
+	if p := c.Prop("RRULE"); p != nil {
+		if r.Rule, err = ParseRule(p.Value); err != nil {
+			return r, err
+		}
+	}
+	if r.RDates, err = d.parseTimes(c.Props("RDATE")); err != nil {
+		return r, err
+	}
+	r.ExDates, err = d.parseTimes(c.Props("EXDATE"))
+	return r, err
+}
+
+func (d *decoder) parseAlarms(c *Component) ([]Alarm, error) {
+	var alarms []Alarm
+	for _, a := range c.Children("VALARM") {
+		alarm := Alarm{Action: strings.ToUpper(a.Text("ACTION"))}
+		trigger := a.Prop("TRIGGER")
+		if trigger == nil {
+			return nil, fmt.Errorf("ical: alarm without trigger")
+		}
+		var err error
+		if strings.ToUpper(trigger.Param("VALUE")) == "DATE-TIME" {
+			alarm.Absolute, _, _, err = d.parseTime(trigger)
+		} else {
+			alarm.RelatedEnd = strings.ToUpper(trigger.Param("RELATED")) == "END"
+			alarm.Trigger, err = ParseDuration(trigger.Value)
+		}
+		if err != nil {
+			return nil, err
+		}
+		alarms = append(alarms, alarm)
+	}
+	return alarms, nil
+}
+
+// Occurrences returns the start times of the instances of the event which
+// overlap the time range from start to end, excluded.
+func (e *Event) Occurrences(start, end time.Time) []time.Time {
+	duration := e.End.Sub(e.Start)
+	overlaps := func(t time.Time) bool {
+		if !t.Before(end) {
+			return false
+		}
+		if duration == 0 {
+			return !t.Before(start)
+		}
+		return t.Add(duration).After(start)
+	}
+
+	r := &e.Recurrence
+	if r.IsZero() {
+		if overlaps(e.Start) {
+			return []time.Time{e.Start}
+		}
+		return nil
+	}
+
+	var times []time.Time
+	add := func(t time.Time) {
+		for _, ex := range r.ExDates {
+			if ex.Equal(t) {
+				return
+			}
+		}
+		for _, other := range times {
+			if other.Equal(t) {
+				return
+			}
+		}
+		if overlaps(t) {
+			times = append(times, t)
+		}
+	}
+	if r.Rule != nil {
+		zone := e.zone
+		if zone == nil {
+			zone = locationZone(e.Start.Location())
+		}
+		r.Rule.expand(wallClock(e.Start), zone, end, func(t time.Time) bool {
+			if !t.Before(end) {
+				return false
+			}
+			add(t)
+			return true
+		})
+	} else {
+		add(e.Start)
+	}
+	for _, t := range r.RDates {
+		add(t)
+	}
+	sort.Sort(byTime(times))
+	return times
+}
+
+// Expand returns the instances of the events which overlap the time range
+// from start to end, excluded, sorted by their start. The instances of a
+// recurring event are replaced by the events with the same UID and their
+// RECURRENCE-ID, and the cancelled ones are left out.
+func Expand(events []*Event, start, end time.Time) []*Event {
+	overrides := make(map[string]bool)
+	for _, e := range events {
+		if !e.RecurrenceId.IsZero() {
+			overrides[instanceKey(e.Uid, e.RecurrenceId)] = true
+		}
+	}
+
+	var instances []*Event
+	for _, e := range events {
+		if !e.RecurrenceId.IsZero() {
+			if len(e.Occurrences(start, end)) > 0 && e.Status != "CANCELLED" {
+				instances = append(instances, e)
+			}
+			continue
+		}
+		if e.Status == "CANCELLED" {
+			continue
+		}
+		recurring := !e.Recurrence.IsZero()
+		for _, t := range e.Occurrences(start, end) {
+			if overrides[instanceKey(e.Uid, t)] {
+				continue
+			}
+			instance := *e
+			instance.Start = t
+			if e.AllDay {
+				// The days can be shorter or longer across DST changes
+				days := int((e.End.Sub(e.Start) + 12*time.Hour) / (24 * time.Hour))
+				instance.End = t.AddDate(0, 0, days)
+			} else {
+				instance.End = t.Add(e.End.Sub(e.Start))
+			}
+			if recurring {
+				instance.RecurrenceId = t
+				instance.Recurrence = Recurrence{}
+			}
+			instances = append(instances, &instance)
+		}
+	}
+	sort.Sort(byStart(instances))
+	return instances
+}
+
+func instanceKey(uid string, t time.Time) string {
+	return fmt.Sprintf("%s@%d", uid, t.Unix())
+}
+
+type byStart []*Event
+
+func (e byStart) Len() int           { return len(e) }
+func (e byStart) Swap(i, j int)      { e[i], e[j] = e[j], e[i] }
+func (e byStart) Less(i, j int) bool { return e[i].Start.Before(e[j].Start) }
+
+// ParseDuration parses a DURATION value, such as "-PT15M" or "P1DT12H"
+func ParseDuration(s string) (time.Duration, error) {
+	value := strings.TrimSpace(s)
+	sign := time.Duration(1)
+	switch {
+	case strings.HasPrefix(value, "-"):
+		sign = -1
+		value = value[1:]
+	case strings.HasPrefix(value, "+"):
+		value = value[1:]
+	}
+	if !strings.HasPrefix(value, "P") || len(value) < 3 {
+		return 0, fmt.Errorf("ical: invalid duration %q", s)
+	}
+	value = value[1:]
+
+	var d time.Duration
+	inTime, hasTime := false, false
+	number := ""
+	for _, r := range value {
+		switch {
+		case r >= '0' && r <= '9':
+			number += string(r)
+			continue
+		case r == 'T' && number == "" && !inTime:
+			inTime = true
+			continue
+		}
+		n, err := strconv.Atoi(number)
+		if err != nil {
+			return 0, fmt.Errorf("ical: invalid duration %q", s)
+		}
+		number = ""
+		hasTime = inTime
+		unit := time.Duration(n)
+		switch {
+		case r == 'W' && !inTime:
+			d += unit * 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			d += unit * 24 * time.Hour
+		case r == 'H' && inTime:
+			d += unit * time.Hour
+		case r == 'M' && inTime:
+			d += unit * time.Minute
+		case r == 'S' && inTime:
+			d += unit * time.Second
+		default:
+			return 0, fmt.Errorf("ical: invalid duration %q", s)
+		}
+	}
+	if number != "" || inTime && !hasTime {
+		return 0, fmt.Errorf("ical: invalid duration %q", s)
+	}
+	return sign * d, nil
+}
@@ -0,0 +1,208 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+// Package ical reads the iCalendar data (RFC 5545) of calendar events.
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Property is a content line of a component, such as
+// "DTSTART;TZID=Europe/Rome:20160401T100000".
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Param returns the value of a parameter, or an empty string
+func (p *Property) Param(name string) string {
+	return p.Params[name]
+}
+
+// Component is a block between BEGIN and END lines, such as a VEVENT.
+type Component struct {
+	Name       string
+	Properties []*Property
+	Components []*Component
+}
+
+// Prop returns the first property with the given name, or nil
+func (c *Component) Prop(name string) *Property {
+	for _, p := range c.Properties {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// Props returns all the properties with the given name
+func (c *Component) Props(name string) []*Property {
+	var props []*Property
+	for _, p := range c.Properties {
+		if p.Name == name {
+			props = append(props, p)
+		}
+	}
+	return props
+}
+
+// Text returns the unescaped value of a text property, or an empty string
+func (c *Component) Text(name string) string {
+	if p := c.Prop(name); p != nil {
+		return unescapeText(p.Value)
+	}
+	return ""
+}
+
+// Children returns the subcomponents with the given name
+func (c *Component) Children(name string) []*Component {
+	var children []*Component
+	for _, child := range c.Components {
+		if child.Name == name {
+			children = append(children, child)
+		}
+	}
+	return children
+}
+
+// Parse reads an iCalendar stream and returns its top-level component,
+// usually a VCALENDAR.
+func Parse(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var stack []*Component
+	var root *Component
+	for _, line := range lines {
+		prop, err := parseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		switch prop.Name {
+		case "BEGIN":
+			c := &Component{Name: strings.ToUpper(prop.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			} else if root != nil {
+				return nil, errors.New("ical: more than one top-level component")
+			} else {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return nil, fmt.Errorf("ical: unexpected END:%s", prop.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("ical: property %s outside of a component", prop.Name)
+			}
+			c := stack[len(stack)-1]
+			c.Properties = append(c.Properties, prop)
+		}
+	}
+	if root == nil {
+		return nil, errors.New("ical: no component found")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("ical: missing END:%s", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+// unfold splits the stream into content lines, joining the lines which
+// were folded by starting them with a space or a tab.
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// parseLine splits a content line into name, parameters and value
+func parseLine(line string) (*Property, error) {
+	prop := &Property{Params: make(map[string]string)}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, fmt.Errorf("ical: malformed line %q", line)
+	}
+	prop.Name = strings.ToUpper(line[:i])
+	for line[i] == ';' {
+		line = line[i+1:]
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("ical: malformed parameter in %s", prop.Name)
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("ical: unterminated quote in %s", prop.Name)
+			}
+			value = line[1 : end+1]
+			line = line[end+2:]
+			i = 0
+		} else {
+			i = strings.IndexAny(line, ";:")
+			if i < 0 {
+				return nil, fmt.Errorf("ical: missing value in %s", prop.Name)
+			}
+			value = line[:i]
+			line = line[i:]
+			i = 0
+		}
+		if len(line) == 0 {
+			return nil, fmt.Errorf("ical: missing value in %s", prop.Name)
+		}
+		prop.Params[name] = value
+	}
+	if line[i] != ':' {
+		return nil, fmt.Errorf("ical: malformed parameters in %s", prop.Name)
+	}
+	prop.Value = line[i+1:]
+	return prop, nil
+}
+
+var textUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func unescapeText(s string) string {
+	return textUnescaper.Replace(s)
+}
@@ -0,0 +1,217 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type S struct{}
+
+func init() {
+	Suite(S{})
+}
+
+func TestAll(t *testing.T) {
+	TestingT(t)
+}
+
+const meeting = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting-1\r\n" +
+	"SUMMARY:Weekly meeting\\, room 2\r\n" +
+	"ORGANIZER;CN=\"Smith, Alice\":mailto:alice@example.com\r\n" +
+	"ATTENDEE;CN=Bob;PARTSTAT=declined:MAILTO:bob@example.com\r\n" +
+	"ATTENDEE:mailto:carol@example.com\r\n" +
+	"LOCATION;ALTREP=\"http://example.com/a;b:c\":Main\r\n" +
+	"  building\r\n" +
+	"DTSTART;TZID=Europe/Rome:20160401T100000\r\n" +
+	"DURATION:PT1H30M\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"END:VALARM\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:AUDIO\r\n" +
+	"TRIGGER;RELATED=END:PT0S\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:holiday\r\n" +
+	"DTSTART;VALUE=DATE:20160425\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER;VALUE=DATE-TIME:20160424T180000Z\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func (s S) TestParse(c *C) {
+	cal, err := Parse(strings.NewReader(meeting))
+	c.Assert(err, IsNil)
+	c.Check(cal.Name, Equals, "VCALENDAR")
+	c.Check(cal.Text("VERSION"), Equals, "2.0")
+	events := cal.Children("VEVENT")
+	c.Assert(events, HasLen, 2)
+
+	location := events[0].Prop("LOCATION")
+	c.Assert(location, NotNil)
+	c.Check(location.Param("ALTREP"), Equals, "http://example.com/a;b:c")
+	c.Check(location.Value, Equals, "Main building")
+	c.Check(events[0].Text("SUMMARY"), Equals, "Weekly meeting, room 2")
+	c.Check(events[0].Prop("DTSTART").Param("TZID"), Equals, "Europe/Rome")
+	c.Check(events[0].Prop("RRULE"), IsNil)
+	c.Check(events[0].Children("VALARM"), HasLen, 2)
+}
+
+func (s S) TestParseErrors(c *C) {
+	for _, data := range []string{
+		"",
+		"BEGIN:VCALENDAR\nEND:VEVENT\n",
+		"BEGIN:VCALENDAR\n",
+		"VERSION:2.0\n",
+		"BEGIN:VCALENDAR\nSUMMARY\nEND:VCALENDAR\n",
+		"BEGIN:VCALENDAR\nSUMMARY;LANGUAGE=en\nEND:VCALENDAR\n",
+		"BEGIN:VCALENDAR\nSUMMARY;ALTREP=\"x:Meeting\nEND:VCALENDAR\n",
+		"BEGIN:VCALENDAR\nSUMMARY;ALTREP=\"x\"y:Meeting\nEND:VCALENDAR\n",
+		"BEGIN:VCALENDAR\nEND:VCALENDAR\nBEGIN:VCALENDAR\nEND:VCALENDAR\n",
+	} {
+		_, err := Parse(strings.NewReader(data))
+		c.Check(err, NotNil, Commentf("%q", data))
+	}
+}
+
+func (s S) TestEvents(c *C) {
+	cal, err := Parse(strings.NewReader(meeting))
+	c.Assert(err, IsNil)
+	events, err := Events(cal, time.UTC)
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 2)
+
+	rome, err := time.LoadLocation("Europe/Rome")
+	c.Assert(err, IsNil)
+	e := events[0]
+	c.Check(e.Uid, Equals, "meeting-1")
+	c.Check(e.Summary, Equals, "Weekly meeting, room 2")
+	c.Check(e.Location, Equals, "Main building")
+	c.Check(e.Start.Equal(time.Date(2016, 4, 1, 10, 0, 0, 0, rome)), Equals, true)
+	c.Check(e.End.Sub(e.Start), Equals, 90*time.Minute)
+	c.Check(e.AllDay, Equals, false)
+	c.Check(e.Organizer, DeepEquals, &Address{Name: "Smith, Alice", Email: "alice@example.com"})
+	c.Check(e.Attendees, DeepEquals, []Address{
+		{Name: "Bob", Email: "bob@example.com", PartStat: "DECLINED"},
+		{Email: "carol@example.com"},
+	})
+	c.Check(e.Organizer.String(), Equals, "Smith, Alice")
+	c.Check(e.Attendees[1].String(), Equals, "carol@example.com")
+	c.Assert(e.Alarms, HasLen, 2)
+	c.Check(e.Alarms[0].Action, Equals, "DISPLAY")
+	c.Check(e.Alarms[0].Time(e.Start, e.End).Equal(e.Start.Add(-15*time.Minute)), Equals, true)
+	c.Check(e.Alarms[1].Action, Equals, "AUDIO")
+	c.Check(e.Alarms[1].Time(e.Start, e.End).Equal(e.End), Equals, true)
+
+	e = events[1]
+	c.Check(e.Organizer, IsNil)
+	c.Check(e.AllDay, Equals, true)
+	c.Check(e.Start, DeepEquals, time.Date(2016, 4, 25, 0, 0, 0, 0, time.UTC))
+	c.Check(e.End, DeepEquals, time.Date(2016, 4, 26, 0, 0, 0, 0, time.UTC))
+	c.Assert(e.Alarms, HasLen, 1)
+	c.Check(e.Alarms[0].Time(e.Start, e.End), DeepEquals, time.Date(2016, 4, 24, 18, 0, 0, 0, time.UTC))
+}
+
+func (s S) TestEventWithoutStart(c *C) {
+	cal, err := Parse(strings.NewReader("BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:x\nEND:VEVENT\nEND:VCALENDAR\n"))
+	c.Assert(err, IsNil)
+	_, err = Events(cal, time.UTC)
+	c.Check(err, NotNil)
+}
+
+func (s S) TestTodos(c *C) {
+	data := "BEGIN:VCALENDAR\n" +
+		"BEGIN:VTODO\n" +
+		"UID:report\n" +
+		"SUMMARY:Write the report\n" +
+		"STATUS:NEEDS-ACTION\n" +
+		"PRIORITY:1\n" +
+		"DTSTART:20160401T080000Z\n" +
+		"DURATION:PT4H\n" +
+		"RRULE:FREQ=MONTHLY\n" +
+		"BEGIN:VALARM\n" +
+		"ACTION:DISPLAY\n" +
+		"TRIGGER;RELATED=END:-PT1H\n" +
+		"END:VALARM\n" +
+		"END:VTODO\n" +
+		"BEGIN:VTODO\n" +
+		"UID:done\n" +
+		"DUE;VALUE=DATE:20160402\n" +
+		"COMPLETED:20160401T170000Z\n" +
+		"END:VTODO\n" +
+		"BEGIN:VEVENT\n" +
+		"UID:event\n" +
+		"DTSTART:20160401T080000Z\n" +
+		"END:VEVENT\n" +
+		"END:VCALENDAR\n"
+	cal, err := Parse(strings.NewReader(data))
+	c.Assert(err, IsNil)
+	todos, err := Todos(cal, time.UTC)
+	c.Assert(err, IsNil)
+	c.Assert(todos, HasLen, 2)
+
+	t := todos[0]
+	c.Check(t.Uid, Equals, "report")
+	c.Check(t.Status, Equals, "NEEDS-ACTION")
+	c.Check(t.Priority, Equals, 1)
+	c.Check(t.Due, Equals, time.Date(2016, 4, 1, 12, 0, 0, 0, time.UTC))
+	c.Check(t.Recurrence.Rule, NotNil)
+	c.Assert(t.Alarms, HasLen, 1)
+	c.Check(t.Alarms[0].Time(t.Start, t.Due), Equals, time.Date(2016, 4, 1, 11, 0, 0, 0, time.UTC))
+
+	t = todos[1]
+	c.Check(t.AllDay, Equals, true)
+	c.Check(t.Start.IsZero(), Equals, true)
+	c.Check(t.Completed, Equals, time.Date(2016, 4, 1, 17, 0, 0, 0, time.UTC))
+}
+
+func (s S) TestParseDuration(c *C) {
+	checks := []struct {
+		value    string
+		duration time.Duration
+	}{
+		{"PT15M", 15 * time.Minute},
+		{"-PT15M", -15 * time.Minute},
+		{"+PT1H30M", 90 * time.Minute},
+		{"P1D", 24 * time.Hour},
+		{"P2W", 14 * 24 * time.Hour},
+		{"P1DT2H3M4S", 26*time.Hour + 3*time.Minute + 4*time.Second},
+		{"PT0S", 0},
+	}
+	for _, check := range checks {
+		d, err := ParseDuration(check.value)
+		c.Check(err, IsNil, Commentf(check.value))
+		c.Check(d, Equals, check.duration, Commentf(check.value))
+	}
+
+	for _, value := range []string{"", "P", "15M", "PT", "P1H", "PT1D", "P1DT", "PT15", "P-1D"} {
+		_, err := ParseDuration(value)
+		c.Check(err, NotNil, Commentf(value))
+	}
+}
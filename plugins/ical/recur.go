@@ -0,0 +1,477 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ical
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a recurrence rule
+type Frequency int
+
+const (
+	Secondly Frequency = iota
+	Minutely
+	Hourly
+	Daily
+	Weekly
+	Monthly
+	Yearly
+)
+
+var frequencies = map[string]Frequency{
+	"SECONDLY": Secondly,
+	"MINUTELY": Minutely,
+	"HOURLY":   Hourly,
+	"DAILY":    Daily,
+	"WEEKLY":   Weekly,
+	"MONTHLY":  Monthly,
+	"YEARLY":   Yearly,
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// maxPeriods bounds the expansion of the rules which never match
+const maxPeriods = 100000
+
+// WeekdayNum is an element of BYDAY, such as "-1SU" for the last Sunday;
+// N is 0 for every matching weekday.
+type WeekdayNum struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// Rule is a RRULE, as described in section 3.3.10 of RFC 5545.
+// BYYEARDAY, BYWEEKNO, BYHOUR, BYMINUTE and BYSECOND are not supported.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	// Count is the number of occurrences, or 0 for no limit
+	Count int
+	// Until is the last possible occurrence, or the zero time
+	Until      time.Time
+	ByMonth    []int
+	ByMonthDay []int
+	ByDay      []WeekdayNum
+	BySetPos   []int
+	WeekStart  time.Weekday
+
+	// untilWall is set when Until is a floating time or a date, which
+	// is compared with the local time of the occurrences
+	untilWall bool
+}
+
+// ParseRule parses the value of a RRULE property
+func ParseRule(value string) (*Rule, error) {
+	r := &Rule{Interval: 1, WeekStart: time.Monday}
+	hasFreq := false
+	for _, part := range strings.Split(value, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("ical: invalid rule part %q", part)
+		}
+		name, v := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq, hasFreq = frequencies[v]
+			if !hasFreq {
+				return nil, fmt.Errorf("ical: invalid frequency %q", v)
+			}
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(v)
+			if err == nil && r.Interval < 1 {
+				err = fmt.Errorf("ical: invalid interval %q", v)
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(v)
+		case "UNTIL":
+			err = r.parseUntil(v)
+		case "BYMONTH":
+			r.ByMonth, err = parseInts(v, 12, false)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(v, 31, true)
+		case "BYSETPOS":
+			r.BySetPos, err = parseInts(v, 366, true)
+		case "BYDAY":
+			r.ByDay, err = parseWeekdays(v)
+		case "WKST":
+			var ok bool
+			if r.WeekStart, ok = weekdays[v]; !ok {
+				err = fmt.Errorf("ical: invalid week start %q", v)
+			}
+		default:
+			err = fmt.Errorf("ical: unsupported rule part %s", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !hasFreq {
+		return nil, fmt.Errorf("ical: rule without frequency")
+	}
+	return r, nil
+}
+
+func (r *Rule) parseUntil(v string) error {
+	var err error
+	switch {
+	case strings.HasSuffix(v, "Z"):
+		r.Until, err = time.Parse(dateTimeFormat+"Z", v)
+	case len(v) == len(dateFormat):
+		// the whole day is included
+		r.Until, err = time.Parse(dateFormat, v)
+		r.Until = r.Until.Add(24*time.Hour - time.Second)
+		r.untilWall = true
+	default:
+		r.Until, err = time.Parse(dateTimeFormat, v)
+		r.untilWall = true
+	}
+	return err
+}
+
+// parseInts parses a list of numbers between 1 and max, or between -max
+// and -1 if they can be negative
+func parseInts(v string, max int, negative bool) ([]int, error) {
+	var ints []int
+	for _, s := range strings.Split(v, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n == 0 || n > max || n < -max || (n < 0 && !negative) {
+			return nil, fmt.Errorf("ical: invalid number %q", s)
+		}
+		ints = append(ints, n)
+	}
+	return ints, nil
+}
+
+func parseWeekdays(v string) ([]WeekdayNum, error) {
+	var days []WeekdayNum
+	for _, s := range strings.Split(v, ",") {
+		if len(s) < 2 {
+			return nil, fmt.Errorf("ical: invalid weekday %q", s)
+		}
+		day, ok := weekdays[s[len(s)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("ical: invalid weekday %q", s)
+		}
+		var n int
+		if prefix := s[:len(s)-2]; prefix != "" {
+			var err error
+			if n, err = strconv.Atoi(prefix); err != nil || n == 0 || n < -53 || n > 53 {
+				return nil, fmt.Errorf("ical: invalid weekday %q", s)
+			}
+		}
+		days = append(days, WeekdayNum{N: n, Weekday: day})
+	}
+	return days, nil
+}
+
+// zoneFunc returns the time for the local time of a time zone, given as a
+// UTC time with the same clock reading
+type zoneFunc func(wall time.Time) time.Time
+
+func utcZone(wall time.Time) time.Time {
+	return wall
+}
+
+func locationZone(loc *time.Location) zoneFunc {
+	return func(w time.Time) time.Time {
+		return time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), 0, loc)
+	}
+}
+
+// wallClock returns the local time of t as a UTC time
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+// expand calls fn with the occurrences of the rule starting at start, a
+// local time in zone, until fn returns false or the occurrences are over.
+// The occurrences are generated in order until end at least.
+func (r *Rule) expand(start time.Time, zone zoneFunc, end time.Time, fn func(time.Time) bool) {
+	// The local times are compared with end in the most distant time
+	// zone, to be sure that no occurrence is missed
+	endWall := end.UTC().Add(14 * time.Hour)
+
+	// DTSTART is always the first occurrence
+	count := 1
+	if !fn(zone(start)) || r.Count == 1 {
+		return
+	}
+	for i := 0; i < maxPeriods; i++ {
+		periodStart, candidates := r.period(start, i*r.interval())
+		if periodStart.After(endWall) {
+			return
+		}
+		for _, wall := range candidates {
+			if !wall.After(start) {
+				continue
+			}
+			t := zone(wall)
+			if !r.Until.IsZero() {
+				if r.untilWall && wall.After(r.Until) || !r.untilWall && t.After(r.Until) {
+					return
+				}
+			}
+			if !fn(t) {
+				return
+			}
+			count++
+			if r.Count > 0 && count >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+func (r *Rule) interval() int {
+	if r.Interval < 1 {
+		return 1
+	}
+	return r.Interval
+}
+
+// period returns the start of the n-th period after the one of start, and
+// the sorted occurrences of the rule within it
+func (r *Rule) period(start time.Time, n int) (time.Time, []time.Time) {
+	y, m, d := start.Date()
+	clock := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+	}
+	var periodStart time.Time
+	var candidates []time.Time
+
+	switch r.Freq {
+	case Yearly:
+		year := y + n
+		periodStart = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		switch {
+		case len(r.ByMonth) > 0:
+			for _, month := range r.ByMonth {
+				candidates = append(candidates, r.monthDays(year, time.Month(month), start)...)
+			}
+		case len(r.ByDay) > 0 && len(r.ByMonthDay) == 0:
+			candidates = r.yearWeekdays(year, start)
+		case len(r.ByMonthDay) > 0:
+			for month := time.January; month <= time.December; month++ {
+				candidates = append(candidates, r.monthDays(year, month, start)...)
+			}
+		default:
+			candidates = r.monthDays(year, m, start)
+		}
+	case Monthly:
+		periodStart = time.Date(y, m+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+		if r.matchesMonth(periodStart) {
+			candidates = r.monthDays(periodStart.Year(), periodStart.Month(), start)
+		}
+	case Weekly:
+		offset := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+		periodStart = time.Date(y, m, d-offset+7*n, 0, 0, 0, 0, time.UTC)
+		for i := 0; i < 7; i++ {
+			t := clock(periodStart.Year(), periodStart.Month(), periodStart.Day()+i)
+			if len(r.ByDay) == 0 && t.Weekday() != start.Weekday() {
+				continue
+			}
+			if r.matchesMonth(t) && r.matchesWeekday(t) {
+				candidates = append(candidates, t)
+			}
+		}
+	case Daily:
+		periodStart = time.Date(y, m, d+n, 0, 0, 0, 0, time.UTC)
+		t := clock(y, m, d+n)
+		if r.matchesDay(t) {
+			candidates = append(candidates, t)
+		}
+	default:
+		unit := time.Second
+		switch r.Freq {
+		case Minutely:
+			unit = time.Minute
+		case Hourly:
+			unit = time.Hour
+		}
+		periodStart = start.Add(time.Duration(n) * unit)
+		if r.matchesDay(periodStart) {
+			candidates = append(candidates, periodStart)
+		}
+	}
+
+	sort.Sort(byTime(candidates))
+	// BYDAY can match the same day more than once
+	unique := candidates[:0]
+	for i, t := range candidates {
+		if i == 0 || !t.Equal(candidates[i-1]) {
+			unique = append(unique, t)
+		}
+	}
+	return periodStart, r.setPositions(unique)
+}
+
+// monthDays returns the days of the month matching BYMONTHDAY and BYDAY,
+// or the day of start if there are none
+func (r *Rule) monthDays(year int, month time.Month, start time.Time) []time.Time {
+	daysInMonth := time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	matches := make(map[int]bool)
+
+	if len(r.ByMonthDay) == 0 && len(r.ByDay) == 0 {
+		matches[start.Day()] = true
+	}
+	for _, d := range r.ByMonthDay {
+		if d < 0 {
+			d += daysInMonth + 1
+		}
+		matches[d] = true
+	}
+	if len(r.ByDay) > 0 {
+		first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC).Weekday()
+		byDay := make(map[int]bool)
+		for _, wd := range r.ByDay {
+			for _, d := range nthWeekdays(wd, first, daysInMonth) {
+				byDay[d] = true
+			}
+		}
+		if len(r.ByMonthDay) > 0 {
+			for d := range matches {
+				if !byDay[d] {
+					delete(matches, d)
+				}
+			}
+		} else {
+			matches = byDay
+		}
+	}
+
+	var days []time.Time
+	for d := range matches {
+		if d >= 1 && d <= daysInMonth {
+			days = append(days, time.Date(year, month, d, start.Hour(), start.Minute(), start.Second(), 0, time.UTC))
+		}
+	}
+	return days
+}
+
+// yearWeekdays returns the days of the year matching BYDAY
+func (r *Rule) yearWeekdays(year int, start time.Time) []time.Time {
+	first := time.Date(year, time.January, 1, start.Hour(), start.Minute(), start.Second(), 0, time.UTC)
+	daysInYear := time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	var days []time.Time
+	for _, wd := range r.ByDay {
+		for _, d := range nthWeekdays(wd, first.Weekday(), daysInYear) {
+			days = append(days, first.AddDate(0, 0, d-1))
+		}
+	}
+	return days
+}
+
+// nthWeekdays returns the days, counted from 1, of a span of the given
+// length starting on the first weekday, which match wd
+func nthWeekdays(wd WeekdayNum, first time.Weekday, length int) []int {
+	firstMatch := 1 + (int(wd.Weekday)-int(first)+7)%7
+	var days []int
+	for d := firstMatch; d <= length; d += 7 {
+		days = append(days, d)
+	}
+	switch {
+	case wd.N == 0:
+		return days
+	case wd.N > 0 && wd.N <= len(days):
+		return days[wd.N-1 : wd.N]
+	case wd.N < 0 && -wd.N <= len(days):
+		i := len(days) + wd.N
+		return days[i : i+1]
+	}
+	return nil
+}
+
+func (r *Rule) matchesMonth(t time.Time) bool {
+	if len(r.ByMonth) == 0 {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if time.Month(m) == t.Month() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) matchesWeekday(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, wd := range r.ByDay {
+		if wd.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesDay tells whether a day passes the BYMONTH, BYMONTHDAY and BYDAY
+// filters
+func (r *Rule) matchesDay(t time.Time) bool {
+	if !r.matchesMonth(t) || !r.matchesWeekday(t) {
+		return false
+	}
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	daysInMonth := time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, d := range r.ByMonthDay {
+		if d == t.Day() || d < 0 && d+daysInMonth+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+// setPositions applies BYSETPOS to the occurrences of a period
+func (r *Rule) setPositions(candidates []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 {
+		return candidates
+	}
+	var selected []time.Time
+	for _, pos := range r.BySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(candidates) + pos
+		}
+		if i >= 0 && i < len(candidates) {
+			selected = append(selected, candidates[i])
+		}
+	}
+	sort.Sort(byTime(selected))
+	return selected
+}
+
+type byTime []time.Time
+
+func (t byTime) Len() int           { return len(t) }
+func (t byTime) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byTime) Less(i, j int) bool { return t[i].Before(t[j]) }
@@ -0,0 +1,274 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ical
+
+import (
+	"strings"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+// parseEvents parses the events of a calendar holding the given lines
+func parseEvents(c *C, lines ...string) []*Event {
+	data := "BEGIN:VCALENDAR\n" + strings.Join(lines, "\n") + "\nEND:VCALENDAR\n"
+	cal, err := Parse(strings.NewReader(data))
+	c.Assert(err, IsNil)
+	events, err := Events(cal, time.UTC)
+	c.Assert(err, IsNil)
+	return events
+}
+
+// occurrences returns the first n occurrences of a recurring event, in
+// the time zone of its start, as "2006-01-02 15:04 -0700"
+func occurrences(c *C, dtstart, rrule string, n int) []string {
+	events := parseEvents(c, "BEGIN:VEVENT", "UID:x", dtstart, rrule, "END:VEVENT")
+	c.Assert(events, HasLen, 1)
+	e := events[0]
+	var times []string
+	for _, t := range e.Occurrences(e.Start, e.Start.AddDate(5, 0, 0)) {
+		if len(times) == n {
+			break
+		}
+		times = append(times, t.In(e.Start.Location()).Format("2006-01-02 15:04 -0700"))
+	}
+	return times
+}
+
+// The examples come from section 3.8.5.3 of RFC 5545
+func (s S) TestRules(c *C) {
+	const newYork = "DTSTART;TZID=America/New_York:"
+	checks := []struct {
+		dtstart, rrule string
+		expected       []string
+	}{{
+		newYork + "19970902T090000",
+		"RRULE:FREQ=DAILY;COUNT=3",
+		[]string{"1997-09-02 09:00 -0400", "1997-09-03 09:00 -0400", "1997-09-04 09:00 -0400"},
+	}, {
+		// across the end of daylight saving time
+		newYork + "19971022T090000",
+		"RRULE:FREQ=WEEKLY;COUNT=3",
+		[]string{"1997-10-22 09:00 -0400", "1997-10-29 09:00 -0500", "1997-11-05 09:00 -0500"},
+	}, {
+		newYork + "19970902T090000",
+		"RRULE:FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH",
+		[]string{
+			"1997-09-02 09:00 -0400", "1997-09-04 09:00 -0400",
+			"1997-09-09 09:00 -0400", "1997-09-11 09:00 -0400",
+			"1997-09-16 09:00 -0400", "1997-09-18 09:00 -0400",
+			"1997-09-23 09:00 -0400", "1997-09-25 09:00 -0400",
+			"1997-09-30 09:00 -0400", "1997-10-02 09:00 -0400",
+		},
+	}, {
+		newYork + "19970901T090000",
+		"RRULE:FREQ=WEEKLY;INTERVAL=2;UNTIL=19971001T000000Z;WKST=SU;BYDAY=MO,WE,FR",
+		[]string{
+			"1997-09-01 09:00 -0400", "1997-09-03 09:00 -0400", "1997-09-05 09:00 -0400",
+			"1997-09-15 09:00 -0400", "1997-09-17 09:00 -0400", "1997-09-19 09:00 -0400",
+			"1997-09-29 09:00 -0400",
+		},
+	}, {
+		newYork + "19970905T090000",
+		"RRULE:FREQ=MONTHLY;COUNT=4;BYDAY=1FR",
+		[]string{"1997-09-05 09:00 -0400", "1997-10-03 09:00 -0400", "1997-11-07 09:00 -0500", "1997-12-05 09:00 -0500"},
+	}, {
+		newYork + "19970928T090000",
+		"RRULE:FREQ=MONTHLY;BYMONTHDAY=-3",
+		[]string{"1997-09-28 09:00 -0400", "1997-10-29 09:00 -0500", "1997-11-28 09:00 -0500", "1997-12-29 09:00 -0500", "1998-01-29 09:00 -0500", "1998-02-26 09:00 -0500"},
+	}, {
+		// the last work day of the month
+		newYork + "19970930T090000",
+		"RRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1",
+		[]string{"1997-09-30 09:00 -0400", "1997-10-31 09:00 -0500", "1997-11-28 09:00 -0500", "1997-12-31 09:00 -0500", "1998-01-30 09:00 -0500"},
+	}, {
+		// the months without the day are skipped
+		"DTSTART:20070131T100000Z",
+		"RRULE:FREQ=MONTHLY;COUNT=5",
+		[]string{"2007-01-31 10:00 +0000", "2007-03-31 10:00 +0000", "2007-05-31 10:00 +0000", "2007-07-31 10:00 +0000", "2007-08-31 10:00 +0000"},
+	}, {
+		newYork + "19971127T090000",
+		"RRULE:FREQ=YEARLY;BYMONTH=11;BYDAY=4TH",
+		[]string{"1997-11-27 09:00 -0500", "1998-11-26 09:00 -0500", "1999-11-25 09:00 -0500"},
+	}, {
+		newYork + "19970519T090000",
+		"RRULE:FREQ=YEARLY;BYDAY=20MO",
+		[]string{"1997-05-19 09:00 -0400", "1998-05-18 09:00 -0400", "1999-05-17 09:00 -0400"},
+	}, {
+		"DTSTART;VALUE=DATE:20160229",
+		"RRULE:FREQ=YEARLY;UNTIL=20200229",
+		[]string{"2016-02-29 00:00 +0000", "2020-02-29 00:00 +0000"},
+	}, {
+		"DTSTART:20160101T100000Z",
+		"RRULE:FREQ=HOURLY;INTERVAL=6;COUNT=3",
+		[]string{"2016-01-01 10:00 +0000", "2016-01-01 16:00 +0000", "2016-01-01 22:00 +0000"},
+	}, {
+		// DTSTART is the first occurrence even if it doesn't match
+		"DTSTART:20160101T100000Z",
+		"RRULE:FREQ=MONTHLY;COUNT=2;BYMONTHDAY=15",
+		[]string{"2016-01-01 10:00 +0000", "2016-01-15 10:00 +0000"},
+	}}
+	for _, check := range checks {
+		n := len(check.expected)
+		if strings.Contains(check.rrule, "COUNT") || strings.Contains(check.rrule, "UNTIL") {
+			// there must be no more occurrences
+			n++
+		}
+		c.Check(occurrences(c, check.dtstart, check.rrule, n), DeepEquals, check.expected, Commentf(check.rrule))
+	}
+}
+
+func (s S) TestParseRuleErrors(c *C) {
+	for _, rule := range []string{
+		"",
+		"COUNT=3",
+		"FREQ=FORTNIGHTLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;BYMONTH=13",
+		"FREQ=DAILY;BYMONTH=-1",
+		"FREQ=DAILY;BYMONTHDAY=0",
+		"FREQ=DAILY;BYDAY=XX",
+		"FREQ=DAILY;BYDAY=0MO",
+		"FREQ=DAILY;BYWEEKNO=20",
+		"FREQ=DAILY;UNTIL=tomorrow",
+		"FREQ",
+	} {
+		_, err := ParseRule(rule)
+		c.Check(err, NotNil, Commentf(rule))
+	}
+}
+
+func (s S) TestExpand(c *C) {
+	events := parseEvents(c,
+		"BEGIN:VEVENT",
+		"UID:standup",
+		"SUMMARY:Standup",
+		"DTSTART:20160104T090000Z",
+		"DTEND:20160104T091500Z",
+		"RRULE:FREQ=DAILY;BYDAY=MO,TU,WE,TH,FR",
+		"EXDATE:20160106T090000Z,20160107T090000Z",
+		"RDATE:20160109T100000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:standup",
+		"SUMMARY:Late standup",
+		"RECURRENCE-ID:20160105T090000Z",
+		"DTSTART:20160105T110000Z",
+		"DTEND:20160105T111500Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:standup",
+		"STATUS:CANCELLED",
+		"RECURRENCE-ID:20160108T090000Z",
+		"DTSTART:20160108T090000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:lunch",
+		"SUMMARY:Lunch",
+		"DTSTART:20160104T120000Z",
+		"DTEND:20160104T130000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:cancelled",
+		"STATUS:CANCELLED",
+		"DTSTART:20160104T150000Z",
+		"END:VEVENT",
+	)
+	start := time.Date(2016, 1, 4, 9, 5, 0, 0, time.UTC)
+	end := time.Date(2016, 1, 11, 9, 0, 0, 0, time.UTC)
+	var instances []string
+	for _, e := range Expand(events, start, end) {
+		instances = append(instances, e.Start.Format("Mon 15:04 ")+e.Summary)
+		c.Check(e.End.Sub(e.Start) > 0, Equals, true)
+	}
+	c.Check(instances, DeepEquals, []string{
+		// the first instance overlaps the start of the range
+		"Mon 09:00 Standup",
+		"Mon 12:00 Lunch",
+		"Tue 11:00 Late standup",
+		"Sat 10:00 Standup",
+	})
+
+	expanded := Expand(events, start, end)
+	c.Check(expanded[0].RecurrenceId, Equals, expanded[0].Start)
+	c.Check(expanded[0].Recurrence.IsZero(), Equals, true)
+	c.Check(expanded[1].RecurrenceId.IsZero(), Equals, true)
+}
+
+const customTimeZone = `BEGIN:VTIMEZONE
+TZID:Eastern Custom
+BEGIN:STANDARD
+DTSTART:19671029T020000
+RRULE:FREQ=YEARLY;BYDAY=1SU;BYMONTH=11
+TZOFFSETFROM:-0400
+TZOFFSETTO:-0500
+TZNAME:EST
+END:STANDARD
+BEGIN:DAYLIGHT
+DTSTART:19870405T020000
+RRULE:FREQ=YEARLY;BYDAY=2SU;BYMONTH=3
+TZOFFSETFROM:-0500
+TZOFFSETTO:-0400
+TZNAME:EDT
+END:DAYLIGHT
+END:VTIMEZONE`
+
+func (s S) TestTimeZones(c *C) {
+	events := parseEvents(c,
+		customTimeZone,
+		"BEGIN:VEVENT",
+		"UID:custom",
+		"DTSTART;TZID=Eastern Custom:20161030T090000",
+		"RRULE:FREQ=WEEKLY;COUNT=2",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:global",
+		"DTSTART;TZID=/Europe/Rome:20160701T090000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:unknown",
+		"DTSTART;TZID=Nowhere:20160701T090000",
+		"END:VEVENT",
+	)
+	c.Assert(events, HasLen, 3)
+
+	custom := events[0]
+	c.Check(custom.Start.UTC(), Equals, time.Date(2016, 10, 30, 13, 0, 0, 0, time.UTC))
+	times := custom.Occurrences(custom.Start, custom.Start.AddDate(1, 0, 0))
+	c.Assert(times, HasLen, 2)
+	c.Check(times[1].UTC(), Equals, time.Date(2016, 11, 6, 14, 0, 0, 0, time.UTC))
+	name, _ := times[1].Zone()
+	c.Check(name, Equals, "EST")
+
+	c.Check(events[1].Start.UTC(), Equals, time.Date(2016, 7, 1, 7, 0, 0, 0, time.UTC))
+	// Unknown time zones are floating times
+	c.Check(events[2].Start.UTC(), Equals, time.Date(2016, 7, 1, 9, 0, 0, 0, time.UTC))
+}
+
+func (s S) TestParseOffset(c *C) {
+	for value, offset := range map[string]int{"+0100": 3600, "-0530": -19800, "+013015": 5415, "-0000": 0} {
+		o, err := parseOffset(&Property{Value: value})
+		c.Check(err, IsNil, Commentf(value))
+		c.Check(o, Equals, offset, Commentf(value))
+	}
+	for _, value := range []string{"", "0100", "+1", "+01:00", "+01xx"} {
+		_, err := parseOffset(&Property{Value: value})
+		c.Check(err, NotNil, Commentf(value))
+	}
+	_, err := parseOffset(nil)
+	c.Check(err, NotNil)
+}
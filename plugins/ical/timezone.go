@@ -0,0 +1,247 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package ical
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// decoder interprets the values of the components of a calendar
+type decoder struct {
+	cal *Component
+	// loc is the time zone of the floating times and of the dates
+	loc   *time.Location
+	zones map[string]zoneFunc
+}
+
+func newDecoder(cal *Component, loc *time.Location) *decoder {
+	return &decoder{cal: cal, loc: loc, zones: make(map[string]zoneFunc)}
+}
+
+// zone resolves a TZID, first in the IANA time zone database, then in the
+// VTIMEZONE components of the calendar. Unknown time zones are treated as
+// floating times.
+func (d *decoder) zone(tzid string) zoneFunc {
+	if zone, ok := d.zones[tzid]; ok {
+		return zone
+	}
+	// Globally unique identifiers are prefixed by a solidus
+	if loc, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+		d.zones[tzid] = locationZone(loc)
+		return d.zones[tzid]
+	}
+	zone := locationZone(d.loc)
+	for _, c := range d.cal.Children("VTIMEZONE") {
+		if c.Text("TZID") != tzid {
+			continue
+		}
+		tz, err := parseTimeZone(c)
+		if err != nil {
+			log.Print("ical: invalid time zone ", tzid, ": ", err)
+			break
+		}
+		zone = tz.at
+		break
+	}
+	d.zones[tzid] = zone
+	return zone
+}
+
+// parseTime parses a DATE or DATE-TIME value, telling whether it is a date
+// and returning the time zone of the value
+func (d *decoder) parseTime(p *Property) (time.Time, bool, zoneFunc, error) {
+	return d.parseTimeValue(p, strings.TrimSpace(p.Value))
+}
+
+func (d *decoder) parseTimeValue(p *Property, value string) (time.Time, bool, zoneFunc, error) {
+	if strings.ToUpper(p.Param("VALUE")) == "DATE" || len(value) == len(dateFormat) {
+		t, err := time.Parse(dateFormat, value)
+		zone := locationZone(d.loc)
+		return zone(t), true, zone, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(dateTimeFormat+"Z", value)
+		return t, false, utcZone, err
+	}
+	zone := locationZone(d.loc)
+	if tzid := p.Param("TZID"); tzid != "" {
+		zone = d.zone(tzid)
+	}
+	t, err := time.Parse(dateTimeFormat, value)
+	return zone(t), false, zone, err
+}
+
+// parseTimes parses the values of a list of properties holding dates or
+// times, such as EXDATE. The periods of RDATE are reduced to their start.
+func (d *decoder) parseTimes(props []*Property) ([]time.Time, error) {
+	var times []time.Time
+	for _, p := range props {
+		for _, value := range strings.Split(p.Value, ",") {
+			if i := strings.IndexByte(value, '/'); i >= 0 {
+				value = value[:i]
+			}
+			t, _, _, err := d.parseTimeValue(p, strings.TrimSpace(value))
+			if err != nil {
+				return nil, err
+			}
+			times = append(times, t)
+		}
+	}
+	return times, nil
+}
+
+// timeZone is a VTIMEZONE component
+type timeZone struct {
+	observances []observance
+}
+
+// observance is a STANDARD or DAYLIGHT component, the period during which
+// the time zone has the same offset
+type observance struct {
+	name       string
+	offsetFrom int
+	offsetTo   int
+	// start is the local time, before the observance, of its first onset
+	start  time.Time
+	rule   *Rule
+	rdates []time.Time
+}
+
+func parseTimeZone(c *Component) (*timeZone, error) {
+	tz := &timeZone{}
+	for _, o := range c.Components {
+		if o.Name != "STANDARD" && o.Name != "DAYLIGHT" {
+			continue
+		}
+		obs := observance{name: o.Text("TZNAME")}
+		var err error
+		if obs.offsetFrom, err = parseOffset(o.Prop("TZOFFSETFROM")); err != nil {
+			return nil, err
+		}
+		if obs.offsetTo, err = parseOffset(o.Prop("TZOFFSETTO")); err != nil {
+			return nil, err
+		}
+		start := o.Prop("DTSTART")
+		if start == nil {
+			return nil, fmt.Errorf("ical: observance without start")
+		}
+		if obs.start, err = time.Parse(dateTimeFormat, start.Value); err != nil {
+			return nil, err
+		}
+		if rrule := o.Prop("RRULE"); rrule != nil {
+			if obs.rule, err = ParseRule(rrule.Value); err != nil {
+				return nil, err
+			}
+		}
+		for _, rdate := range o.Props("RDATE") {
+			for _, value := range strings.Split(rdate.Value, ",") {
+				t, err := time.Parse(dateTimeFormat, strings.TrimSuffix(value, "Z"))
+				if err != nil {
+					return nil, err
+				}
+				obs.rdates = append(obs.rdates, t)
+			}
+		}
+		tz.observances = append(tz.observances, obs)
+	}
+	if len(tz.observances) == 0 {
+		return nil, fmt.Errorf("ical: time zone without observances")
+	}
+	return tz, nil
+}
+
+// parseOffset parses an UTC offset such as "-0500" or "+013000", in
+// seconds
+func parseOffset(p *Property) (int, error) {
+	if p == nil {
+		return 0, fmt.Errorf("ical: missing offset")
+	}
+	value := strings.TrimSpace(p.Value)
+	if (len(value) != 5 && len(value) != 7) || (value[0] != '+' && value[0] != '-') {
+		return 0, fmt.Errorf("ical: invalid offset %q", value)
+	}
+	var parts [3]int
+	for i := 0; 1+2*i < len(value); i++ {
+		n, err := strconv.Atoi(value[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, fmt.Errorf("ical: invalid offset %q", value)
+		}
+		parts[i] = n
+	}
+	offset := parts[0]*3600 + parts[1]*60 + parts[2]
+	if value[0] == '-' {
+		offset = -offset
+	}
+	return offset, nil
+}
+
+// at returns the time for a local time in the time zone, using the offset
+// of the observance with the latest onset before it
+func (tz *timeZone) at(wall time.Time) time.Time {
+	var current *observance
+	var currentOnset time.Time
+	for i := range tz.observances {
+		o := &tz.observances[i]
+		onset, ok := o.lastOnset(wall)
+		if ok && (current == nil || onset.After(currentOnset)) {
+			current, currentOnset = o, onset
+		}
+	}
+	name, offset := "", 0
+	if current != nil {
+		name, offset = current.name, current.offsetTo
+	} else {
+		// Before the first onset, the offset of the earliest
+		// observance is in effect
+		earliest := tz.observances[0]
+		for _, o := range tz.observances[1:] {
+			if o.start.Before(earliest.start) {
+				earliest = o
+			}
+		}
+		offset = earliest.offsetFrom
+	}
+	return time.Date(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute(), wall.Second(), 0, time.FixedZone(name, offset))
+}
+
+// lastOnset returns the last onset of the observance not after wall
+func (o *observance) lastOnset(wall time.Time) (time.Time, bool) {
+	var last time.Time
+	found := false
+	consider := func(t time.Time) bool {
+		if t.After(wall) {
+			return false
+		}
+		if !found || t.After(last) {
+			last, found = t, true
+		}
+		return true
+	}
+	if o.rule != nil {
+		o.rule.expand(o.start, utcZone, wall, consider)
+	} else {
+		consider(o.start)
+	}
+	for _, t := range o.rdates {
+		consider(t)
+	}
+	return last, found
+}
@@ -0,0 +1,51 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"log"
+	"os"
+)
+
+const invitationsConfigName = "invitations"
+
+// InvitationSettings tells how the meeting invitations are notified.
+// Example configuration:
+//
+//	{
+//	  "replyActions": true
+//	}
+//
+// With ReplyActions, the invitation cards also have actions to accept or
+// decline them in the calendar application.
+type InvitationSettings struct {
+	ReplyActions bool `json:"replyActions"`
+}
+
+// LoadInvitationSettings reads the invitation settings from the
+// configuration file; nil is returned if there's none, meaning that the
+// invitations are not notified.
+func LoadInvitationSettings() *InvitationSettings {
+	var s InvitationSettings
+	if err := loadConfig(invitationsConfigName, &s); err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("Cannot load invitation settings: ", err)
+		}
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,35 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestInvitationSettings(c *C) {
+	// The invitations are only notified once they are configured
+	c.Check(LoadInvitationSettings(), IsNil)
+
+	s.writeConfig(c, invitationsConfigName, `{}`)
+	c.Check(LoadInvitationSettings(), DeepEquals, &InvitationSettings{})
+
+	s.writeConfig(c, invitationsConfigName, `{"replyActions": true}`)
+	c.Check(LoadInvitationSettings(), DeepEquals, &InvitationSettings{ReplyActions: true})
+
+	s.writeConfig(c, invitationsConfigName, `{"replyActions": true`)
+	c.Check(LoadInvitationSettings(), IsNil)
+}
@@ -16,39 +16,54 @@
 
 package plugins
 
-import "C"
 import (
 	"encoding/json"
 	"log"
 	"os"
+	"sort"
+	"strconv"
+	"time"
 )
 
 type Ipc struct {
 	C      chan AuthData
+	Budget NotificationBudget
 	input  *json.Decoder
 	output *json.Encoder
 }
 
 type AuthData struct {
 	ApplicationId string
-	AccountId   uint
-	ServiceName string
-	ServiceType string
-	Error       error
-	Enabled     bool
+	AccountId     uint
+	ServiceName   string
+	ServiceType   string
+	Error         error
+	Enabled       bool
+	// Locale is the language the notifications should be shown in.
+	Locale string
 
 	ClientId     string
 	ClientSecret string
 	AccessToken  string
 	TokenSecret  string
-	Secret       string
-	UserName     string
+	RefreshToken string
+	// TokenExpiry is when AccessToken expires; zero if unknown.
+	TokenExpiry time.Time
+	Secret      string
+	UserName    string
+	// ServerUrl is the address of the server, for the services which
+	// are not bound to a single host
+	ServerUrl string
+
+	// refreshed is set when the plugin refreshed the tokens
+	refreshed bool
 }
 
 type JsonInputMessage struct {
 	ApplicationId string
-	AccountId uint
-	Auth map[string]interface{}
+	AccountId     uint
+	Locale        string
+	Auth          map[string]interface{}
 }
 
 func NewIpc(authData chan AuthData) *Ipc {
@@ -57,6 +72,7 @@ func NewIpc(authData chan AuthData) *Ipc {
 	w.output = json.NewEncoder(os.Stdout)
 
 	w.C = authData
+	w.Budget = DefaultBudget
 
 	return w
 }
@@ -73,6 +89,7 @@ func (w *Ipc) Run() {
 		var data AuthData
 		data.ApplicationId = msg.ApplicationId
 		data.AccountId = msg.AccountId
+		data.Locale = msg.Locale
 		if v, ok := msg.Auth["ClientId"]; ok {
 			data.ClientId = v.(string)
 		}
@@ -85,19 +102,51 @@ func (w *Ipc) Run() {
 		if v, ok := msg.Auth["TokenSecret"]; ok {
 			data.TokenSecret = v.(string)
 		}
+		if v, ok := msg.Auth["RefreshToken"]; ok {
+			data.RefreshToken = v.(string)
+		}
+		if v, ok := msg.Auth["ExpiresIn"]; ok {
+			data.TokenExpiry = expiryFromAuth(v)
+		}
 		if v, ok := msg.Auth["Secret"]; ok {
 			data.Secret = v.(string)
 		}
 		if v, ok := msg.Auth["UserName"]; ok {
 			data.UserName = v.(string)
 		}
+		if v, ok := msg.Auth["ServerUrl"]; ok {
+			data.ServerUrl = v.(string)
+		}
 		w.C <- data
 	}
 }
 
-func (w *Ipc) PostMessages(batches []*PushMessageBatch) {
+// PostMessages sends the notifications in batches to the daemon, after
+// stripping them of the details that the privacy level does not allow.
+// Batches are handled in order of priority, and the notifications of all
+// of them together must fit in the Ipc's budget.
+func (w *Ipc) PostMessages(batches []*PushMessageBatch, privacy PrivacyLevel) {
+	w.PostReply(batches, privacy, nil)
+}
+
+// PostReply sends the notifications like PostMessages, and the error the
+// poll failed with if it's not nil, as a single reply: the daemon learns
+// about the failure even if the plugin could notify of something.
+func (w *Ipc) PostReply(batches []*PushMessageBatch, privacy PrivacyLevel, err error) {
 	var notifications []*PushMessage
 
+	// The rules are applied first, so that the batch handling below
+	// still limits the sounds and popups of the VIP messages
+	for _, batch := range batches {
+		for _, n := range batch.Messages {
+			n.applyRule()
+			if n.isVip() && batch.Priority > PRIORITY_HIGH {
+				batch.Priority = PRIORITY_HIGH
+			}
+		}
+	}
+
+	sort.Stable(byPriority(batches))
 	for _, batch := range batches {
 		notifs := batch.Messages
 		overflowing := len(notifs) > batch.Limit
@@ -114,30 +163,67 @@ func (w *Ipc) PostMessages(batches []*PushMessageBatch) {
 			if overflowing {
 				n.Notification.Card.Popup = false
 			}
+
+			if batch.silent && (batch.silenceVip || !n.isVip()) {
+				n.silence()
+			}
 		}
 
 		if overflowing {
 			n := batch.OverflowHandler(notifs)
 			n.Notification.Card.Persist = false
 			n.Notification.Vibrate = false
+			if batch.silent {
+				n.silence()
+			}
 			notifs = append(notifs, n)
 		}
 
 		notifications = append(notifications, notifs...)
 	}
+	notifications = w.Budget.apply(notifications)
+
+	for _, n := range notifications {
+		privacy.apply(n)
+	}
 
 	reply := make(map[string]interface{})
 	reply["notifications"] = notifications
+	if err != nil {
+		reply["error"] = errorReply(err)
+	}
+	w.output.Encode(reply)
+}
+
+// PostCredentials sends the credentials refreshed by the plugin to the
+// daemon, so that it can update the account.
+func (w *Ipc) PostCredentials(authData *AuthData) {
+	credentials := make(map[string]interface{})
+	credentials["AccountId"] = authData.AccountId
+	credentials["Auth"] = authData.credentials()
+	reply := make(map[string]interface{})
+	reply["credentials"] = credentials
 	w.output.Encode(reply)
 }
 
 func (w *Ipc) PostError(err error) {
+	reply := make(map[string]interface{})
+	reply["error"] = errorReply(err)
+	w.output.Encode(reply)
+}
+
+// errorReply describes the error to the daemon
+func errorReply(err error) map[string]string {
 	errorMap := make(map[string]string)
 	errorMap["message"] = err.Error()
 	if err == ErrTokenExpired {
 		errorMap["code"] = "ERR_INVALID_AUTH"
+	} else if e, ok := err.(*RateLimitError); ok {
+		errorMap["code"] = "ERR_RATE_LIMITED"
+		// Seconds since the epoch, when the daemon can poll again
+		errorMap["reset"] = strconv.FormatInt(e.Reset.Unix(), 10)
+	} else if e, ok := err.(*SyncError); ok {
+		errorMap["code"] = e.Kind.code()
 	}
-	reply := make(map[string]interface{})
-	reply["error"] = errorMap
-	w.output.Encode(reply)
+	return errorMap
 }
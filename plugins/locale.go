@@ -0,0 +1,54 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"log"
+
+	"launchpad.net/account-polld/gettext"
+)
+
+const (
+	textDomain = "account-polld"
+	localeDir  = "/usr/share/locale"
+)
+
+// translations is the handle used to render the notification texts; it
+// follows the locale requested by the daemon, if any, or the one set in
+// the environment. The handles read the catalogs in Go whichever gettext
+// implementation is built: the C library only translates into the process
+// locale, which must be installed on the system and is overridden by the
+// LANGUAGE variable the plugin was started with.
+var translations = gettext.NewLocale(textDomain, localeDir, "")
+
+// Gettext translates msgid into the current notification language.
+func Gettext(msgid string) string {
+	return translations.Gettext(msgid)
+}
+
+// NGettext translates msgid into the plural form for n of the current
+// notification language.
+func NGettext(msgid string, msgid_plural string, n uint64) string {
+	return translations.NGettext(msgid, msgid_plural, n)
+}
+
+// SetLocale changes the language of the notifications; an empty locale
+// selects the one set in the environment.
+func SetLocale(locale string) {
+	translations = gettext.NewLocale(textDomain, localeDir, locale)
+	log.Print("Using language '", translations.Language(), "' for notifications")
+}
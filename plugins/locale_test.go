@@ -0,0 +1,53 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestIpcLocale(c *C) {
+	authChan := make(chan AuthData, 2)
+	w := NewIpc(authChan)
+	w.input = json.NewDecoder(strings.NewReader(`
+{"ApplicationId": "app", "AccountId": 3, "Locale": "cs_CZ.UTF-8", "Auth": {"AccessToken": "token"}}
+{"ApplicationId": "app", "AccountId": 3, "Auth": {"AccessToken": "token"}}
+`))
+	w.Run()
+	data := <-authChan
+	c.Check(data.AccountId, Equals, uint(3))
+	c.Check(data.AccessToken, Equals, "token")
+	c.Check(data.Locale, Equals, "cs_CZ.UTF-8")
+	data = <-authChan
+	c.Check(data.Locale, Equals, "")
+}
+
+func (s *S) TestRunnerUpdateLocale(c *C) {
+	defer SetLocale("")
+
+	r := &PluginRunner{}
+	r.updateLocale("cs_CZ.UTF-8")
+	c.Check(translations.Language(), Equals, "cs_CZ.UTF-8")
+	// No locale in the request keeps the current one
+	r.updateLocale("")
+	c.Check(translations.Language(), Equals, "cs_CZ.UTF-8")
+	r.updateLocale("de_DE.UTF-8")
+	c.Check(translations.Language(), Equals, "de_DE.UTF-8")
+}
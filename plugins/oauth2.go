@@ -0,0 +1,158 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// refreshMargin is how long before its expiry an access token is refreshed
+const refreshMargin = time.Minute
+
+// OAuth2 performs requests authenticated with an OAuth 2.0 bearer token,
+// refreshing the token when it's about to expire or the server rejects it.
+// The refreshed credentials are stored in the AuthData, and the
+// PluginRunner sends them back to the daemon.
+type OAuth2 struct {
+	// TokenUrl is the endpoint used to refresh the access token.
+	TokenUrl string
+	// BasicAuth sends the client credentials of the confidential
+	// clients with HTTP basic authentication, as some servers require,
+	// instead of in the form.
+	BasicAuth bool
+	// Client is the HTTP client used for all the requests; if nil,
+	// http.DefaultClient is used.
+	Client *http.Client
+}
+
+// GoogleOAuth2 refreshes tokens with the Google authorization server.
+var GoogleOAuth2 = &OAuth2{TokenUrl: "https://oauth2.googleapis.com/token"}
+
+func (o *OAuth2) client() *http.Client {
+	if o.Client != nil {
+		return o.Client
+	}
+	return http.DefaultClient
+}
+
+// Do sends the request with the access token from authData. If the server
+// replies that the token is invalid, the request is retried once with a
+// refreshed token, so it must not have a body. Failures refreshing the
+// token are reported as ErrTokenExpired.
+func (o *OAuth2) Do(authData *AuthData, req *http.Request) (*http.Response, error) {
+	canRefresh := authData.RefreshToken != ""
+	if canRefresh && !authData.TokenExpiry.IsZero() &&
+		time.Now().Add(refreshMargin).After(authData.TokenExpiry) {
+		if err := o.Refresh(authData); err != nil {
+			return nil, err
+		}
+		canRefresh = false
+	}
+
+	for {
+		req.Header.Set("Authorization", "Bearer "+authData.AccessToken)
+		resp, err := o.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusUnauthorized || !canRefresh {
+			return resp, nil
+		}
+		resp.Body.Close()
+		if err := o.Refresh(authData); err != nil {
+			return nil, err
+		}
+		canRefresh = false
+	}
+}
+
+// Refresh obtains a new access token with the refresh token in authData.
+func (o *OAuth2) Refresh(authData *AuthData) error {
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {authData.RefreshToken},
+		"client_id":     {authData.ClientId},
+	}
+	if authData.ClientSecret != "" && !o.BasicAuth {
+		form.Set("client_secret", authData.ClientSecret)
+	}
+	req, err := http.NewRequest("POST", o.TokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Public clients, as the PKCE ones, don't have a secret
+	if authData.ClientSecret != "" && o.BasicAuth {
+		req.SetBasicAuth(authData.ClientId, authData.ClientSecret)
+	}
+	resp, err := o.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int64  `json:"expires_in"`
+		Error        string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil ||
+		resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		log.Print("Failed to refresh token for account ", authData.AccountId, ": ", resp.Status, " ", result.Error)
+		return ErrTokenExpired
+	}
+
+	authData.AccessToken = result.AccessToken
+	if result.RefreshToken != "" {
+		authData.RefreshToken = result.RefreshToken
+	}
+	authData.TokenExpiry = time.Time{}
+	if result.ExpiresIn > 0 {
+		authData.TokenExpiry = time.Now().Add(time.Duration(result.ExpiresIn) * time.Second)
+	}
+	authData.refreshed = true
+	return nil
+}
+
+// credentials returns the authentication data that the daemon has to store
+func (a *AuthData) credentials() map[string]interface{} {
+	auth := map[string]interface{}{
+		"AccessToken": a.AccessToken,
+	}
+	if a.RefreshToken != "" {
+		auth["RefreshToken"] = a.RefreshToken
+	}
+	if !a.TokenExpiry.IsZero() {
+		auth["ExpiresIn"] = int64(a.TokenExpiry.Sub(time.Now()) / time.Second)
+	}
+	return auth
+}
+
+// expiryFromAuth parses the lifetime of the access token, in seconds
+func expiryFromAuth(v interface{}) time.Time {
+	seconds, ok := v.(float64)
+	if !ok || seconds <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(time.Duration(seconds * float64(time.Second)))
+}
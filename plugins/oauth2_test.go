@@ -0,0 +1,212 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+// oauth2Server accepts only the given access token, and hands out
+// issuedToken (by default the same) when the "good" refresh token is used.
+// With basicAuth, the client credentials are expected in the header.
+type oauth2Server struct {
+	*httptest.Server
+	validToken  string
+	issuedToken string
+	basicAuth   bool
+	refreshes   int
+	requests    int
+}
+
+func newOauth2Server(c *C, validToken string) *oauth2Server {
+	s := &oauth2Server{validToken: validToken, issuedToken: validToken}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			s.refreshes++
+			c.Check(r.FormValue("grant_type"), Equals, "refresh_token")
+			c.Check(r.FormValue("client_id"), Equals, "client")
+			if s.basicAuth {
+				c.Check(r.FormValue("client_secret"), Equals, "")
+				user, password, _ := r.BasicAuth()
+				c.Check(user, Equals, "client")
+				c.Check(password, Equals, "secret")
+			} else {
+				c.Check(r.FormValue("client_secret"), Equals, "secret")
+			}
+			if r.FormValue("refresh_token") != "good" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error": "invalid_grant"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"access_token": %q, "expires_in": 3600, "token_type": "Bearer"}`, s.issuedToken)
+			return
+		}
+		s.requests++
+		if r.Header.Get("Authorization") != "Bearer "+s.validToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, "{}")
+	}))
+	return s
+}
+
+func (s *oauth2Server) do(c *C, authData *AuthData) (*http.Response, error) {
+	o := &OAuth2{TokenUrl: s.URL + "/token", BasicAuth: s.basicAuth}
+	req, err := http.NewRequest("GET", s.URL+"/api", nil)
+	c.Assert(err, IsNil)
+	return o.Do(authData, req)
+}
+
+func newAuthData(accessToken, refreshToken string) *AuthData {
+	return &AuthData{
+		AccountId:    4,
+		ClientId:     "client",
+		ClientSecret: "secret",
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+	}
+}
+
+func (s *S) TestOAuth2ValidToken(c *C) {
+	server := newOauth2Server(c, "valid")
+	defer server.Close()
+
+	authData := newAuthData("valid", "good")
+	resp, err := server.do(c, authData)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+	c.Check(server.refreshes, Equals, 0)
+	c.Check(authData.refreshed, Equals, false)
+}
+
+func (s *S) TestOAuth2RetryUnauthorized(c *C) {
+	server := newOauth2Server(c, "valid")
+	defer server.Close()
+
+	authData := newAuthData("stale", "good")
+	resp, err := server.do(c, authData)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+	c.Check(server.requests, Equals, 2)
+	c.Check(server.refreshes, Equals, 1)
+	c.Check(authData.AccessToken, Equals, "valid")
+	// The refresh token wasn't rotated
+	c.Check(authData.RefreshToken, Equals, "good")
+	c.Check(authData.TokenExpiry.After(time.Now().Add(59*time.Minute)), Equals, true)
+	c.Check(authData.refreshed, Equals, true)
+}
+
+func (s *S) TestOAuth2BasicAuth(c *C) {
+	server := newOauth2Server(c, "valid")
+	defer server.Close()
+	server.basicAuth = true
+
+	authData := newAuthData("stale", "good")
+	resp, err := server.do(c, authData)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+	c.Check(server.refreshes, Equals, 1)
+	c.Check(authData.AccessToken, Equals, "valid")
+}
+
+func (s *S) TestOAuth2RetryOnce(c *C) {
+	// The refreshed token is rejected as well
+	server := newOauth2Server(c, "valid")
+	defer server.Close()
+	server.issuedToken = "invalid"
+	resp, err := server.do(c, newAuthData("stale", "good"))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusUnauthorized)
+	c.Check(server.requests, Equals, 2)
+	c.Check(server.refreshes, Equals, 1)
+}
+
+func (s *S) TestOAuth2ProactiveRefresh(c *C) {
+	server := newOauth2Server(c, "valid")
+	defer server.Close()
+
+	authData := newAuthData("expiring", "good")
+	authData.TokenExpiry = time.Now().Add(10 * time.Second)
+	resp, err := server.do(c, authData)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusOK)
+	// The expiring token was never sent
+	c.Check(server.requests, Equals, 1)
+	c.Check(server.refreshes, Equals, 1)
+}
+
+func (s *S) TestOAuth2RefreshFailure(c *C) {
+	server := newOauth2Server(c, "valid")
+	defer server.Close()
+
+	authData := newAuthData("stale", "revoked")
+	_, err := server.do(c, authData)
+	c.Check(err, Equals, ErrTokenExpired)
+	c.Check(authData.AccessToken, Equals, "stale")
+	c.Check(authData.refreshed, Equals, false)
+}
+
+func (s *S) TestOAuth2NoRefreshToken(c *C) {
+	server := newOauth2Server(c, "valid")
+	defer server.Close()
+
+	resp, err := server.do(c, newAuthData("stale", ""))
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, http.StatusUnauthorized)
+	c.Check(server.refreshes, Equals, 0)
+}
+
+func (s *S) TestIpcCredentials(c *C) {
+	authChan := make(chan AuthData, 1)
+	w := NewIpc(authChan)
+	w.input = json.NewDecoder(strings.NewReader(`
+{"ApplicationId": "app", "AccountId": 4, "Auth": {"AccessToken": "token", "RefreshToken": "refresh", "ExpiresIn": 3600}}
+`))
+	w.Run()
+	data := <-authChan
+	c.Check(data.RefreshToken, Equals, "refresh")
+	c.Check(data.TokenExpiry.After(time.Now().Add(59*time.Minute)), Equals, true)
+
+	w, buf := newTestIpc()
+	data.AccessToken = "new token"
+	w.PostCredentials(&data)
+	var reply struct {
+		Credentials struct {
+			AccountId uint
+			Auth      map[string]interface{}
+		} `json:"credentials"`
+	}
+	c.Assert(json.NewDecoder(buf).Decode(&reply), IsNil)
+	c.Check(reply.Credentials.AccountId, Equals, uint(4))
+	c.Check(reply.Credentials.Auth["AccessToken"], Equals, "new token")
+	c.Check(reply.Credentials.Auth["RefreshToken"], Equals, "refresh")
+	c.Check(reply.Credentials.Auth["ExpiresIn"].(float64) > 3500, Equals, true)
+}
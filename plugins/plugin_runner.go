@@ -18,6 +18,7 @@ package plugins
 
 import (
 	"log"
+	"time"
 )
 
 type PluginRunner struct {
@@ -27,11 +28,15 @@ type PluginRunner struct {
 	authChan         chan AuthData
 	penaltyCount     int
 	authFailureCount int
+	locale           string
 }
 
 type PostWatch struct {
-	appId   ApplicationId
-	batches []*PushMessageBatch
+	appId     ApplicationId
+	accountId uint
+	batches   []*PushMessageBatch
+	// err is the error the poll failed with, despite the batches
+	err error
 }
 
 func NewPluginRunner(plugin Plugin) *PluginRunner {
@@ -54,28 +59,58 @@ func (r *PluginRunner) Run() {
 		select {
 		case data := <-r.authChan:
 			log.Println("Got data, access token is ", data.AccessToken)
+			r.updateLocale(data.Locale)
 			err := r.poll(&data)
 			if err != nil {
 				r.watcher.PostError(err)
 			}
 		case post := <-r.postWatch:
 			log.Println("Got reply")
-			r.watcher.PostMessages(post.batches)
+			r.post(post)
 		}
 	}
 }
 
+// post sends the notifications of the poll to the daemon, along with the
+// error it failed with, if any.
+func (r *PluginRunner) post(post *PostWatch) {
+	batches := LoadRules().apply(post.accountId, post.batches)
+	batches = LoadQuietHours().apply(post.accountId, time.Now(), batches)
+	r.watcher.PostReply(batches, PrivacyLevelForAccount(post.accountId), post.err)
+}
+
 func (r *PluginRunner) poll(authData *AuthData) error {
 	log.Println("Polling account", authData.AccountId)
 
-	if bs, err := r.plugin.Poll(authData); err != nil {
+	bs, err := r.plugin.Poll(authData)
+	if authData.refreshed {
+		r.watcher.PostCredentials(authData)
+	}
+	if err != nil {
 		log.Print("Error while polling ", authData.AccountId, ": ", err)
-		return err
-	} else {
-		for _, b := range bs {
-			log.Println("Account", authData.AccountId, "has", len(b.Messages), b.Tag, "updates to report")
+		// The error is sent with the notifications, if there are any
+		if len(bs) == 0 {
+			return err
 		}
-		r.postWatch <- &PostWatch{batches: bs, appId: r.plugin.ApplicationId()}
-		return err
 	}
+	for _, b := range bs {
+		log.Println("Account", authData.AccountId, "has", len(b.Messages), b.Tag, "updates to report")
+	}
+	r.postWatch <- &PostWatch{
+		batches:   bs,
+		appId:     r.plugin.ApplicationId(),
+		accountId: authData.AccountId,
+		err:       err,
+	}
+	return nil
+}
+
+// updateLocale switches the notifications to the locale requested by the
+// daemon, if it changed.
+func (r *PluginRunner) updateLocale(locale string) {
+	if locale == "" || locale == r.locale {
+		return
+	}
+	r.locale = locale
+	SetLocale(locale)
 }
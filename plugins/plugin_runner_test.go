@@ -0,0 +1,79 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	. "launchpad.net/gocheck"
+)
+
+// fakePlugin returns the same batches and error on every poll
+type fakePlugin struct {
+	batches []*PushMessageBatch
+	err     error
+}
+
+func (p *fakePlugin) ApplicationId() ApplicationId { return "app" }
+
+func (p *fakePlugin) Poll(authData *AuthData) ([]*PushMessageBatch, error) {
+	return p.batches, p.err
+}
+
+func newTestRunner(plugin Plugin) (*PluginRunner, *bytes.Buffer) {
+	w, buf := newTestIpc()
+	return &PluginRunner{
+		watcher:   w,
+		plugin:    plugin,
+		postWatch: make(chan *PostWatch, 1),
+	}, buf
+}
+
+func (s *S) TestRunnerPostsErrorWithMessages(c *C) {
+	plugin := &fakePlugin{
+		batches: []*PushMessageBatch{{
+			Messages: []*PushMessage{NewStandardPushMessage("Standup", "Starts at 10:00", "action", "", 1)},
+			Limit:    4,
+			Tag:      "reminder",
+		}},
+		err: &SyncError{Kind: SyncBusy},
+	}
+	r, buf := newTestRunner(plugin)
+
+	c.Assert(r.poll(&AuthData{AccountId: 1}), IsNil)
+	r.post(<-r.postWatch)
+	var reply struct {
+		Notifications []*PushMessage    `json:"notifications"`
+		Error         map[string]string `json:"error"`
+	}
+	c.Assert(json.NewDecoder(buf).Decode(&reply), IsNil)
+	c.Assert(reply.Notifications, HasLen, 1)
+	c.Check(reply.Notifications[0].Notification.Card.Summary, Equals, "Standup")
+	c.Check(reply.Error, DeepEquals, map[string]string{
+		"code":    "ERR_SYNC_BUSY",
+		"message": "Sync service busy",
+	})
+}
+
+func (s *S) TestRunnerReturnsErrorWithoutMessages(c *C) {
+	err := errors.New("no network")
+	r, _ := newTestRunner(&fakePlugin{err: err})
+	c.Check(r.poll(&AuthData{AccountId: 1}), Equals, err)
+	c.Check(r.postWatch, HasLen, 0)
+}
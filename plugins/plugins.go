@@ -24,6 +24,8 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
+	"time"
 
 	"launchpad.net/go-xdg/v0"
 )
@@ -34,6 +36,8 @@ import (
 // Poll interacts with the backend service with the means the plugin defines
 // and  returns a list of Notifications to send to the Push service. If an
 // error occurs and is returned the daemon can decide to throttle the service.
+// A plugin may return Notifications along with an error about a part of the
+// poll which failed: both are sent to the daemon in the same reply.
 //
 // ApplicationId returns the APP_ID of the delivery target for Post Office.
 type Plugin interface {
@@ -76,6 +80,10 @@ func NewStandardPushMessage(summary, body, action, icon string, epoch int64) *Pu
 // overflow. All Notifications that are part of a Batch share the same
 // tag (Tag).  ${Tag}-overflow is the overflow notification tag.
 //
+// Priority is one of the PRIORITY_* constants; when not all the
+// notifications of a poll can be presented, the batches with higher
+// priority are served first.
+//
 // TODO: support notifications sharing just the prefix (so the app can
 // tell them apart by tag).
 type PushMessageBatch struct {
@@ -83,6 +91,11 @@ type PushMessageBatch struct {
 	Limit           int
 	OverflowHandler func([]*PushMessage) *PushMessage
 	Tag             string
+	Priority        int
+
+	// silent is set during quiet hours
+	silent     bool
+	silenceVip bool
 }
 
 // PushMessage represents a data structure to be sent over to the
@@ -94,6 +107,10 @@ type PushMessage struct {
 	// Notification (optional) describes the user-facing notifications
 	// triggered by this push message.
 	Notification Notification `json:"notification,omitempty"`
+	// Fields (optional) describes the message for the notification rules.
+	Fields *MessageFields `json:"-"`
+	// rule is the notification rule matching this message, if any
+	rule *Rule
 }
 
 // Notification (optional) describes the user-facing notifications
@@ -149,7 +166,7 @@ type EmblemCounter struct {
 // DEFAULT: social media updates
 // LOW: software updates, junk email
 const (
-	PRIORITY_MAXIMUM = 0
+	PRIORITY_MAXIMUM = iota
 	PRIORITY_HIGH
 	PRIORITY_DEFAULT
 	PRIORITY_LOW
@@ -164,10 +181,75 @@ const (
 // the web service reported that the authentication token has expired.
 var ErrTokenExpired = errors.New("Token expired")
 
+// RateLimitError is the error returned by a plugin when the web service
+// can't be queried until Reset because of its rate limits.
+type RateLimitError struct {
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit exceeded until %s", e.Reset.Format(time.RFC3339))
+}
+
+// SyncErrorKind classifies the failures to sync the calendars
+type SyncErrorKind int
+
+const (
+	// SyncUnavailable means that the sync service can't be reached, or
+	// the calendars can't be discovered when there's none
+	SyncUnavailable SyncErrorKind = iota
+	// SyncBusy means that the service was still syncing at the end of
+	// the poll
+	SyncBusy
+	// SyncRequestFailed means that the sync of some calendars could not
+	// be requested
+	SyncRequestFailed
+)
+
+// SyncError is the error returned by the calendar plugins when the
+// calendars can't be synced.
+type SyncError struct {
+	Kind SyncErrorKind
+	// Calendars lists the calendars concerned, if known
+	Calendars []string
+	Err       error
+}
+
+func (e *SyncError) Error() string {
+	var msg string
+	switch e.Kind {
+	case SyncUnavailable:
+		msg = "Sync service unavailable"
+	case SyncBusy:
+		msg = "Sync service busy"
+	default:
+		msg = "Sync request failed"
+	}
+	if len(e.Calendars) > 0 {
+		msg += " for " + strings.Join(e.Calendars, ", ")
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// code returns the error code reported to the daemon
+func (k SyncErrorKind) code() string {
+	switch k {
+	case SyncUnavailable:
+		return "ERR_SYNC_UNAVAILABLE"
+	case SyncBusy:
+		return "ERR_SYNC_BUSY"
+	}
+	return "ERR_SYNC_FAILED"
+}
+
 var cmdName = "account-polld"
 
 var XdgDataFind = xdg.Data.Find
 var XdgDataEnsure = xdg.Data.Ensure
+var XdgConfigFind = xdg.Config.Find
 
 // Persist stores the plugins data in a common location to a json file
 // from which it can recover later
@@ -227,6 +309,21 @@ func FromPersist(pluginName string, accountId uint, data interface{}) (err error
 	return nil
 }
 
+// loadConfig reads the user configuration stored in the json file with the
+// given name
+func loadConfig(name string, data interface{}) error {
+	p, err := XdgConfigFind(filepath.Join(cmdName, name+".json"))
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewDecoder(file).Decode(data)
+}
+
 // DefaultSound returns the path to the default sound for a Notification
 func DefaultSound() string {
 	// path is searched within XDG_DATA_DIRS
@@ -0,0 +1,112 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type S struct {
+	configDir string
+	dataDir   string
+	cacheDir  string
+}
+
+var _ = Suite(&S{})
+
+func TestAll(t *testing.T) {
+	TestingT(t)
+}
+
+func (s *S) SetUpTest(c *C) {
+	s.configDir = c.MkDir()
+	XdgConfigFind = func(p string) (string, error) {
+		p = filepath.Join(s.configDir, p)
+		_, err := os.Stat(p)
+		return p, err
+	}
+	s.dataDir = c.MkDir()
+	XdgDataFind = func(p string) (string, error) {
+		p = filepath.Join(s.dataDir, p)
+		_, err := os.Stat(p)
+		return p, err
+	}
+	XdgDataEnsure = func(p string) (string, error) {
+		p = filepath.Join(s.dataDir, p)
+		return p, os.MkdirAll(filepath.Dir(p), 0700)
+	}
+	s.cacheDir = c.MkDir()
+	XdgCacheEnsure = func(p string) (string, error) {
+		p = filepath.Join(s.cacheDir, p)
+		return p, os.MkdirAll(filepath.Dir(p), 0700)
+	}
+}
+
+// writeConfig stores a configuration file where loadConfig can find it
+func (s *S) writeConfig(c *C, name, contents string) {
+	dir := filepath.Join(s.configDir, cmdName)
+	c.Assert(os.MkdirAll(dir, 0700), IsNil)
+	err := ioutil.WriteFile(filepath.Join(dir, name+".json"), []byte(contents), 0600)
+	c.Assert(err, IsNil)
+}
+
+// newTestIpc returns an Ipc writing its replies into the returned buffer
+func newTestIpc() (*Ipc, *bytes.Buffer) {
+	var buf bytes.Buffer
+	w := NewIpc(make(chan AuthData))
+	w.output = json.NewEncoder(&buf)
+	return w, &buf
+}
+
+// decodeNotifications parses the notifications reply written by the Ipc
+func decodeNotifications(c *C, buf *bytes.Buffer) []*PushMessage {
+	var reply struct {
+		Notifications []*PushMessage `json:"notifications"`
+	}
+	c.Assert(json.NewDecoder(buf).Decode(&reply), IsNil)
+	return reply.Notifications
+}
+
+func (s *S) TestPostError(c *C) {
+	w, buf := newTestIpc()
+	w.PostError(ErrTokenExpired)
+	w.PostError(&RateLimitError{Reset: time.Unix(1500000000, 0)})
+	w.PostError(errors.New("other"))
+
+	var reply struct {
+		Error map[string]string `json:"error"`
+	}
+	decoder := json.NewDecoder(buf)
+	c.Assert(decoder.Decode(&reply), IsNil)
+	c.Check(reply.Error["code"], Equals, "ERR_INVALID_AUTH")
+	reply.Error = nil
+	c.Assert(decoder.Decode(&reply), IsNil)
+	c.Check(reply.Error["code"], Equals, "ERR_RATE_LIMITED")
+	c.Check(reply.Error["reset"], Equals, "1500000000")
+	reply.Error = nil
+	c.Assert(decoder.Decode(&reply), IsNil)
+	c.Check(reply.Error, DeepEquals, map[string]string{"message": "other"})
+}
@@ -0,0 +1,88 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"log"
+)
+
+// PrivacyLevel determines how much of a message is shown on the
+// notification cards.
+type PrivacyLevel string
+
+const (
+	// PrivacyFull shows the cards as the plugins created them.
+	PrivacyFull PrivacyLevel = "full"
+	// PrivacySenderOnly shows who the message is from, but hides its
+	// content.
+	PrivacySenderOnly PrivacyLevel = "sender-only"
+	// PrivacyHidden replaces every card with a generic one.
+	PrivacyHidden PrivacyLevel = "hidden"
+)
+
+const privacyConfigName = "privacy"
+
+// privacyConfig is the format of the privacy configuration file, e.g.:
+//
+//	{ "level": "sender-only", "accounts": { "12": "hidden" } }
+//
+// Accounts not listed in Accounts use Level.
+type privacyConfig struct {
+	Level    PrivacyLevel          `json:"level"`
+	Accounts map[uint]PrivacyLevel `json:"accounts"`
+}
+
+// PrivacyLevelForAccount returns the privacy level configured by the user
+// for the given account. The configuration is read on every call, so that
+// changes are picked up on the next poll.
+func PrivacyLevelForAccount(accountId uint) PrivacyLevel {
+	var config privacyConfig
+	if err := loadConfig(privacyConfigName, &config); err != nil {
+		return PrivacyFull
+	}
+	level := config.Level
+	if l, ok := config.Accounts[accountId]; ok {
+		level = l
+	}
+	switch level {
+	case "", PrivacyFull, PrivacySenderOnly, PrivacyHidden:
+	default:
+		log.Print("Unknown privacy level ", level, ", showing full notifications")
+		return PrivacyFull
+	}
+	return level
+}
+
+// apply removes from the card of pm all the information that the privacy
+// level does not allow to show. The application payload and the actions are
+// left untouched, so that activating the card still opens the right item.
+func (l PrivacyLevel) apply(pm *PushMessage) {
+	card := pm.Notification.Card
+	if card == nil {
+		return
+	}
+	switch l {
+	case PrivacySenderOnly:
+		card.Body = ""
+	case PrivacyHidden:
+		// TRANSLATORS: This is the notification summary shown instead of the message details when these are hidden
+		card.Summary = Gettext("New message")
+		card.Body = ""
+		// The avatar would reveal the sender
+		card.Icon = ""
+	}
+}
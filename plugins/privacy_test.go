@@ -0,0 +1,82 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+func newPrivacyTestBatch() *PushMessageBatch {
+	pm := NewStandardPushMessage("Alice", "Lunch?\nAre you free today?", "https://example.com/thread/42", "file:///tmp/alice.png", 1)
+	pm.Message = `{"id":"42"}`
+	return &PushMessageBatch{
+		Messages: []*PushMessage{pm},
+		Limit:    10,
+		Tag:      "test",
+	}
+}
+
+func (s *S) TestPrivacyLevelDefault(c *C) {
+	c.Check(PrivacyLevelForAccount(1), Equals, PrivacyFull)
+}
+
+func (s *S) TestPrivacyLevelForAccount(c *C) {
+	s.writeConfig(c, privacyConfigName, `{"level": "sender-only", "accounts": {"3": "hidden"}}`)
+	c.Check(PrivacyLevelForAccount(1), Equals, PrivacySenderOnly)
+	c.Check(PrivacyLevelForAccount(3), Equals, PrivacyHidden)
+}
+
+func (s *S) TestPrivacyLevelInvalid(c *C) {
+	s.writeConfig(c, privacyConfigName, `{"level": "bogus"}`)
+	c.Check(PrivacyLevelForAccount(1), Equals, PrivacyFull)
+}
+
+func (s *S) TestPostMessagesPrivacyFull(c *C) {
+	w, buf := newTestIpc()
+	w.PostMessages([]*PushMessageBatch{newPrivacyTestBatch()}, PrivacyFull)
+	notifs := decodeNotifications(c, buf)
+	c.Assert(notifs, HasLen, 1)
+	card := notifs[0].Notification.Card
+	c.Check(card.Summary, Equals, "Alice")
+	c.Check(card.Body, Equals, "Lunch?\nAre you free today?")
+	c.Check(card.Icon, Equals, "file:///tmp/alice.png")
+}
+
+func (s *S) TestPostMessagesPrivacySenderOnly(c *C) {
+	w, buf := newTestIpc()
+	w.PostMessages([]*PushMessageBatch{newPrivacyTestBatch()}, PrivacySenderOnly)
+	notifs := decodeNotifications(c, buf)
+	c.Assert(notifs, HasLen, 1)
+	card := notifs[0].Notification.Card
+	c.Check(card.Summary, Equals, "Alice")
+	c.Check(card.Body, Equals, "")
+	c.Check(card.Icon, Equals, "file:///tmp/alice.png")
+	c.Check(notifs[0].Message, Equals, `{"id":"42"}`)
+}
+
+func (s *S) TestPostMessagesPrivacyHidden(c *C) {
+	w, buf := newTestIpc()
+	w.PostMessages([]*PushMessageBatch{newPrivacyTestBatch()}, PrivacyHidden)
+	notifs := decodeNotifications(c, buf)
+	c.Assert(notifs, HasLen, 1)
+	card := notifs[0].Notification.Card
+	c.Check(card.Summary, Equals, "New message")
+	c.Check(card.Body, Equals, "")
+	c.Check(card.Icon, Equals, "")
+	c.Check(card.Actions, DeepEquals, []string{"https://example.com/thread/42"})
+	c.Check(notifs[0].Message, Equals, `{"id":"42"}`)
+}
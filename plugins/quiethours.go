@@ -0,0 +1,202 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	quietHoursConfigName = "quiet-hours"
+	quietHoursStateName  = "quiet-hours"
+	quietHoursTag        = "quiet-hours"
+	clockFormat          = "15:04"
+)
+
+// QuietHours is the do-not-disturb schedule set by the user. During quiet
+// hours the cards are still stored in the notification center, but they
+// don't pop up, play sounds or vibrate. Example configuration:
+//
+//	{
+//	  "schedule": {
+//	    "default": [ { "start": "22:30", "end": "07:00" } ],
+//	    "saturday": [ { "start": "00:00", "end": "10:00" } ]
+//	  },
+//	  "silenceVip": false
+//	}
+//
+// The keys of Schedule are lowercase English weekday names; the periods
+// listed under "default" apply to the days which are not listed. A period
+// ending before its start time ends on the following day.
+type QuietHours struct {
+	Schedule map[string][]QuietPeriod `json:"schedule"`
+	// SilenceVip also silences the notifications matching VIP rules,
+	// which otherwise break through.
+	SilenceVip bool `json:"silenceVip"`
+}
+
+// QuietPeriod is a time range within a day, in "15:04" format.
+type QuietPeriod struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// quietHoursState keeps track of what happened during quiet hours, so
+// that the user can be told once they are over.
+type quietHoursState struct {
+	Suppressed int `json:"suppressed"`
+}
+
+// LoadQuietHours reads the quiet hours schedule from the configuration
+// file; nil is returned if there's none.
+func LoadQuietHours() *QuietHours {
+	var q QuietHours
+	if err := loadConfig(quietHoursConfigName, &q); err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("Cannot load quiet hours: ", err)
+		}
+		return nil
+	}
+	return &q
+}
+
+// IsQuiet tells whether t falls within quiet hours.
+func (q *QuietHours) IsQuiet(t time.Time) bool {
+	if q == nil {
+		return false
+	}
+	minutes := t.Hour()*60 + t.Minute()
+	for _, p := range q.periods(t.Weekday()) {
+		start, end, ok := p.minutes()
+		if !ok {
+			continue
+		}
+		if start <= end {
+			if minutes >= start && minutes < end {
+				return true
+			}
+		} else if minutes >= start {
+			return true
+		}
+	}
+	// Periods of the day before might extend past midnight
+	yesterday := (t.Weekday() + 6) % 7
+	for _, p := range q.periods(yesterday) {
+		start, end, ok := p.minutes()
+		if ok && start > end && minutes < end {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *QuietHours) periods(day time.Weekday) []QuietPeriod {
+	if periods, ok := q.Schedule[strings.ToLower(day.String())]; ok {
+		return periods
+	}
+	return q.Schedule["default"]
+}
+
+// minutes returns the start and end of the period, in minutes since
+// midnight.
+func (p QuietPeriod) minutes() (start, end int, ok bool) {
+	s, err := time.Parse(clockFormat, p.Start)
+	if err != nil {
+		log.Print("Invalid quiet hours start time ", p.Start)
+		return 0, 0, false
+	}
+	e, err := time.Parse(clockFormat, p.End)
+	if err != nil {
+		log.Print("Invalid quiet hours end time ", p.End)
+		return 0, 0, false
+	}
+	return s.Hour()*60 + s.Minute(), e.Hour()*60 + e.Minute(), true
+}
+
+// apply silences the batches if now is within quiet hours, and remembers
+// how many notifications were silenced. After quiet hours are over, a
+// batch with a summary of what was silenced is added.
+func (q *QuietHours) apply(accountId uint, now time.Time, batches []*PushMessageBatch) []*PushMessageBatch {
+	if q == nil {
+		return batches
+	}
+	var state quietHoursState
+	// a missing state just means that nothing was silenced
+	FromPersist(quietHoursStateName, accountId, &state)
+
+	if q.IsQuiet(now) {
+		silenced := 0
+		for _, batch := range batches {
+			batch.silent = true
+			batch.silenceVip = q.SilenceVip
+			for _, m := range batch.Messages {
+				if q.SilenceVip || !m.isVip() {
+					silenced++
+				}
+			}
+		}
+		if silenced > 0 {
+			state.Suppressed += silenced
+			if err := Persist(quietHoursStateName, accountId, state); err != nil {
+				log.Print("Cannot save quiet hours state: ", err)
+			}
+		}
+		return batches
+	}
+
+	if state.Suppressed > 0 {
+		batches = append(batches, &PushMessageBatch{
+			Messages: []*PushMessage{quietHoursSummary(state.Suppressed)},
+			Limit:    1,
+			Tag:      quietHoursTag,
+			Priority: PRIORITY_MAXIMUM,
+		})
+		state.Suppressed = 0
+		if err := Persist(quietHoursStateName, accountId, state); err != nil {
+			log.Print("Cannot save quiet hours state: ", err)
+		}
+	}
+	return batches
+}
+
+// quietHoursSummary creates the notification shown when quiet hours end.
+func quietHoursSummary(count int) *PushMessage {
+	// TRANSLATORS: the %d refers to the number of notifications received while in quiet hours
+	summary := fmt.Sprintf(NGettext("%d notification arrived during quiet hours", "%d notifications arrived during quiet hours", uint64(count)), count)
+	pm := NewStandardPushMessage(summary, "", "", "", time.Now().Unix())
+	pm.Notification.Card.Actions = nil
+	pm.Notification.Card.Persist = false
+	pm.Notification.Sound = ""
+	pm.Notification.Vibrate = false
+	pm.Notification.Tag = quietHoursTag
+	return pm
+}
+
+// silence turns the notification into a silent one, which is only stored
+// in the notification center.
+func (pm *PushMessage) silence() {
+	n := &pm.Notification
+	n.Sound = ""
+	n.Vibrate = false
+	if n.Card != nil {
+		n.Card.Popup = false
+	}
+}
@@ -0,0 +1,144 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+const testQuietHours = `
+{
+  "schedule": {
+    "default": [ { "start": "22:30", "end": "07:00" } ],
+    "saturday": [ { "start": "00:00", "end": "10:00" } ],
+    "sunday": []
+  }
+}`
+
+// 2017-06-05 is a Monday
+func monday(clock string) time.Time {
+	t, _ := time.ParseInLocation("2006-01-02 15:04", "2017-06-05 "+clock, time.Local)
+	return t
+}
+
+func (s *S) TestQuietHoursMissing(c *C) {
+	q := LoadQuietHours()
+	c.Check(q, IsNil)
+	c.Check(q.IsQuiet(monday("03:00")), Equals, false)
+}
+
+func (s *S) TestQuietHoursIsQuiet(c *C) {
+	s.writeConfig(c, quietHoursConfigName, testQuietHours)
+	q := LoadQuietHours()
+	c.Assert(q, NotNil)
+
+	checks := []struct {
+		t     time.Time
+		quiet bool
+	}{
+		{monday("03:00"), false}, // the night from sunday is free
+		{monday("12:00"), false},
+		{monday("22:29"), false},
+		{monday("22:30"), true},
+		{monday("23:59"), true},
+		{monday("00:00").AddDate(0, 0, 1), true},  // tuesday
+		{monday("06:59").AddDate(0, 0, 1), true},  // tuesday
+		{monday("07:00").AddDate(0, 0, 1), false}, // tuesday
+		{monday("09:00").AddDate(0, 0, 5), true},  // saturday
+		{monday("10:00").AddDate(0, 0, 5), false}, // saturday
+		{monday("23:00").AddDate(0, 0, 5), false}, // saturday
+		{monday("23:00").AddDate(0, 0, 6), false}, // sunday
+	}
+	for _, check := range checks {
+		c.Check(q.IsQuiet(check.t), Equals, check.quiet, Commentf("%v", check.t))
+	}
+}
+
+func newQuietHoursTestBatch() *PushMessageBatch {
+	boss := newRulesTestMessage("boss@example.com", "Call me", "t1")
+	boss.rule = &Rule{Vip: true}
+	return &PushMessageBatch{
+		Messages: []*PushMessage{
+			boss,
+			newRulesTestMessage("a@example.com", "Hi", "t2"),
+		},
+		Limit: 1,
+		OverflowHandler: func(msgs []*PushMessage) *PushMessage {
+			return NewStandardPushMessage("overflow", "", "action", "", 1)
+		},
+		Tag: "test",
+	}
+}
+
+func (s *S) TestQuietHoursSilence(c *C) {
+	s.writeConfig(c, quietHoursConfigName, testQuietHours)
+	q := LoadQuietHours()
+
+	w, buf := newTestIpc()
+	// The batch must not overflow for the VIP message to pop up
+	batch := newQuietHoursTestBatch()
+	batch.Limit = 2
+	batches := q.apply(1, monday("23:00"), []*PushMessageBatch{batch})
+	w.PostMessages(batches, PrivacyFull)
+	notifs := decodeNotifications(c, buf)
+	c.Assert(notifs, HasLen, 2)
+
+	// VIP breaks through
+	c.Check(notifs[0].Notification.Card.Popup, Equals, true)
+	c.Check(notifs[0].Notification.Sound, Equals, DefaultSound())
+	for _, n := range notifs[1:] {
+		c.Check(n.Notification.Card.Popup, Equals, false)
+		c.Check(n.Notification.Sound, Equals, "")
+		c.Check(n.Notification.Vibrate, Equals, false)
+	}
+	c.Check(notifs[1].Notification.Card.Persist, Equals, true)
+
+	// Once quiet hours are over, a summary is shown
+	batches = q.apply(1, monday("08:00").AddDate(0, 0, 1), nil)
+	c.Assert(batches, HasLen, 1)
+	c.Assert(batches[0].Messages, HasLen, 1)
+	card := batches[0].Messages[0].Notification.Card
+	c.Check(card.Summary, Equals, "1 notification arrived during quiet hours")
+	c.Check(card.Popup, Equals, true)
+	c.Check(card.Persist, Equals, false)
+
+	// ...only once
+	batches = q.apply(1, monday("09:00").AddDate(0, 0, 1), nil)
+	c.Check(batches, HasLen, 0)
+}
+
+func (s *S) TestQuietHoursSilenceVip(c *C) {
+	q := &QuietHours{
+		Schedule:   map[string][]QuietPeriod{"default": {{"00:00", "23:59"}}},
+		SilenceVip: true,
+	}
+	w, buf := newTestIpc()
+	batches := q.apply(1, monday("12:00"), []*PushMessageBatch{newQuietHoursTestBatch()})
+	w.PostMessages(batches, PrivacyFull)
+	notifs := decodeNotifications(c, buf)
+	c.Assert(notifs, HasLen, 3)
+	for _, n := range notifs {
+		c.Check(n.Notification.Card.Popup, Equals, false)
+		c.Check(n.Notification.Sound, Equals, "")
+	}
+
+	batches = q.apply(1, monday("23:59"), nil)
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Messages[0].Notification.Card.Summary, Equals, "2 notifications arrived during quiet hours")
+}
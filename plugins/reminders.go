@@ -0,0 +1,188 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	remindersConfigName = "reminders"
+	reminderTag         = "reminder"
+	reminderDispatchUrl = "calendar://startdate=%s"
+	// defaultReminderWindow is used when the configuration doesn't set
+	// the window
+	defaultReminderWindow = 15
+	maxReminders          = 4
+)
+
+// ReminderSettings enables the reminders of the calendar plugins, which
+// show a card before the events start even if no calendar application is
+// running. Example configuration:
+//
+//	{
+//	  "defaultLeadTime": 10,
+//	  "window": 15
+//	}
+//
+// DefaultLeadTime is how many minutes before the start the events without
+// alarms are reminded of; with 0 only the events with alarms are. Window
+// is how many minutes ahead of the poll the reminders are shown, and
+// should match the polling interval.
+type ReminderSettings struct {
+	DefaultLeadTime int `json:"defaultLeadTime"`
+	Window          int `json:"window"`
+}
+
+// LoadReminderSettings reads the reminder settings from the configuration
+// file; nil is returned if there's none, meaning that the reminders are
+// disabled.
+func LoadReminderSettings() *ReminderSettings {
+	var r ReminderSettings
+	if err := loadConfig(remindersConfigName, &r); err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("Cannot load reminder settings: ", err)
+		}
+		return nil
+	}
+	if r.Window <= 0 {
+		r.Window = defaultReminderWindow
+	}
+	return &r
+}
+
+// LeadTime returns the time before the start of the events without
+// alarms at which they are reminded of, or 0 for none.
+func (r *ReminderSettings) LeadTime() time.Duration {
+	return time.Duration(r.DefaultLeadTime) * time.Minute
+}
+
+// Reminder is an upcoming event instance to be reminded of
+type Reminder struct {
+	// InstanceId identifies the event instance; with Time, it makes
+	// sure that each alarm is only shown once
+	InstanceId string
+	Summary    string
+	Location   string
+	Start      time.Time
+	AllDay     bool
+	// Time is when the reminder is due
+	Time time.Time
+}
+
+// ReminderInstanceId identifies an instance of a recurring event
+func ReminderInstanceId(uid string, start time.Time) string {
+	return fmt.Sprintf("%s@%d", uid, start.Unix())
+}
+
+// sentKey identifies the alarm of the event instance in RemindersSent
+func (r Reminder) sentKey() string {
+	return fmt.Sprintf("%s@%d", r.InstanceId, r.Time.Unix())
+}
+
+// RemindersSent records the alarms of the event instances which were
+// reminded of, with the start time of the instance in seconds since the
+// epoch. The plugins keep it in their persisted state.
+type RemindersSent map[string]int64
+
+// Due returns the reminders which are due within the window after now and
+// were not sent yet, and records them as sent. When several alarms of an
+// instance are due at once, a single reminder is returned. The instances
+// which have already started are forgotten.
+func (sent RemindersSent) Due(reminders []Reminder, now time.Time, window time.Duration) []Reminder {
+	for key, start := range sent {
+		if start < now.Unix() {
+			delete(sent, key)
+		}
+	}
+
+	var due []Reminder
+	reminded := make(map[string]bool)
+	for _, r := range reminders {
+		if !r.Start.After(now) || r.Time.After(now.Add(window)) {
+			continue
+		}
+		key := r.sentKey()
+		if _, ok := sent[key]; ok {
+			continue
+		}
+		sent[key] = r.Start.Unix()
+		if reminded[r.InstanceId] {
+			continue
+		}
+		reminded[r.InstanceId] = true
+		due = append(due, r)
+	}
+	sort.Sort(byStart(due))
+	return due
+}
+
+type byStart []Reminder
+
+func (r byStart) Len() int           { return len(r) }
+func (r byStart) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byStart) Less(i, j int) bool { return r[i].Start.Before(r[j].Start) }
+
+// ReminderBatch creates the batch of reminder cards, or returns nil if
+// there are no reminders.
+func ReminderBatch(reminders []Reminder) *PushMessageBatch {
+	if len(reminders) == 0 {
+		return nil
+	}
+	messages := make([]*PushMessage, len(reminders))
+	for i, r := range reminders {
+		messages[i] = reminderMessage(r)
+	}
+	return &PushMessageBatch{
+		Messages:        messages,
+		Limit:           maxReminders,
+		OverflowHandler: reminderOverflow,
+		Tag:             reminderTag,
+		Priority:        PRIORITY_HIGH,
+	}
+}
+
+func reminderMessage(r Reminder) *PushMessage {
+	summary := r.Summary
+	if summary == "" {
+		summary = Gettext("Untitled event")
+	}
+	var body string
+	if r.AllDay {
+		body = Gettext("All day")
+	} else {
+		// TRANSLATORS: the %s is the time at which the event starts
+		body = fmt.Sprintf(Gettext("Starts at %s"), r.Start.Local().Format("15:04"))
+	}
+	if r.Location != "" {
+		body += "\n" + r.Location
+	}
+	action := fmt.Sprintf(reminderDispatchUrl, r.Start.UTC().Format(time.RFC3339))
+	return NewStandardPushMessage(summary, body, action, "", r.Time.Unix())
+}
+
+func reminderOverflow(pushMsg []*PushMessage) *PushMessage {
+	count := len(pushMsg)
+	// TRANSLATORS: the %d refers to the number of events starting soon
+	summary := fmt.Sprintf(NGettext("%d upcoming event", "%d upcoming events", uint64(count)), count)
+	action := fmt.Sprintf(reminderDispatchUrl, time.Now().UTC().Format(time.RFC3339))
+	return NewStandardPushMessage(summary, "", action, "", time.Now().Unix())
+}
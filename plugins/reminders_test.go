@@ -0,0 +1,136 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+func (s *S) TestReminderSettings(c *C) {
+	c.Check(LoadReminderSettings(), IsNil)
+
+	s.writeConfig(c, remindersConfigName, `{"defaultLeadTime": 10}`)
+	r := LoadReminderSettings()
+	c.Assert(r, NotNil)
+	c.Check(r.LeadTime(), Equals, 10*time.Minute)
+	c.Check(r.Window, Equals, defaultReminderWindow)
+
+	s.writeConfig(c, remindersConfigName, `{"window": 5}`)
+	r = LoadReminderSettings()
+	c.Assert(r, NotNil)
+	c.Check(r.LeadTime(), Equals, time.Duration(0))
+	c.Check(r.Window, Equals, 5)
+}
+
+func newTestReminder(uid string, start time.Time, lead time.Duration) Reminder {
+	return Reminder{
+		InstanceId: ReminderInstanceId(uid, start),
+		Summary:    uid,
+		Start:      start,
+		Time:       start.Add(-lead),
+	}
+}
+
+func (s *S) TestRemindersDue(c *C) {
+	now := time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC)
+	window := 15 * time.Minute
+	sent := RemindersSent{
+		// forgotten, since the event started
+		newTestReminder("old", now.Add(-time.Hour), 0).sentKey(): now.Add(-time.Hour).Unix(),
+	}
+	reminders := []Reminder{
+		newTestReminder("later", now.Add(2*time.Hour), 10*time.Minute),
+		newTestReminder("standup", now.Add(20*time.Minute), 10*time.Minute),
+		newTestReminder("started", now.Add(-5*time.Minute), 10*time.Minute),
+		newTestReminder("lunch", now.Add(10*time.Minute), 30*time.Minute),
+		// a second alarm of the same instance
+		newTestReminder("lunch", now.Add(10*time.Minute), 5*time.Minute),
+		// the next instance of a recurring event
+		newTestReminder("standup", now.Add(24*time.Hour+20*time.Minute), 10*time.Minute),
+	}
+
+	// Both alarms of lunch are due, but it's only reminded of once
+	due := sent.Due(reminders, now, window)
+	c.Assert(due, HasLen, 2)
+	c.Check(due[0].Summary, Equals, "lunch")
+	c.Check(due[1].Summary, Equals, "standup")
+	c.Check(sent, DeepEquals, RemindersSent{
+		reminders[3].sentKey(): now.Add(10 * time.Minute).Unix(),
+		reminders[4].sentKey(): now.Add(10 * time.Minute).Unix(),
+		reminders[1].sentKey(): now.Add(20 * time.Minute).Unix(),
+	})
+
+	// The reminders are only sent once
+	c.Check(sent.Due(reminders, now.Add(5*time.Minute), window), HasLen, 0)
+	due = sent.Due(reminders, now.Add(24*time.Hour), window)
+	c.Assert(due, HasLen, 1)
+	c.Check(due[0].Start, Equals, now.Add(24*time.Hour+20*time.Minute))
+	c.Check(sent, HasLen, 1)
+}
+
+func (s *S) TestRemindersDueEachAlarm(c *C) {
+	now := time.Date(2017, 6, 5, 10, 0, 0, 0, time.UTC)
+	window := 15 * time.Minute
+	start := now.Add(24 * time.Hour)
+	reminders := []Reminder{
+		newTestReminder("review", start, 24*time.Hour),
+		newTestReminder("review", start, 15*time.Minute),
+	}
+
+	sent := make(RemindersSent)
+	due := sent.Due(reminders, now, window)
+	c.Assert(due, HasLen, 1)
+	c.Check(due[0].Time, Equals, now)
+	c.Check(sent.Due(reminders, now.Add(time.Hour), window), HasLen, 0)
+
+	// The second alarm is shown too, once
+	due = sent.Due(reminders, start.Add(-20*time.Minute), window)
+	c.Assert(due, HasLen, 1)
+	c.Check(due[0].Time, Equals, start.Add(-15*time.Minute))
+	c.Check(sent.Due(reminders, start.Add(-5*time.Minute), window), HasLen, 0)
+}
+
+func (s *S) TestReminderBatch(c *C) {
+	c.Check(ReminderBatch(nil), IsNil)
+
+	start := time.Date(2017, 6, 5, 10, 0, 0, 0, time.Local)
+	meeting := newTestReminder("Meeting", start, 10*time.Minute)
+	meeting.Location = "Room 2"
+	holiday := newTestReminder("", start, time.Hour)
+	holiday.AllDay = true
+
+	batch := ReminderBatch([]Reminder{meeting, holiday})
+	c.Assert(batch, NotNil)
+	c.Check(batch.Tag, Equals, reminderTag)
+	c.Check(batch.Priority, Equals, PRIORITY_HIGH)
+	c.Assert(batch.Messages, HasLen, 2)
+
+	card := batch.Messages[0].Notification.Card
+	c.Check(card.Summary, Equals, "Meeting")
+	c.Check(card.Body, Equals, "Starts at 10:00\nRoom 2")
+	c.Check(card.Timestamp, Equals, start.Add(-10*time.Minute).Unix())
+	c.Check(card.Actions, DeepEquals, []string{"calendar://startdate=" + start.UTC().Format(time.RFC3339)})
+
+	card = batch.Messages[1].Notification.Card
+	c.Check(card.Summary, Equals, "Untitled event")
+	c.Check(card.Body, Equals, "All day")
+
+	overflow := batch.OverflowHandler(batch.Messages)
+	c.Check(overflow.Notification.Card.Summary, Equals, "2 upcoming events")
+}
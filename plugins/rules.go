@@ -0,0 +1,230 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const rulesConfigName = "rules"
+
+// MessageFields holds the structured details of a message which the
+// notification rules are matched against. Plugins fill it in when creating
+// a PushMessage, so that rules don't need to look at the card text.
+type MessageFields struct {
+	// Senders holds the email addresses or user names of the senders;
+	// notifications grouping several messages list all of them.
+	Senders []string
+	// Subject is the message subject, if the service has one.
+	Subject string
+	// Body is the message text, or an excerpt of it.
+	Body string
+	// Thread identifies the thread or conversation of the message.
+	Thread string
+	// Labels holds the service-defined labels or categories.
+	Labels []string
+}
+
+// Rule describes which messages it applies to and how their notifications
+// are to be changed. All the criteria which are set must match; a rule
+// without any criteria matches every message.
+type Rule struct {
+	Name string `json:"name"`
+
+	// Accounts restricts the rule to the given account ids.
+	Accounts []uint `json:"accounts,omitempty"`
+	// Senders holds shell patterns matched against each sender, ignoring
+	// case, e.g. "boss@example.com" or "*@example.com".
+	Senders []string `json:"senders,omitempty"`
+	// Keywords are searched in the subject and in the body, ignoring case.
+	Keywords []string `json:"keywords,omitempty"`
+	// Threads holds thread or conversation ids.
+	Threads []string `json:"threads,omitempty"`
+	// Labels matches if the message has any of them.
+	Labels []string `json:"labels,omitempty"`
+
+	// Mute drops the notification altogether.
+	Mute bool `json:"mute,omitempty"`
+	// Vip notifications come first in their batch, raise it to the high
+	// priority and pop up, play a sound and vibrate, unless otherwise
+	// stated below. Like the others, only the first one of a batch plays
+	// a sound, and none pops up when the batch overflows.
+	Vip bool `json:"vip,omitempty"`
+	// Sound, Vibrate and Popup, when set, override the default behaviour.
+	Sound   *bool `json:"sound,omitempty"`
+	Vibrate *bool `json:"vibrate,omitempty"`
+	Popup   *bool `json:"popup,omitempty"`
+}
+
+// Rules is the list of rules defined by the user, in order of evaluation.
+type Rules []*Rule
+
+// LoadRules reads the user defined rules from the configuration file. An
+// empty list is returned if no rules are defined.
+func LoadRules() Rules {
+	var config struct {
+		Rules Rules `json:"rules"`
+	}
+	if err := loadConfig(rulesConfigName, &config); err != nil {
+		if !os.IsNotExist(err) {
+			log.Print("Cannot load notification rules: ", err)
+		}
+		return nil
+	}
+	return config.Rules
+}
+
+// Match returns the first rule matching the message, or nil if none does.
+func (rules Rules) Match(accountId uint, fields *MessageFields) *Rule {
+	for _, r := range rules {
+		if r.Match(accountId, fields) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Match tells whether the rule applies to the message described by fields.
+// Messages without fields only match rules without message criteria.
+func (r *Rule) Match(accountId uint, fields *MessageFields) bool {
+	if len(r.Accounts) > 0 && !containsAccount(r.Accounts, accountId) {
+		return false
+	}
+	if fields == nil {
+		fields = &MessageFields{}
+	}
+	if len(r.Senders) > 0 && !matchAny(r.Senders, fields.Senders, matchPattern) {
+		return false
+	}
+	if len(r.Keywords) > 0 {
+		text := []string{fields.Subject, fields.Body}
+		if !matchAny(r.Keywords, text, containsFold) {
+			return false
+		}
+	}
+	if len(r.Threads) > 0 && !matchAny(r.Threads, []string{fields.Thread}, strings.EqualFold) {
+		return false
+	}
+	if len(r.Labels) > 0 && !matchAny(r.Labels, fields.Labels, strings.EqualFold) {
+		return false
+	}
+	return true
+}
+
+// apply evaluates the rules against the messages of each batch, dropping
+// the muted ones and remembering the matching rule for the others. Batches
+// left empty are removed.
+func (rules Rules) apply(accountId uint, batches []*PushMessageBatch) []*PushMessageBatch {
+	if len(rules) == 0 {
+		return batches
+	}
+	var result []*PushMessageBatch
+	for _, batch := range batches {
+		var messages []*PushMessage
+		for _, m := range batch.Messages {
+			m.rule = rules.Match(accountId, m.Fields)
+			if m.rule != nil && m.rule.Mute {
+				log.Print("Muting notification by rule ", m.rule.Name)
+				continue
+			}
+			messages = append(messages, m)
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		// VIP messages come first, so that they get the sound
+		sort.Stable(vipFirst(messages))
+		batch.Messages = messages
+		result = append(result, batch)
+	}
+	return result
+}
+
+// applyRule changes the notification according to the rule which matched
+// the message, if any.
+func (pm *PushMessage) applyRule() {
+	r := pm.rule
+	if r == nil {
+		return
+	}
+	n := &pm.Notification
+	if r.Vip {
+		n.Sound = DefaultSound()
+		n.Vibrate = true
+		if n.Card != nil {
+			n.Card.Popup = true
+		}
+	}
+	if r.Sound != nil {
+		n.Sound = ""
+		if *r.Sound {
+			n.Sound = DefaultSound()
+		}
+	}
+	if r.Vibrate != nil {
+		n.Vibrate = *r.Vibrate
+	}
+	if r.Popup != nil && n.Card != nil {
+		n.Card.Popup = *r.Popup
+	}
+}
+
+type vipFirst []*PushMessage
+
+func (m vipFirst) Len() int      { return len(m) }
+func (m vipFirst) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m vipFirst) Less(i, j int) bool {
+	return m[i].isVip() && !m[j].isVip()
+}
+
+func (pm *PushMessage) isVip() bool {
+	return pm.rule != nil && pm.rule.Vip
+}
+
+func containsAccount(accounts []uint, accountId uint) bool {
+	for _, a := range accounts {
+		if a == accountId {
+			return true
+		}
+	}
+	return false
+}
+
+// matchAny tells whether any of the values matches any of the criteria
+func matchAny(criteria, values []string, match func(criterion, value string) bool) bool {
+	for _, c := range criteria {
+		for _, v := range values {
+			if match(c, v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern, value string) bool {
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return ok && err == nil
+}
+
+func containsFold(keyword, text string) bool {
+	return strings.Contains(strings.ToLower(text), strings.ToLower(keyword))
+}
@@ -0,0 +1,209 @@
+/*
+ Copyright 2017 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package plugins
+
+import (
+	. "launchpad.net/gocheck"
+)
+
+const testRules = `
+{
+  "rules": [
+    { "name": "boss", "senders": ["boss@example.com"], "vip": true },
+    { "name": "spam", "accounts": [2], "senders": ["*@spam.example.com"], "mute": true },
+    { "name": "release", "keywords": ["Release"], "sound": false },
+    { "name": "noisy thread", "threads": ["t1"], "mute": true },
+    { "name": "social", "labels": ["CATEGORY_SOCIAL"], "popup": false, "vibrate": false }
+  ]
+}`
+
+func newRulesTestMessage(sender, subject, thread string, labels ...string) *PushMessage {
+	pm := NewStandardPushMessage(sender, subject, "action", "", 1)
+	pm.Fields = &MessageFields{
+		Senders: []string{sender},
+		Subject: subject,
+		Thread:  thread,
+		Labels:  labels,
+	}
+	return pm
+}
+
+func (s *S) TestLoadRulesMissing(c *C) {
+	c.Check(LoadRules(), HasLen, 0)
+}
+
+func (s *S) TestRulesMatch(c *C) {
+	s.writeConfig(c, rulesConfigName, testRules)
+	rules := LoadRules()
+	c.Assert(rules, HasLen, 5)
+
+	matches := []struct {
+		accountId uint
+		fields    *MessageFields
+		rule      string
+	}{
+		{1, &MessageFields{Senders: []string{"Boss@Example.com"}}, "boss"},
+		{1, &MessageFields{Senders: []string{"x@example.com", "boss@example.com"}}, "boss"},
+		{2, &MessageFields{Senders: []string{"offers@spam.example.com"}}, "spam"},
+		{1, &MessageFields{Senders: []string{"offers@spam.example.com"}}, ""},
+		{1, &MessageFields{Subject: "New release out"}, "release"},
+		{1, &MessageFields{Body: "the RELEASE is tomorrow"}, "release"},
+		{1, &MessageFields{Thread: "t1"}, "noisy thread"},
+		{1, &MessageFields{Labels: []string{"INBOX", "CATEGORY_SOCIAL"}}, "social"},
+		{1, &MessageFields{Senders: []string{"friend@example.com"}}, ""},
+		{1, nil, ""},
+	}
+	for _, m := range matches {
+		r := rules.Match(m.accountId, m.fields)
+		if m.rule == "" {
+			c.Check(r, IsNil, Commentf("%v", m.fields))
+		} else if c.Check(r, NotNil, Commentf("%v", m.fields)) {
+			c.Check(r.Name, Equals, m.rule)
+		}
+	}
+}
+
+func (s *S) TestRuleWithoutCriteria(c *C) {
+	r := &Rule{}
+	c.Check(r.Match(1, nil), Equals, true)
+	r.Accounts = []uint{2}
+	c.Check(r.Match(1, nil), Equals, false)
+	c.Check(r.Match(2, nil), Equals, true)
+}
+
+func (s *S) TestPostMessagesWithRules(c *C) {
+	s.writeConfig(c, rulesConfigName, testRules)
+	batch := &PushMessageBatch{
+		Messages: []*PushMessage{
+			newRulesTestMessage("friend@example.com", "Hi", "t0"),
+			newRulesTestMessage("someone@example.com", "Still going on", "t1"),
+			newRulesTestMessage("news@example.com", "Release notes", "t2"),
+			newRulesTestMessage("boss@example.com", "Meeting", "t3"),
+			newRulesTestMessage("photos@example.com", "Tagged", "t4", "CATEGORY_SOCIAL"),
+		},
+		Limit: 2,
+		OverflowHandler: func(msgs []*PushMessage) *PushMessage {
+			return NewStandardPushMessage("overflow", "", "action", "", 1)
+		},
+		Tag: "test",
+	}
+
+	w, buf := newTestIpc()
+	batches := LoadRules().apply(1, []*PushMessageBatch{batch})
+	w.PostMessages(batches, PrivacyFull)
+	notifs := decodeNotifications(c, buf)
+	c.Assert(notifs, HasLen, 5)
+
+	summaries := make([]string, len(notifs))
+	for i, n := range notifs {
+		summaries[i] = n.Notification.Card.Summary
+	}
+	c.Check(summaries, DeepEquals, []string{
+		"boss@example.com",
+		"friend@example.com",
+		"news@example.com",
+		"photos@example.com",
+		"overflow",
+	})
+
+	// The batch overflows, so even the VIP message doesn't pop up
+	boss := notifs[0].Notification
+	c.Check(boss.Card.Popup, Equals, false)
+	c.Check(boss.Sound, Equals, DefaultSound())
+	c.Check(boss.Vibrate, Equals, true)
+
+	friend := notifs[1].Notification
+	c.Check(friend.Card.Popup, Equals, false)
+	c.Check(friend.Sound, Equals, "")
+
+	news := notifs[2].Notification
+	c.Check(news.Card.Popup, Equals, false)
+	c.Check(news.Sound, Equals, "")
+
+	social := notifs[3].Notification
+	c.Check(social.Card.Popup, Equals, false)
+	c.Check(social.Vibrate, Equals, false)
+}
+
+func (s *S) TestPostMessagesVipOverflow(c *C) {
+	s.writeConfig(c, rulesConfigName, testRules)
+	newBatch := func(tag string, priority int, senders ...string) *PushMessageBatch {
+		batch := &PushMessageBatch{
+			Limit: 2,
+			OverflowHandler: func(msgs []*PushMessage) *PushMessage {
+				return NewStandardPushMessage(tag+" overflow", "", "action", "", 1)
+			},
+			Tag:      tag,
+			Priority: priority,
+		}
+		for _, sender := range senders {
+			batch.Messages = append(batch.Messages, newRulesTestMessage(sender, "Hi", "t0"))
+		}
+		return batch
+	}
+	batches := []*PushMessageBatch{
+		newBatch("other", PRIORITY_HIGH, "friend@example.com"),
+		newBatch("mail", PRIORITY_LOW, "boss@example.com", "boss@example.com", "boss@example.com"),
+	}
+	// The friend's message doesn't play a sound, which would use the
+	// budget
+	batches[0].Messages[0].Notification.Sound = ""
+	batches[0].Messages[0].Notification.Vibrate = false
+
+	w, buf := newTestIpc()
+	w.PostMessages(LoadRules().apply(1, batches), PrivacyFull)
+	notifs := decodeNotifications(c, buf)
+	c.Assert(notifs, HasLen, 5)
+
+	summaries := make([]string, len(notifs))
+	for i, n := range notifs {
+		summaries[i] = n.Notification.Card.Summary
+	}
+	// The VIP messages raise their batch to the high priority, but
+	// don't overtake the batch which was already there
+	c.Check(summaries, DeepEquals, []string{
+		"friend@example.com",
+		"boss@example.com",
+		"boss@example.com",
+		"boss@example.com",
+		"mail overflow",
+	})
+	c.Check(batches[1].Priority, Equals, PRIORITY_HIGH)
+
+	// Only the first VIP message plays the sound, and none pops up as
+	// the batch overflows
+	for i, n := range notifs[1:4] {
+		comment := Commentf("message %d", i)
+		c.Check(n.Notification.Card.Popup, Equals, false, comment)
+		c.Check(n.Notification.Vibrate, Equals, i == 0, comment)
+		if i == 0 {
+			c.Check(n.Notification.Sound, Equals, DefaultSound(), comment)
+		} else {
+			c.Check(n.Notification.Sound, Equals, "", comment)
+		}
+	}
+	c.Check(notifs[4].Notification.Card.Persist, Equals, false)
+}
+
+func (s *S) TestRulesMuteWholeBatch(c *C) {
+	rules := Rules{&Rule{Threads: []string{"t1"}, Mute: true}}
+	batch := &PushMessageBatch{
+		Messages: []*PushMessage{newRulesTestMessage("a@example.com", "Hi", "t1")},
+		Limit:    2,
+	}
+	c.Check(rules.apply(1, []*PushMessageBatch{batch}), HasLen, 0)
+}
@@ -0,0 +1,359 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+const (
+	maxIndividualActivities = 2
+	// maxLikedTweets is how many of the most recent tweets of the user
+	// are checked for new likes
+	maxLikedTweets = 10
+)
+
+// pollActivity retrieves the optional notifications enabled in the
+// configuration. Failures only prevent the notifications of that kind,
+// since they aren't as important as mentions and direct messages.
+func (p *twitterPlugin) pollActivity(authData *plugins.AuthData) (batches []*plugins.PushMessageBatch) {
+	kinds := []struct {
+		enabled bool
+		poll    func(*plugins.AuthData) (*plugins.PushMessageBatch, error)
+	}{
+		{p.config.Followers, p.pollFollowers},
+		{p.config.Likes, p.pollLikes},
+		{p.config.Retweets, p.pollRetweets},
+		{p.config.Quotes, p.pollQuotes},
+	}
+	for _, kind := range kinds {
+		if !kind.enabled {
+			continue
+		}
+		batch, err := kind.poll(authData)
+		if err != nil {
+			log.Print("twitter plugin ", authData.AccountId, ": ", err)
+			continue
+		}
+		if batch != nil && len(batch.Messages) > 0 {
+			batches = append(batches, batch)
+		}
+	}
+	return batches
+}
+
+// pollFollowers reports the users who followed the account since the last
+// follower seen. As with the other kinds, nothing is reported the first
+// time, nor when the last follower seen is not listed anymore: the new
+// followers can't be told apart then.
+func (p *twitterPlugin) pollFollowers(authData *plugins.AuthData) (*plugins.PushMessageBatch, error) {
+	query := url.Values{}
+	query.Set("user.fields", userFieldsV2)
+	path := fmt.Sprintf("users/%s/followers", url.QueryEscape(p.config.UserId))
+	var result struct {
+		Data []userV2 `json:"data"`
+	}
+	if err := p.getV2(authData, path, query, &result); err != nil {
+		return nil, err
+	}
+	if len(result.Data) == 0 {
+		return nil, nil
+	}
+
+	last := p.config.LastFollowerId
+	p.config.LastFollowerId = result.Data[0].Id
+	if last == "" {
+		return nil, nil
+	}
+
+	// Followers are listed newest first
+	count := -1
+	for i, u := range result.Data {
+		if u.Id == last {
+			count = i
+			break
+		}
+	}
+	if count < 0 {
+		log.Print("twitter plugin: last follower ", last, " not found, skipping the followers")
+		return nil, nil
+	}
+	var pushMsg []*plugins.PushMessage
+	for _, u := range result.Data[:count] {
+		// TRANSLATORS: The first %s refers to the twitter user's Name, the second %s to the username.
+		summary := fmt.Sprintf(plugins.Gettext("%s. @%s"), u.Name, u.Username)
+		// TRANSLATORS: This is the body of the notification about a new twitter follower
+		body := plugins.Gettext("Followed you")
+		action := fmt.Sprintf("%s/%s", twitterDispatchUrlBase, u.Username)
+		m := plugins.NewStandardPushMessage(summary, body, action, avatarIcon(u.Image), time.Now().Unix())
+		m.Fields = &plugins.MessageFields{
+			Senders: []string{u.Username},
+			Labels:  []string{"follower"},
+		}
+		pushMsg = append(pushMsg, m)
+	}
+	return &plugins.PushMessageBatch{
+		Messages:        pushMsg,
+		Limit:           maxIndividualActivities,
+		OverflowHandler: p.consolidateFollowers,
+		Tag:             "follower",
+		Priority:        plugins.PRIORITY_LOW,
+	}, nil
+}
+
+// pollLikes reports who liked the recent tweets of the user. Likes have no
+// id to track, so the like counts of the tweets are compared with the
+// ones seen on the previous poll.
+func (p *twitterPlugin) pollLikes(authData *plugins.AuthData) (*plugins.PushMessageBatch, error) {
+	query := url.Values{}
+	query.Set("tweet.fields", "created_at,public_metrics,entities,note_tweet")
+	query.Set("exclude", "retweets,replies")
+	query.Set("max_results", strconv.Itoa(maxLikedTweets))
+	path := fmt.Sprintf("users/%s/tweets", url.QueryEscape(p.config.UserId))
+	var result tweetsV2
+	if err := p.getV2(authData, path, query, &result); err != nil {
+		return nil, err
+	}
+
+	previous := p.config.LikeCounts
+	p.config.LikeCounts = make(map[string]int, len(result.Data))
+	var pushMsg []*plugins.PushMessage
+	for _, t := range result.Data {
+		count := t.PublicMetrics.LikeCount
+		p.config.LikeCounts[t.Id] = count
+		// A tweet which wasn't in the list yet is a new one
+		old := previous[t.Id]
+		if previous == nil || count <= old {
+			continue
+		}
+		tweet, err := t.status(nil)
+		if err != nil {
+			p.config.LikeCounts = previous
+			return nil, err
+		}
+
+		// Users are listed newest first
+		var likers struct {
+			Data []userV2 `json:"data"`
+		}
+		likersQuery := url.Values{}
+		likersQuery.Set("user.fields", userFieldsV2)
+		likersPath := fmt.Sprintf("tweets/%s/liking_users", url.QueryEscape(t.Id))
+		if err := p.getV2(authData, likersPath, likersQuery, &likers); err != nil {
+			// Try again next time
+			p.config.LikeCounts = previous
+			return nil, err
+		}
+		users := likers.Data
+		if len(users) > count-old {
+			users = users[:count-old]
+		}
+		for _, u := range users {
+			// TRANSLATORS: The first %s refers to the twitter user's Name, the second %s to the username.
+			summary := fmt.Sprintf(plugins.Gettext("%s. @%s"), u.Name, u.Username)
+			// TRANSLATORS: This is the body of the notification about a like, %s is the liked tweet
+			body := fmt.Sprintf(plugins.Gettext("Liked your tweet: %s"), tweet.body())
+			action := fmt.Sprintf("%s/%s/statuses/%s", twitterDispatchUrlBase, p.config.UserName, t.Id)
+			m := plugins.NewStandardPushMessage(summary, body, action, avatarIcon(u.Image), time.Now().Unix())
+			m.Fields = &plugins.MessageFields{
+				Senders: []string{u.Username},
+				Body:    tweet.body(),
+				Thread:  t.Id,
+				Labels:  []string{"like"},
+			}
+			pushMsg = append(pushMsg, m)
+		}
+	}
+	return &plugins.PushMessageBatch{
+		Messages:        pushMsg,
+		Limit:           maxIndividualActivities,
+		OverflowHandler: p.consolidateLikes,
+		Tag:             "like",
+		Priority:        plugins.PRIORITY_LOW,
+	}, nil
+}
+
+// pollRetweets reports the retweets of the tweets of the user
+func (p *twitterPlugin) pollRetweets(authData *plugins.AuthData) (*plugins.PushMessageBatch, error) {
+	tweets, err := p.searchV2(authData, "retweets_of:"+p.config.UserName, &p.config.LastRetweetId)
+	if err != nil || tweets == nil {
+		return nil, err
+	}
+
+	pushMsg := make([]*plugins.PushMessage, len(tweets))
+	for i, t := range tweets {
+		// TRANSLATORS: The first %s refers to the twitter user's Name, the second %s to the username.
+		summary := fmt.Sprintf(plugins.Gettext("%s. @%s"), t.User.Name, t.User.ScreenName)
+		// TRANSLATORS: This is the body of the notification about a retweet, %s is the retweeted tweet
+		body := fmt.Sprintf(plugins.Gettext("Retweeted your tweet: %s"), t.quoted.body())
+		action := fmt.Sprintf("%s/%s/statuses/%d", twitterDispatchUrlBase, t.User.ScreenName, t.Id)
+		pushMsg[i] = plugins.NewStandardPushMessage(summary, body, action, avatarIcon(t.User.Image), toEpoch(t.CreatedAt))
+		pushMsg[i].Fields = &plugins.MessageFields{
+			Senders: []string{t.User.ScreenName},
+			Body:    t.quoted.body(),
+			Thread:  strconv.FormatInt(t.quoted.Id, 10),
+			Labels:  []string{"retweet"},
+		}
+	}
+	return &plugins.PushMessageBatch{
+		Messages:        pushMsg,
+		Limit:           maxIndividualActivities,
+		OverflowHandler: p.consolidateRetweets,
+		Tag:             "retweet",
+		Priority:        plugins.PRIORITY_DEFAULT,
+	}, nil
+}
+
+// pollQuotes reports the tweets quoting the ones of the user
+func (p *twitterPlugin) pollQuotes(authData *plugins.AuthData) (*plugins.PushMessageBatch, error) {
+	query := fmt.Sprintf("is:quote url:\"twitter.com/%s/status\"", p.config.UserName)
+	tweets, err := p.searchV2(authData, query, &p.config.LastQuoteId)
+	if err != nil || tweets == nil {
+		return nil, err
+	}
+
+	pushMsg := make([]*plugins.PushMessage, len(tweets))
+	for i, t := range tweets {
+		// TRANSLATORS: The first %s refers to the twitter user's Name, the second %s to the username.
+		summary := fmt.Sprintf(plugins.Gettext("%s. @%s"), t.User.Name, t.User.ScreenName)
+		action := fmt.Sprintf("%s/%s/statuses/%d", twitterDispatchUrlBase, t.User.ScreenName, t.Id)
+		body := t.body()
+		pushMsg[i] = plugins.NewStandardPushMessage(summary, body, action, avatarIcon(t.User.Image), toEpoch(t.CreatedAt))
+		pushMsg[i].Fields = &plugins.MessageFields{
+			Senders: []string{t.User.ScreenName},
+			Body:    body,
+			Thread:  strconv.FormatInt(t.quoted.Id, 10),
+			Labels:  []string{"quote"},
+		}
+	}
+	return &plugins.PushMessageBatch{
+		Messages:        pushMsg,
+		Limit:           maxIndividualActivities,
+		OverflowHandler: p.consolidateQuotes,
+		Tag:             "quote",
+		Priority:        plugins.PRIORITY_DEFAULT,
+	}, nil
+}
+
+// referencingStatus is a tweet referring to one of the user
+type referencingStatus struct {
+	status
+	quoted status
+}
+
+// searchV2 returns the recent tweets matching the query newer than
+// *sinceId, newest first, and updates *sinceId. The first time, when
+// *sinceId is 0, nothing is returned.
+func (p *twitterPlugin) searchV2(authData *plugins.AuthData, search string, sinceId *int64) ([]referencingStatus, error) {
+	query := url.Values{}
+	query.Set("query", search)
+	query.Set("expansions", "author_id,referenced_tweets.id,entities.mentions.username")
+	query.Set("user.fields", userFieldsV2)
+	query.Set("tweet.fields", "created_at,referenced_tweets,entities,note_tweet")
+	if *sinceId > 0 {
+		query.Set("since_id", strconv.FormatInt(*sinceId, 10))
+	}
+	var result tweetsV2
+	if err := p.getV2(authData, "tweets/search/recent", query, &result); err != nil {
+		return nil, err
+	}
+
+	users := result.Includes.usersById()
+	referenced := make(map[string]tweetV2, len(result.Includes.Tweets))
+	for _, t := range result.Includes.Tweets {
+		referenced[t.Id] = t
+	}
+	var tweets []referencingStatus
+	for _, t := range result.Data {
+		s, err := t.status(users)
+		if err != nil {
+			return nil, err
+		}
+		r := referencingStatus{status: s}
+		for _, ref := range t.ReferencedTweets {
+			if ref.Type == "retweeted" || ref.Type == "quoted" {
+				quoted := referenced[ref.Id]
+				quoted.Id = ref.Id
+				if r.quoted, err = quoted.status(users); err != nil {
+					return nil, err
+				}
+			}
+		}
+		tweets = append(tweets, r)
+	}
+	sort.Sort(sort.Reverse(byReferencingId(tweets)))
+
+	first := *sinceId == 0
+	if len(tweets) > 0 && tweets[0].Id > *sinceId {
+		*sinceId = tweets[0].Id
+	}
+	if first {
+		return nil, nil
+	}
+	return tweets, nil
+}
+
+type byReferencingId []referencingStatus
+
+func (s byReferencingId) Len() int           { return len(s) }
+func (s byReferencingId) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byReferencingId) Less(i, j int) bool { return s[i].Id < s[j].Id }
+
+// consolidatedActivity creates the overflow notification for the given
+// summary, listing the users in the notifications.
+func consolidatedActivity(summary string, pushMsg []*plugins.PushMessage) *plugins.PushMessage {
+	users := make([]string, len(pushMsg))
+	for i, m := range pushMsg {
+		users[i] = m.Notification.Card.Summary
+	}
+	// TRANSLATORS: This represents a notification body with the comma separated twitter usernames
+	body := fmt.Sprintf(plugins.Gettext("From %s"), strings.Join(users, ", "))
+	action := fmt.Sprintf("%s/i/connect", twitterDispatchUrlBase)
+	return plugins.NewStandardPushMessage(summary, body, action, "", time.Now().Unix())
+}
+
+func (p *twitterPlugin) consolidateFollowers(pushMsg []*plugins.PushMessage) *plugins.PushMessage {
+	// TRANSLATORS: This represents a notification summary about new twitter followers, %d is their number
+	summary := fmt.Sprintf(plugins.NGettext("%d new follower", "%d new followers", uint64(len(pushMsg))), len(pushMsg))
+	return consolidatedActivity(summary, pushMsg)
+}
+
+func (p *twitterPlugin) consolidateLikes(pushMsg []*plugins.PushMessage) *plugins.PushMessage {
+	// TRANSLATORS: This represents a notification summary about new likes of twitter statuses, %d is their number
+	summary := fmt.Sprintf(plugins.NGettext("%d new like", "%d new likes", uint64(len(pushMsg))), len(pushMsg))
+	return consolidatedActivity(summary, pushMsg)
+}
+
+func (p *twitterPlugin) consolidateRetweets(pushMsg []*plugins.PushMessage) *plugins.PushMessage {
+	// TRANSLATORS: This represents a notification summary about new retweets, %d is their number
+	summary := fmt.Sprintf(plugins.NGettext("%d new retweet", "%d new retweets", uint64(len(pushMsg))), len(pushMsg))
+	return consolidatedActivity(summary, pushMsg)
+}
+
+func (p *twitterPlugin) consolidateQuotes(pushMsg []*plugins.PushMessage) *plugins.PushMessage {
+	// TRANSLATORS: This represents a notification summary about new quote tweets, %d is their number
+	summary := fmt.Sprintf(plugins.NGettext("%d new quote tweet", "%d new quote tweets", uint64(len(pushMsg))), len(pushMsg))
+	return consolidatedActivity(summary, pushMsg)
+}
@@ -0,0 +1,229 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"net/url"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+const (
+	followersBodyV2 = `
+{
+  "data": [
+    {"id": "6253282", "name": "Twitter API", "username": "TwitterAPI", "profile_image_url": "https://pbs.twimg.com/api_normal.jpg"},
+    {"id": "783214", "name": "Twitter", "username": "Twitter"},
+    {"id": "2244994945", "name": "Twitter Dev", "username": "TwitterDev"}
+  ]
+}`
+	ownTweetsBodyV2 = `
+{
+  "data": [
+    {"id": "1400000000000000002", "text": "Second tweet", "public_metrics": {"like_count": 3}},
+    {"id": "1400000000000000001", "text": "First tweet", "public_metrics": {"like_count": 5}}
+  ]
+}`
+	likingUsersBodyV2 = `
+{
+  "data": [
+    {"id": "6253282", "name": "Twitter API", "username": "TwitterAPI"},
+    {"id": "783214", "name": "Twitter", "username": "Twitter"},
+    {"id": "38895958", "name": "Sean Cook", "username": "theSeanCook"}
+  ]
+}`
+	retweetsBodyV2 = `
+{
+  "data": [
+    {
+      "id": "1400000000000000011",
+      "text": "RT @jasoncosta: First tweet",
+      "author_id": "783214",
+      "created_at": "2021-06-02T10:00:00.000Z",
+      "referenced_tweets": [{"type": "retweeted", "id": "1400000000000000001"}]
+    }
+  ],
+  "includes": {
+    "users": [{"id": "783214", "name": "Twitter", "username": "Twitter"}],
+    "tweets": [{"id": "1400000000000000001", "text": "First tweet"}]
+  }
+}`
+	quotesBodyV2 = `
+{
+  "data": [
+    {
+      "id": "1400000000000000021",
+      "text": "So true https://t.co/quoted",
+      "author_id": "6253282",
+      "created_at": "2021-06-02T11:00:00.000Z",
+      "referenced_tweets": [{"type": "quoted", "id": "1400000000000000002"}]
+    }
+  ],
+  "includes": {
+    "users": [{"id": "6253282", "name": "Twitter API", "username": "TwitterAPI"}],
+    "tweets": [{"id": "1400000000000000002", "text": "Second tweet"}]
+  }
+}`
+)
+
+func newActivityPlugin() *twitterPlugin {
+	return &twitterPlugin{config: twitterConfig{
+		UserId:   "2244994945",
+		UserName: "jasoncosta",
+	}}
+}
+
+func (s S) TestPollFollowers(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := serveV2(c, map[string]string{
+		"/2/users/2244994945/followers": followersBodyV2,
+	})
+	defer server.Close()
+
+	p := newActivityPlugin()
+	p.config.Followers = true
+	// Nothing is reported the first time
+	c.Check(p.pollActivity(&plugins.AuthData{}), HasLen, 0)
+	c.Check(p.config.LastFollowerId, Equals, "6253282")
+
+	p.config.LastFollowerId = "2244994945"
+	batches := p.pollActivity(&plugins.AuthData{})
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Tag, Equals, "follower")
+	c.Assert(batches[0].Messages, HasLen, 2)
+	card := batches[0].Messages[0].Notification.Card
+	c.Check(card.Summary, Equals, "Twitter API. @TwitterAPI")
+	c.Check(card.Body, Equals, "Followed you")
+	c.Check(card.Actions, DeepEquals, []string{"https://mobile.twitter.com/TwitterAPI"})
+	c.Check(p.config.LastFollowerId, Equals, "6253282")
+
+	consolidated := batches[0].OverflowHandler(batches[0].Messages)
+	c.Check(consolidated.Notification.Card.Summary, Equals, "2 new followers")
+	c.Check(consolidated.Notification.Card.Body, Equals, "From Twitter API. @TwitterAPI, Twitter. @Twitter")
+}
+
+func (s S) TestPollFollowersLastGone(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := serveV2(c, map[string]string{
+		"/2/users/2244994945/followers": followersBodyV2,
+	})
+	defer server.Close()
+
+	// The last follower seen unfollowed the account, so the ones
+	// listed can't be told apart from the new ones
+	p := newActivityPlugin()
+	p.config.Followers = true
+	p.config.LastFollowerId = "38895958"
+	c.Check(p.pollActivity(&plugins.AuthData{}), HasLen, 0)
+	c.Check(p.config.LastFollowerId, Equals, "6253282")
+}
+
+func (s S) TestPollLikes(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := serveV2(c, map[string]string{
+		"/2/users/2244994945/tweets":                 ownTweetsBodyV2,
+		"/2/tweets/1400000000000000001/liking_users": likingUsersBodyV2,
+	})
+	defer server.Close()
+
+	p := newActivityPlugin()
+	p.config.Likes = true
+	c.Check(p.pollActivity(&plugins.AuthData{}), HasLen, 0)
+	c.Check(p.config.LikeCounts, DeepEquals, map[string]int{
+		"1400000000000000001": 5,
+		"1400000000000000002": 3,
+	})
+
+	// Two new likes on the first tweet
+	p.config.LikeCounts["1400000000000000001"] = 3
+	batches := p.pollActivity(&plugins.AuthData{})
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Tag, Equals, "like")
+	c.Assert(batches[0].Messages, HasLen, 2)
+	card := batches[0].Messages[0].Notification.Card
+	c.Check(card.Summary, Equals, "Twitter API. @TwitterAPI")
+	c.Check(card.Body, Equals, "Liked your tweet: First tweet")
+	c.Check(card.Actions, DeepEquals, []string{"https://mobile.twitter.com/jasoncosta/statuses/1400000000000000001"})
+	c.Check(batches[0].Messages[1].Notification.Card.Summary, Equals, "Twitter. @Twitter")
+	c.Check(p.config.LikeCounts["1400000000000000001"], Equals, 5)
+
+	consolidated := batches[0].OverflowHandler(batches[0].Messages)
+	c.Check(consolidated.Notification.Card.Summary, Equals, "2 new likes")
+}
+
+func (s S) TestPollRetweets(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := serveV2(c, map[string]string{
+		"/2/tweets/search/recent": retweetsBodyV2,
+	})
+	defer server.Close()
+
+	p := newActivityPlugin()
+	p.config.Retweets = true
+	c.Check(p.pollActivity(&plugins.AuthData{}), HasLen, 0)
+	c.Check(p.config.LastRetweetId, Equals, int64(1400000000000000011))
+
+	p.config.LastRetweetId = 1400000000000000010
+	batches := p.pollActivity(&plugins.AuthData{})
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Tag, Equals, "retweet")
+	c.Assert(batches[0].Messages, HasLen, 1)
+	m := batches[0].Messages[0]
+	c.Check(m.Notification.Card.Summary, Equals, "Twitter. @Twitter")
+	c.Check(m.Notification.Card.Body, Equals, "Retweeted your tweet: First tweet")
+	c.Check(m.Fields.Thread, Equals, "1400000000000000001")
+
+	consolidated := batches[0].OverflowHandler(batches[0].Messages)
+	c.Check(consolidated.Notification.Card.Summary, Equals, "1 new retweet")
+}
+
+func (s S) TestPollQuotes(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := serveV2(c, map[string]string{
+		"/2/tweets/search/recent": quotesBodyV2,
+	})
+	defer server.Close()
+
+	p := newActivityPlugin()
+	p.config.Quotes = true
+	p.config.LastQuoteId = 1400000000000000020
+	batches := p.pollActivity(&plugins.AuthData{})
+	c.Assert(batches, HasLen, 1)
+	c.Check(batches[0].Tag, Equals, "quote")
+	c.Assert(batches[0].Messages, HasLen, 1)
+	m := batches[0].Messages[0]
+	c.Check(m.Notification.Card.Summary, Equals, "Twitter API. @TwitterAPI")
+	c.Check(m.Notification.Card.Body, Equals, "So true https://t.co/quoted")
+	c.Check(m.Notification.Card.Actions, DeepEquals, []string{"https://mobile.twitter.com/TwitterAPI/statuses/1400000000000000021"})
+	c.Check(m.Fields.Thread, Equals, "1400000000000000002")
+	c.Check(p.config.LastQuoteId, Equals, int64(1400000000000000021))
+
+	consolidated := batches[0].OverflowHandler(batches[0].Messages)
+	c.Check(consolidated.Notification.Card.Summary, Equals, "1 new quote tweet")
+}
+
+func (s S) TestPollActivityDisabled(c *C) {
+	// No request is made for the kinds which are not enabled
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := serveV2(c, map[string]string{})
+	defer server.Close()
+
+	p := newActivityPlugin()
+	c.Check(p.pollActivity(&plugins.AuthData{}), HasLen, 0)
+}
@@ -55,6 +55,12 @@
 // method is the only way to correctly sign a request if the application sets
 // the URL Opaque field when making a request.
 //
+// The signature method is chosen with the Client SignatureMethod field:
+// HMAC-SHA1 (the default), HMAC-SHA256, RSA-SHA1 with the Client PrivateKey,
+// or PLAINTEXT. Requests whose body is not a form, such as JSON documents,
+// are signed with SetBodyHashAuthorizationHeader, which includes a hash of
+// the body in the signature.
+//
 // The Get and Post methods sign and invoke a request using the supplied
 // net/http Client. These methods are easy to use, but not as flexible as
 // constructing a request using one of the low-level methods.
@@ -62,9 +68,12 @@ package oauth
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/hmac"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
@@ -232,14 +241,54 @@ func nonce() string {
 	return result
 }
 
+// SignatureMethod identifies a signature method.
+type SignatureMethod int
+
+const (
+	HMACSHA1   SignatureMethod = iota // HMAC-SHA1, the default
+	RSASHA1                           // RSA-SHA1
+	PLAINTEXT                         // Plain text
+	HMACSHA256                        // HMAC-SHA256
+)
+
+func (sm SignatureMethod) String() string {
+	switch sm {
+	case HMACSHA1:
+		return "HMAC-SHA1"
+	case RSASHA1:
+		return "RSA-SHA1"
+	case PLAINTEXT:
+		return "PLAINTEXT"
+	case HMACSHA256:
+		return "HMAC-SHA256"
+	}
+	return fmt.Sprintf("SignatureMethod(%d)", int(sm))
+}
+
+// bodyHash returns the value of the oauth_body_hash parameter for body, as
+// described in the OAuth Request Body Hash extension. The hash function is
+// the one of the signature method, or SHA-1 for PLAINTEXT.
+func (sm SignatureMethod) bodyHash(body []byte) string {
+	var sum []byte
+	if sm == HMACSHA256 {
+		h := sha256.Sum256(body)
+		sum = h[:]
+	} else {
+		h := sha1.Sum(body)
+		sum = h[:]
+	}
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
 // oauthParams returns the OAuth request parameters for the given credentials,
-// method, URL and application params. See
+// method, URL and application params. If bodyHash is not empty, it is added
+// as the oauth_body_hash parameter. See
 // http://tools.ietf.org/html/rfc5849#section-3.4 for more information about
 // signatures.
-func oauthParams(clientCredentials *Credentials, credentials *Credentials, method string, u *url.URL, form url.Values) map[string]string {
+func (c *Client) oauthParams(credentials *Credentials, method string, u *url.URL, form url.Values, bodyHash string) (map[string]string, error) {
 	oauthParams := map[string]string{
-		"oauth_consumer_key":     clientCredentials.Token,
-		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_consumer_key":     c.Credentials.Token,
+		"oauth_signature_method": c.SignatureMethod.String(),
 		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
 		"oauth_version":          "1.0",
 		"oauth_nonce":            nonce(),
@@ -247,6 +296,9 @@ func oauthParams(clientCredentials *Credentials, credentials *Credentials, metho
 	if credentials != nil {
 		oauthParams["oauth_token"] = credentials.Token
 	}
+	if bodyHash != "" {
+		oauthParams["oauth_body_hash"] = bodyHash
+	}
 	if testingNonce != "" {
 		oauthParams["oauth_nonce"] = testingNonce
 	}
@@ -254,22 +306,53 @@ func oauthParams(clientCredentials *Credentials, credentials *Credentials, metho
 		oauthParams["oauth_timestamp"] = testingTimestamp
 	}
 
+	signature, err := c.signature(credentials, method, u, form, oauthParams)
+	if err != nil {
+		return nil, err
+	}
+	oauthParams["oauth_signature"] = signature
+	return oauthParams, nil
+}
+
+// signature computes the signature of a request with the signature method
+// of the client.
+func (c *Client) signature(credentials *Credentials, method string, u *url.URL, form url.Values, oauthParams map[string]string) (string, error) {
 	var key bytes.Buffer
-	key.Write(encode(clientCredentials.Secret, false))
+	key.Write(encode(c.Credentials.Secret, false))
 	key.WriteByte('&')
 	if credentials != nil {
 		key.Write(encode(credentials.Secret, false))
 	}
 
-	h := hmac.New(sha1.New, key.Bytes())
-	writeBaseString(h, method, u, form, oauthParams)
-	sum := h.Sum(nil)
-
-	encodedSum := make([]byte, base64.StdEncoding.EncodedLen(len(sum)))
-	base64.StdEncoding.Encode(encodedSum, sum)
-
-	oauthParams["oauth_signature"] = string(encodedSum)
-	return oauthParams
+	var sum []byte
+	switch c.SignatureMethod {
+	case HMACSHA1:
+		h := hmac.New(sha1.New, key.Bytes())
+		writeBaseString(h, method, u, form, oauthParams)
+		sum = h.Sum(nil)
+	case HMACSHA256:
+		h := hmac.New(sha256.New, key.Bytes())
+		writeBaseString(h, method, u, form, oauthParams)
+		sum = h.Sum(nil)
+	case RSASHA1:
+		if c.PrivateKey == nil {
+			return "", errors.New("oauth: private key not set")
+		}
+		h := sha1.New()
+		writeBaseString(h, method, u, form, oauthParams)
+		var err error
+		sum, err = rsa.SignPKCS1v15(rand.Reader, c.PrivateKey, crypto.SHA1, h.Sum(nil))
+		if err != nil {
+			return "", err
+		}
+	case PLAINTEXT:
+		// The signature is the key itself, see
+		// http://tools.ietf.org/html/rfc5849#section-3.4.4
+		return key.String(), nil
+	default:
+		return "", fmt.Errorf("oauth: unknown signature method %v", c.SignatureMethod)
+	}
+	return base64.StdEncoding.EncodeToString(sum), nil
 }
 
 // Client represents an OAuth client.
@@ -278,6 +361,11 @@ type Client struct {
 	TemporaryCredentialRequestURI string // Also known as request token URL.
 	ResourceOwnerAuthorizationURI string // Also known as authorization URL.
 	TokenRequestURI               string // Also known as access token URL.
+
+	// SignatureMethod is the method used to sign the requests.
+	SignatureMethod SignatureMethod
+	// PrivateKey is the key used by the RSA-SHA1 signature method.
+	PrivateKey *rsa.PrivateKey
 }
 
 // Credentials represents client, temporary and token credentials.
@@ -306,42 +394,89 @@ func (c *Client) SignForm(credentials *Credentials, method, urlStr string, form
 	case u.RawQuery != "":
 		return errors.New("oauth: urlStr argument to SignForm must not include a query string")
 	}
-	for k, v := range oauthParams(&c.Credentials, credentials, method, u, form) {
+	p, err := c.oauthParams(credentials, method, u, form, "")
+	if err != nil {
+		return err
+	}
+	for k, v := range p {
 		form.Set(k, v)
 	}
 	return nil
 }
 
 // SignParam is deprecated. Use SignForm instead.
-func (c *Client) SignParam(credentials *Credentials, method, urlStr string, params url.Values) {
-	u, _ := url.Parse(urlStr)
+func (c *Client) SignParam(credentials *Credentials, method, urlStr string, params url.Values) error {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return err
+	}
 	u.RawQuery = ""
-	for k, v := range oauthParams(&c.Credentials, credentials, method, u, params) {
+	p, err := c.oauthParams(credentials, method, u, params, "")
+	if err != nil {
+		return err
+	}
+	for k, v := range p {
 		params.Set(k, v)
 	}
+	return nil
 }
 
 // AuthorizationHeader returns the HTTP authorization header value for given
-// method, URL and parameters.
+// method, URL and parameters. It returns an empty string if the request
+// cannot be signed; use SetAuthorizationHeader to get the error.
 //
 // See http://tools.ietf.org/html/rfc5849#section-3.5.1 for information about
 // transmitting OAuth parameters in an HTTP request header.
 func (c *Client) AuthorizationHeader(credentials *Credentials, method string, u *url.URL, params url.Values) string {
-	p := oauthParams(&c.Credentials, credentials, method, u, params)
+	p, err := c.oauthParams(credentials, method, u, params, "")
+	if err != nil {
+		return ""
+	}
+	return authorizationHeader(p)
+}
+
+// SetAuthorizationHeader adds the OAuth signature to the Authorization
+// header for the given method, URL and parameters.
+func (c *Client) SetAuthorizationHeader(header http.Header, credentials *Credentials, method string, u *url.URL, params url.Values) error {
+	p, err := c.oauthParams(credentials, method, u, params, "")
+	if err != nil {
+		return err
+	}
+	header.Set("Authorization", authorizationHeader(p))
+	return nil
+}
+
+// SetBodyHashAuthorizationHeader adds the OAuth signature to the
+// Authorization header of a request whose body is not a form, signing the
+// hash of the body as described in the OAuth Request Body Hash extension.
+func (c *Client) SetBodyHashAuthorizationHeader(header http.Header, credentials *Credentials, method string, u *url.URL, body []byte) error {
+	p, err := c.oauthParams(credentials, method, u, nil, c.SignatureMethod.bodyHash(body))
+	if err != nil {
+		return err
+	}
+	header.Set("Authorization", authorizationHeader(p))
+	return nil
+}
+
+// authorizationHeader formats the OAuth parameters, sorted by name
+func authorizationHeader(p map[string]string) string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
 	var buf bytes.Buffer
-	buf.WriteString(`OAuth oauth_consumer_key="`)
-	buf.Write(encode(p["oauth_consumer_key"], false))
-	buf.WriteString(`", oauth_nonce="`)
-	buf.Write(encode(p["oauth_nonce"], false))
-	buf.WriteString(`", oauth_signature="`)
-	buf.Write(encode(p["oauth_signature"], false))
-	buf.WriteString(`", oauth_signature_method="HMAC-SHA1", oauth_timestamp="`)
-	buf.Write(encode(p["oauth_timestamp"], false))
-	if t, ok := p["oauth_token"]; ok {
-		buf.WriteString(`", oauth_token="`)
-		buf.Write(encode(t, false))
-	}
-	buf.WriteString(`", oauth_version="1.0"`)
+	buf.WriteString("OAuth ")
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString(k)
+		buf.WriteString(`="`)
+		buf.Write(encode(p[k], false))
+		buf.WriteByte('"')
+	}
 	return buf.String()
 }
 
@@ -354,7 +489,9 @@ func (c *Client) Get(client *http.Client, credentials *Credentials, urlStr strin
 	if req.URL.RawQuery != "" {
 		return nil, errors.New("oauth: url must not contain a query string")
 	}
-	req.Header.Set("Authorization", c.AuthorizationHeader(credentials, "GET", req.URL, form))
+	if err := c.SetAuthorizationHeader(req.Header, credentials, "GET", req.URL, form); err != nil {
+		return nil, err
+	}
 	req.URL.RawQuery = form.Encode()
 	return client.Do(req)
 }
@@ -365,7 +502,9 @@ func (c *Client) do(client *http.Client, method string, credentials *Credentials
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Authorization", c.AuthorizationHeader(credentials, method, req.URL, form))
+	if err := c.SetAuthorizationHeader(req.Header, credentials, method, req.URL, form); err != nil {
+		return nil, err
+	}
 	return client.Do(req)
 }
 
@@ -384,8 +523,24 @@ func (c *Client) Put(client *http.Client, credentials *Credentials, urlStr strin
 	return c.do(client, "PUT", credentials, urlStr, form)
 }
 
+// PostBody issues a POST with a body which is not a form, such as a JSON
+// document, signed with its body hash.
+func (c *Client) PostBody(client *http.Client, credentials *Credentials, urlStr, contentType string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest("POST", urlStr, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if err := c.SetBodyHashAuthorizationHeader(req.Header, credentials, "POST", req.URL, body); err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
 func (c *Client) request(client *http.Client, credentials *Credentials, urlStr string, params url.Values) (*Credentials, url.Values, error) {
-	c.SignParam(credentials, "POST", urlStr, params)
+	if err := c.SignParam(credentials, "POST", urlStr, params); err != nil {
+		return nil, nil, err
+	}
 	resp, err := client.PostForm(urlStr, params)
 	if err != nil {
 		return nil, nil, err
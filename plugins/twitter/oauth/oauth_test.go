@@ -16,7 +16,14 @@ package oauth
 
 import (
 	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
 	"net/url"
+	"strings"
 	"testing"
 )
 
@@ -170,3 +177,139 @@ func TestAuthorizationHeader(t *testing.T) {
 		}
 	}
 }
+
+// rfcParams are the OAuth parameters of the example request in section 1.2
+// of RFC 5849, which doesn't include oauth_version.
+func rfcParams(signatureMethod SignatureMethod) map[string]string {
+	return map[string]string{
+		"oauth_consumer_key":     "dpf43f3p2l4k3l03",
+		"oauth_token":            "nnch734d00sl2jdk",
+		"oauth_signature_method": signatureMethod.String(),
+		"oauth_timestamp":        "137131202",
+		"oauth_nonce":            "chapoH",
+	}
+}
+
+var signatureTests = []struct {
+	signatureMethod SignatureMethod
+	credentials     *Credentials
+	signature       string
+}{
+	// RFC 5849, section 1.2
+	{HMACSHA1, &Credentials{"nnch734d00sl2jdk", "pfkkdhi9sl3r4s00"}, "MdpQcU8iPSUjWoN/UDMsK2sui9I="},
+	{HMACSHA256, &Credentials{"nnch734d00sl2jdk", "pfkkdhi9sl3r4s00"}, "HtMwoX2zenlFjgGg/SNEoKEQmL7CzxYFEKzs7er044Y="},
+	// The temporary and token credentials requests of RFC 5849, section 1.2
+	{PLAINTEXT, nil, "kd94hf93k423kf44&"},
+	{PLAINTEXT, &Credentials{"hh5s93j4hdidpola", "hdhd0244k9j7ao03"}, "kd94hf93k423kf44&hdhd0244k9j7ao03"},
+}
+
+func TestSignature(t *testing.T) {
+	u := parseURL("http://photos.example.net/photos")
+	form := url.Values{"file": {"vacation.jpg"}, "size": {"original"}}
+	for _, st := range signatureTests {
+		c := Client{
+			Credentials:     Credentials{"dpf43f3p2l4k3l03", "kd94hf93k423kf44"},
+			SignatureMethod: st.signatureMethod,
+		}
+		signature, err := c.signature(st.credentials, "GET", u, form, rfcParams(st.signatureMethod))
+		if err != nil {
+			t.Errorf("signature with %v: %v", st.signatureMethod, err)
+		} else if signature != st.signature {
+			t.Errorf("signature with %v = %q, want %q", st.signatureMethod, signature, st.signature)
+		}
+	}
+}
+
+func TestRSASignature(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := parseURL("http://photos.example.net/photos")
+	form := url.Values{"file": {"vacation.jpg"}, "size": {"original"}}
+	params := rfcParams(RSASHA1)
+	credentials := &Credentials{"nnch734d00sl2jdk", "pfkkdhi9sl3r4s00"}
+
+	c := Client{Credentials: Credentials{"dpf43f3p2l4k3l03", ""}, SignatureMethod: RSASHA1}
+	if _, err := c.signature(credentials, "GET", u, form, params); err == nil {
+		t.Error("RSA-SHA1 signature without a private key succeeded")
+	}
+
+	c.PrivateKey = key
+	signature, err := c.signature(credentials, "GET", u, form, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha1.New()
+	writeBaseString(h, "GET", u, form, params)
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, h.Sum(nil), sum); err != nil {
+		t.Errorf("RSA-SHA1 signature doesn't verify: %v", err)
+	}
+}
+
+func TestAuthorizationHeaderSignatureMethod(t *testing.T) {
+	defer func() {
+		testingNonce = ""
+		testingTimestamp = ""
+	}()
+	testingNonce = "wIjqoS"
+	testingTimestamp = "137131200"
+	c := Client{
+		Credentials:     Credentials{"dpf43f3p2l4k3l03", "kd94hf93k423kf44"},
+		SignatureMethod: PLAINTEXT,
+	}
+	header := c.AuthorizationHeader(nil, "POST", parseURL("https://photos.example.net/initiate"), url.Values{})
+	want := `OAuth oauth_consumer_key="dpf43f3p2l4k3l03", oauth_nonce="wIjqoS", oauth_signature="kd94hf93k423kf44%26", oauth_signature_method="PLAINTEXT", oauth_timestamp="137131200", oauth_version="1.0"`
+	if header != want {
+		t.Errorf("authorization header\ngot:  %s\nwant: %s", header, want)
+	}
+
+	c.SignatureMethod = RSASHA1
+	if header := c.AuthorizationHeader(nil, "POST", parseURL("https://photos.example.net/initiate"), url.Values{}); header != "" {
+		t.Errorf("authorization header without a private key = %q", header)
+	}
+	if err := c.SetAuthorizationHeader(make(http.Header), nil, "POST", parseURL("https://photos.example.net/initiate"), url.Values{}); err == nil {
+		t.Error("SetAuthorizationHeader without a private key succeeded")
+	}
+	if err := c.SignParam(nil, "POST", "https://photos.example.net/initiate", url.Values{}); err == nil {
+		t.Error("SignParam without a private key succeeded")
+	}
+}
+
+func TestBodyHash(t *testing.T) {
+	// Example from the OAuth Request Body Hash extension
+	if h := HMACSHA1.bodyHash([]byte("Hello World!")); h != "Lve95gjOVATpfV8EL5X4nxwjKHE=" {
+		t.Errorf("body hash = %q", h)
+	}
+
+	defer func() {
+		testingNonce = ""
+		testingTimestamp = ""
+	}()
+	testingNonce = "chapoH"
+	testingTimestamp = "137131202"
+	c := Client{
+		Credentials:     Credentials{"dpf43f3p2l4k3l03", "kd94hf93k423kf44"},
+		SignatureMethod: HMACSHA256,
+	}
+	header := make(http.Header)
+	err := c.SetBodyHashAuthorizationHeader(header, &Credentials{"nnch734d00sl2jdk", "pfkkdhi9sl3r4s00"},
+		"POST", parseURL("http://photos.example.net/photos"), []byte(`{"text":"Hello World!"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := header.Get("Authorization")
+	for _, want := range []string{
+		`oauth_body_hash="b95zmB7G8yHs3hHBjlVENLm%2BcKehtRAaP8VkhyujZsE%3D"`,
+		`oauth_signature="3x4aDuLjy6RJT%2FR%2F0fsu4fybdwWPweuBOoqx%2FT%2FzEGo%3D"`,
+		`oauth_signature_method="HMAC-SHA256"`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("authorization header %s\ndoesn't contain %s", got, want)
+		}
+	}
+}
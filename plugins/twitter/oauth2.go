@@ -0,0 +1,43 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"net/http"
+	"net/url"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+// twitterOAuth2 refreshes the OAuth 2.0 tokens of the v2 API. The
+// confidential clients must authenticate with HTTP basic authentication.
+var twitterOAuth2 = &plugins.OAuth2{
+	TokenUrl:  "https://api.twitter.com/2/oauth2/token",
+	BasicAuth: true,
+}
+
+// bearerRequest performs a GET request with an OAuth 2.0 bearer token,
+// refreshing it when it has expired or the server rejects it. Refresh
+// tokens are rotated, so the refreshed tokens are sent back to the daemon
+// by the plugin runner, as for the other accounts.
+func (p *twitterPlugin) bearerRequest(authData *plugins.AuthData, u *url.URL) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	return twitterOAuth2.Do(authData, req)
+}
@@ -0,0 +1,151 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+// oauth2Server is a fake API accepting a single valid bearer token, which
+// can be obtained from its token endpoint.
+type oauth2Server struct {
+	*httptest.Server
+	validToken string
+	refreshes  int
+	form       url.Values
+	user       string
+}
+
+func newOauth2Server(c *C, validToken string) *oauth2Server {
+	s := &oauth2Server{validToken: validToken}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			s.refreshes++
+			c.Check(r.ParseForm(), IsNil)
+			s.form = r.PostForm
+			s.user, _, _ = r.BasicAuth()
+			if r.PostForm.Get("refresh_token") != "good-refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error": "invalid_request"}`)
+				return
+			}
+			fmt.Fprintf(w, `{"token_type": "bearer", "expires_in": 7200, "access_token": %q, "refresh_token": "next-refresh"}`, s.validToken)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+s.validToken {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"title": "Unauthorized", "status": 401}`)
+			return
+		}
+		fmt.Fprint(w, meBodyV2)
+	}))
+	twitterOAuth2.TokenUrl = s.URL + "/token"
+	baseUrlV2, _ = url.Parse(s.URL + "/2/")
+	return s
+}
+
+func restoreUrls() func() {
+	oldTokenUrl, oldBaseUrl := twitterOAuth2.TokenUrl, baseUrlV2
+	return func() {
+		twitterOAuth2.TokenUrl, baseUrlV2 = oldTokenUrl, oldBaseUrl
+	}
+}
+
+func (s S) TestBearerRequest(c *C) {
+	defer restoreUrls()()
+	server := newOauth2Server(c, "access")
+	defer server.Close()
+
+	p := &twitterPlugin{}
+	me, err := p.userV2(&plugins.AuthData{AccessToken: "access"})
+	c.Assert(err, IsNil)
+	c.Check(me.Id, Equals, "2244994945")
+	c.Check(server.refreshes, Equals, 0)
+}
+
+func (s S) TestBearerRequestRefresh(c *C) {
+	defer restoreUrls()()
+	server := newOauth2Server(c, "refreshed")
+	defer server.Close()
+
+	authData := &plugins.AuthData{
+		AccountId:    2,
+		ClientId:     "client",
+		AccessToken:  "stale",
+		RefreshToken: "good-refresh",
+	}
+	p := &twitterPlugin{}
+	me, err := p.userV2(authData)
+	c.Assert(err, IsNil)
+	c.Check(me.Id, Equals, "2244994945")
+	c.Check(server.refreshes, Equals, 1)
+	c.Check(server.form.Get("grant_type"), Equals, "refresh_token")
+	c.Check(server.form.Get("client_id"), Equals, "client")
+	// Public clients don't authenticate
+	c.Check(server.user, Equals, "")
+
+	// The rotated tokens are handed over to the daemon with authData
+	c.Check(authData.AccessToken, Equals, "refreshed")
+	c.Check(authData.RefreshToken, Equals, "next-refresh")
+	c.Check(authData.TokenExpiry.After(time.Now()), Equals, true)
+
+	_, err = p.userV2(authData)
+	c.Assert(err, IsNil)
+	c.Check(server.refreshes, Equals, 1)
+}
+
+func (s S) TestBearerRequestRefreshExpired(c *C) {
+	defer restoreUrls()()
+	server := newOauth2Server(c, "refreshed")
+	defer server.Close()
+
+	authData := &plugins.AuthData{
+		ClientId:     "client",
+		ClientSecret: "secret",
+		AccessToken:  "access",
+		RefreshToken: "good-refresh",
+	}
+	authData.TokenExpiry = time.Now().Add(-time.Minute)
+	p := &twitterPlugin{}
+	_, err := p.userV2(authData)
+	c.Assert(err, IsNil)
+	// The token was refreshed before trying it
+	c.Check(server.refreshes, Equals, 1)
+	c.Check(server.user, Equals, "client")
+}
+
+func (s S) TestBearerRequestRefreshFailure(c *C) {
+	defer restoreUrls()()
+	server := newOauth2Server(c, "refreshed")
+	defer server.Close()
+
+	p := &twitterPlugin{}
+	_, err := p.userV2(&plugins.AuthData{
+		AccessToken:  "stale",
+		RefreshToken: "bad-refresh",
+	})
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+	c.Check(server.refreshes, Equals, 1)
+}
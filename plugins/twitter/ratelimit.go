@@ -0,0 +1,113 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+// rateLimitWindow is how long an endpoint is considered exhausted after a
+// 429 response without rate limit headers.
+const rateLimitWindow = 15 * time.Minute
+
+// rateLimit is the state of the rate limit window of an endpoint, as
+// reported by the x-rate-limit-* headers.
+type rateLimit struct {
+	Remaining int `json:"remaining"`
+	// Reset is when the window resets, in seconds since the epoch.
+	Reset int64 `json:"reset"`
+}
+
+// checkRateLimit returns a plugins.RateLimitError if the endpoint has no
+// requests left in its current window.
+func (p *twitterPlugin) checkRateLimit(endpoint string, now time.Time) error {
+	limit, ok := p.config.RateLimits[endpoint]
+	if !ok {
+		return nil
+	}
+	if now.Unix() >= limit.Reset {
+		delete(p.config.RateLimits, endpoint)
+		return nil
+	}
+	if limit.Remaining > 0 {
+		return nil
+	}
+	return &plugins.RateLimitError{Reset: time.Unix(limit.Reset, 0)}
+}
+
+// updateRateLimit records the rate limit window of the endpoint from the
+// response headers. A 429 response is returned as a plugins.RateLimitError.
+func (p *twitterPlugin) updateRateLimit(endpoint string, resp *http.Response, now time.Time) error {
+	remaining, errRemaining := strconv.Atoi(resp.Header.Get("x-rate-limit-remaining"))
+	reset, errReset := strconv.ParseInt(resp.Header.Get("x-rate-limit-reset"), 10, 64)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		remaining, errRemaining = 0, nil
+		if errReset != nil || reset <= now.Unix() {
+			reset, errReset = now.Add(rateLimitWindow).Unix(), nil
+		}
+	}
+	if errRemaining != nil || errReset != nil {
+		return nil
+	}
+
+	if p.config.RateLimits == nil {
+		p.config.RateLimits = make(map[string]rateLimit)
+	}
+	p.config.RateLimits[endpoint] = rateLimit{Remaining: remaining, Reset: reset}
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+	log.Print("twitter plugin: ", endpoint, " is rate limited until ", time.Unix(reset, 0))
+	return &plugins.RateLimitError{Reset: time.Unix(reset, 0)}
+}
+
+// rateLimited collects the rate limit errors of the endpoints that have
+// been skipped, so that a poll can go on with the other ones.
+type rateLimited struct {
+	err       *plugins.RateLimitError
+	succeeded bool
+}
+
+// skip returns nil if err is a rate limit error, remembering the earliest
+// reset time; other errors are returned as they are.
+func (r *rateLimited) skip(err error) error {
+	if err == nil {
+		r.succeeded = true
+		return nil
+	}
+	e, ok := err.(*plugins.RateLimitError)
+	if !ok {
+		return err
+	}
+	if r.err == nil || e.Reset.Before(r.err.Reset) {
+		r.err = e
+	}
+	return nil
+}
+
+// result returns the rate limit error if no endpoint could be queried.
+func (r *rateLimited) result() error {
+	if r.succeeded || r.err == nil {
+		return nil
+	}
+	return r.err
+}
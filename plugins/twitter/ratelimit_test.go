@@ -0,0 +1,123 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+func rateLimitedResponse(status int, remaining, reset string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: make(http.Header)}
+	if remaining != "" {
+		resp.Header.Set("x-rate-limit-remaining", remaining)
+	}
+	if reset != "" {
+		resp.Header.Set("x-rate-limit-reset", reset)
+	}
+	return resp
+}
+
+func (s S) TestUpdateRateLimit(c *C) {
+	now := time.Unix(1500000000, 0)
+	p := &twitterPlugin{}
+	err := p.updateRateLimit("/2/dm_events", rateLimitedResponse(http.StatusOK, "3", "1500000600"), now)
+	c.Assert(err, IsNil)
+	c.Check(p.config.RateLimits["/2/dm_events"], Equals, rateLimit{Remaining: 3, Reset: 1500000600})
+	c.Check(p.checkRateLimit("/2/dm_events", now), IsNil)
+
+	err = p.updateRateLimit("/2/dm_events", rateLimitedResponse(http.StatusOK, "0", "1500000600"), now)
+	c.Assert(err, IsNil)
+	err = p.checkRateLimit("/2/dm_events", now)
+	c.Assert(err, FitsTypeOf, &plugins.RateLimitError{})
+	c.Check(err.(*plugins.RateLimitError).Reset, Equals, time.Unix(1500000600, 0))
+	// Other endpoints have their own window
+	c.Check(p.checkRateLimit("/2/users/1/mentions", now), IsNil)
+
+	// Once the window resets the endpoint is available again
+	c.Check(p.checkRateLimit("/2/dm_events", time.Unix(1500000600, 0)), IsNil)
+	_, ok := p.config.RateLimits["/2/dm_events"]
+	c.Check(ok, Equals, false)
+}
+
+func (s S) TestUpdateRateLimitTooManyRequests(c *C) {
+	now := time.Unix(1500000000, 0)
+	p := &twitterPlugin{}
+	err := p.updateRateLimit("/2/dm_events", rateLimitedResponse(http.StatusTooManyRequests, "", "1500000300"), now)
+	c.Assert(err, FitsTypeOf, &plugins.RateLimitError{})
+	c.Check(err.(*plugins.RateLimitError).Reset, Equals, time.Unix(1500000300, 0))
+	c.Check(p.config.RateLimits["/2/dm_events"], Equals, rateLimit{Remaining: 0, Reset: 1500000300})
+
+	// Without headers, the default window is assumed
+	err = p.updateRateLimit("/2/users/me", rateLimitedResponse(http.StatusTooManyRequests, "", ""), now)
+	c.Assert(err, FitsTypeOf, &plugins.RateLimitError{})
+	c.Check(err.(*plugins.RateLimitError).Reset, Equals, now.Add(rateLimitWindow))
+
+	// Responses without headers don't change anything
+	c.Check(p.updateRateLimit("/2/other", rateLimitedResponse(http.StatusOK, "", ""), now), IsNil)
+	c.Check(len(p.config.RateLimits), Equals, 2)
+}
+
+func (s S) TestPollV2SkipsRateLimited(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	reset := time.Now().Add(10 * time.Minute).Unix()
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/2/dm_events" {
+			w.Header().Set("x-rate-limit-remaining", "0")
+			w.Header().Set("x-rate-limit-reset", strconv.FormatInt(reset, 10))
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"title": "Too Many Requests", "status": 429}`)
+			return
+		}
+		w.Header().Set("x-rate-limit-remaining", "179")
+		w.Header().Set("x-rate-limit-reset", strconv.FormatInt(reset, 10))
+		fmt.Fprint(w, mentionsPage2BodyV2)
+	}))
+	defer server.Close()
+	baseUrlV2, _ = url.Parse(server.URL + "/2/")
+
+	p := &twitterPlugin{config: twitterConfig{UserId: "2244994945", UserName: "jasoncosta"}}
+	statuses, dms, err := p.pollV2(&plugins.AuthData{})
+	// The mentions are still reported
+	c.Assert(err, IsNil)
+	c.Assert(statuses, NotNil)
+	c.Check(len(statuses.Messages), Equals, 1)
+	c.Check(dms, IsNil)
+	c.Check(requests, Equals, 2)
+
+	// The exhausted endpoint isn't queried again until it resets
+	statuses, dms, err = p.pollV2(&plugins.AuthData{})
+	c.Assert(err, IsNil)
+	c.Check(requests, Equals, 3)
+
+	// When no endpoint can be queried, the poll fails
+	p.config.RateLimits["/2/users/2244994945/mentions"] = rateLimit{Remaining: 0, Reset: reset - 60}
+	statuses, dms, err = p.pollV2(&plugins.AuthData{})
+	c.Check(requests, Equals, 3)
+	c.Assert(err, FitsTypeOf, &plugins.RateLimitError{})
+	c.Check(err.(*plugins.RateLimitError).Reset, Equals, time.Unix(reset-60, 0))
+}
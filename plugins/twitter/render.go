@@ -0,0 +1,210 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxBodyLength is the number of characters (grapheme clusters) after
+// which the text of tweets is cut in the notifications
+const maxBodyLength = 280
+
+// Entities are described here:
+// https://developer.twitter.com/en/docs/twitter-api/v1/data-dictionary/object-model/entities
+type entities struct {
+	Urls         []urlEntity     `json:"urls"`
+	Media        []urlEntity     `json:"media"`
+	UserMentions []mentionEntity `json:"user_mentions"`
+}
+
+type urlEntity struct {
+	Url         string `json:"url"`
+	ExpandedUrl string `json:"expanded_url"`
+	DisplayUrl  string `json:"display_url"`
+	// Indices are the offsets of the URL in the text, in code points
+	Indices [2]int `json:"indices"`
+}
+
+type mentionEntity struct {
+	ScreenName string `json:"screen_name"`
+	Name       string `json:"name"`
+	Indices    [2]int `json:"indices"`
+}
+
+// extendedTweet holds the full text of tweets longer than 140 characters
+type extendedTweet struct {
+	FullText string   `json:"full_text"`
+	Entities entities `json:"entities"`
+}
+
+// fullText returns the complete text of the status, with its entities
+func (s status) fullText() (string, entities) {
+	if s.ExtendedTweet != nil && s.ExtendedTweet.FullText != "" {
+		return s.ExtendedTweet.FullText, s.ExtendedTweet.Entities
+	}
+	if s.FullText != "" {
+		return s.FullText, s.Entities
+	}
+	return s.Text, s.Entities
+}
+
+// body returns the text of the status as shown in the notifications
+func (s status) body() string {
+	return truncateGraphemes(renderText(s.fullText()), maxBodyLength)
+}
+
+// body returns the text of the direct message as shown in the
+// notifications
+func (m directMessage) body() string {
+	return truncateGraphemes(renderText(m.Text, m.Entities), maxBodyLength)
+}
+
+type replacement struct {
+	start, end int
+	text       string
+}
+
+type byStart []replacement
+
+func (r byStart) Len() int           { return len(r) }
+func (r byStart) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+func (r byStart) Less(i, j int) bool { return r[i].start < r[j].start }
+
+// renderText expands the short links of the text, replaces the mentions
+// with the names of the users where known, keeping the "@" so that they
+// still read as mentions, and unescapes the HTML entities which the API
+// uses for "&", "<" and ">".
+func renderText(text string, e entities) string {
+	runes := []rune(text)
+	// entityAt checks that the entity is really at the given indices,
+	// since they are ignored if wrong
+	entityAt := func(indices [2]int, s string) bool {
+		start, end := indices[0], indices[1]
+		if start < 0 || start >= end || end > len(runes) {
+			return false
+		}
+		return strings.EqualFold(string(runes[start:end]), s)
+	}
+
+	var replacements []replacement
+	for _, urls := range [][]urlEntity{e.Urls, e.Media} {
+		for _, u := range urls {
+			expanded := u.ExpandedUrl
+			if expanded == "" {
+				expanded = u.DisplayUrl
+			}
+			if expanded != "" && entityAt(u.Indices, u.Url) {
+				replacements = append(replacements, replacement{u.Indices[0], u.Indices[1], expanded})
+			}
+		}
+	}
+	for _, m := range e.UserMentions {
+		if m.Name != "" && entityAt(m.Indices, "@"+m.ScreenName) {
+			replacements = append(replacements, replacement{m.Indices[0], m.Indices[1], "@" + m.Name})
+		}
+	}
+	sort.Sort(byStart(replacements))
+
+	var out []string
+	pos := 0
+	for _, r := range replacements {
+		// Overlapping entities are bogus
+		if r.start < pos {
+			continue
+		}
+		out = append(out, html.UnescapeString(string(runes[pos:r.start])), r.text)
+		pos = r.end
+	}
+	out = append(out, html.UnescapeString(string(runes[pos:])))
+	return strings.Join(out, "")
+}
+
+// truncateGraphemes cuts the text after max user-perceived characters,
+// never splitting a character made of several code points such as
+// accented letters, flags or emoji sequences.
+func truncateGraphemes(s string, max int) string {
+	count := 0
+	for i := 0; i < len(s); i += graphemeLength(s[i:]) {
+		if count == max {
+			return strings.TrimRightFunc(s[:i], unicode.IsSpace) + "…"
+		}
+		count++
+	}
+	return s
+}
+
+// graphemeLength returns the length in bytes of the grapheme cluster at
+// the start of s. This is a simplification of the rules in UAX #29 which
+// covers what appears in tweets.
+func graphemeLength(s string) int {
+	r, n := utf8.DecodeRuneInString(s)
+	if r == '\r' && strings.HasPrefix(s[n:], "\n") {
+		return n + 1
+	}
+	if isRegionalIndicator(r) {
+		// Flags are pairs of regional indicators
+		if next, size := utf8.DecodeRuneInString(s[n:]); isRegionalIndicator(next) {
+			n += size
+		}
+	}
+	for n < len(s) {
+		next, size := utf8.DecodeRuneInString(s[n:])
+		if next == zeroWidthJoiner {
+			// The joiner glues the following character to this one
+			n += size
+			if n < len(s) {
+				_, size = utf8.DecodeRuneInString(s[n:])
+				n += size
+			}
+			continue
+		}
+		if !isGraphemeExtender(next) {
+			break
+		}
+		n += size
+	}
+	return n
+}
+
+const zeroWidthJoiner = '\u200d'
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// isGraphemeExtender tells whether r combines with the preceding character
+func isGraphemeExtender(r rune) bool {
+	switch {
+	case unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc):
+		return true
+	case r >= 0xfe00 && r <= 0xfe0f, r >= 0xe0100 && r <= 0xe01ef:
+		// Variation selectors
+		return true
+	case r >= 0x1f3fb && r <= 0x1f3ff:
+		// Emoji skin tone modifiers
+		return true
+	case r >= 0xe0020 && r <= 0xe007f:
+		// Tags, used in subdivision flags
+		return true
+	}
+	return false
+}
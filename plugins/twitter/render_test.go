@@ -0,0 +1,130 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"encoding/json"
+	"strings"
+
+	. "launchpad.net/gocheck"
+)
+
+const extendedStatusBody = `
+{
+  "id": 1,
+  "text": "Tom &amp; Jerry reunion with @spode and more at https://t.co/short… https://t.co/more",
+  "truncated": true,
+  "entities": {
+    "urls": [{"url": "https://t.co/more", "expanded_url": "https://twitter.com/i/web/status/1", "indices": [68, 85]}]
+  },
+  "extended_tweet": {
+    "full_text": "Tom &amp; Jerry reunion with @spode and more at https://t.co/short &lt;3 https://t.co/pic",
+    "entities": {
+      "urls": [{"url": "https://t.co/short", "expanded_url": "https://example.com/reunion?a=1&b=2", "display_url": "example.com/reunion", "indices": [48, 66]}],
+      "user_mentions": [{"screen_name": "spode", "name": "Andrew Spode Miller", "indices": [29, 35]}],
+      "media": [{"url": "https://t.co/pic", "display_url": "pic.twitter.com/pic", "indices": [73, 89]}]
+    }
+  }
+}`
+
+func (s S) TestStatusBody(c *C) {
+	var st status
+	c.Assert(json.Unmarshal([]byte(extendedStatusBody), &st), IsNil)
+	c.Check(st.body(), Equals, "Tom & Jerry reunion with @Andrew Spode Miller and more at https://example.com/reunion?a=1&b=2 <3 pic.twitter.com/pic")
+
+	// The extended mode puts the full text in place of the text
+	st = status{
+		FullText: "Hi @unknown and @Spode",
+		Entities: entities{UserMentions: []mentionEntity{
+			{ScreenName: "unknown", Indices: [2]int{3, 11}},
+			{ScreenName: "spode", Name: "Andrew", Indices: [2]int{16, 22}},
+		}},
+	}
+	c.Check(st.body(), Equals, "Hi @unknown and @Andrew")
+}
+
+func (s S) TestRenderTextWrongIndices(c *C) {
+	// Entities whose indices don't match the text are left alone
+	e := entities{
+		Urls:         []urlEntity{{Url: "https://t.co/x", ExpandedUrl: "https://example.com", Indices: [2]int{0, 14}}},
+		UserMentions: []mentionEntity{{ScreenName: "spode", Name: "Andrew", Indices: [2]int{50, 56}}},
+	}
+	c.Check(renderText("See https://t.co/x &amp; @spode", e), Equals, "See https://t.co/x & @spode")
+}
+
+func (s S) TestRenderTextMultibyte(c *C) {
+	// Indices count code points, not bytes
+	e := entities{
+		UserMentions: []mentionEntity{{ScreenName: "spode", Name: "Andrew", Indices: [2]int{4, 10}}},
+	}
+	c.Check(renderText("😀 ¡ @spode!", e), Equals, "😀 ¡ @Andrew!")
+}
+
+func (s S) TestTruncateGraphemes(c *C) {
+	for _, t := range []struct {
+		text     string
+		max      int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"a long text to cut", 6, "a long…"},
+		{"cut at the space", 4, "cut…"},
+		// Combining accents stay with their letter
+		{"café noir", 4, "café…"},
+		// Flags are pairs of regional indicators
+		{"🇮🇹🇫🇷🇩🇪", 2, "🇮🇹🇫🇷…"},
+		// Skin tones and ZWJ sequences
+		{"👍🏽👨‍👩‍👧‍👦!", 2, "👍🏽👨‍👩‍👧‍👦…"},
+		{"👨‍👩‍👧‍👦 family", 1, "👨‍👩‍👧‍👦…"},
+		// Variation selectors
+		{"❤️❤️❤️", 2, "❤️❤️…"},
+	} {
+		c.Check(truncateGraphemes(t.text, t.max), Equals, t.expected, Commentf("%q", t.text))
+	}
+}
+
+func (s S) TestStatusBodyTruncated(c *C) {
+	st := status{FullText: strings.Repeat("ab ", 200)}
+	body := st.body()
+	c.Check(strings.HasSuffix(body, "…"), Equals, true)
+	c.Check(len([]rune(body)), Equals, maxBodyLength+1)
+}
+
+func (s S) TestTweetV2Body(c *C) {
+	var t tweetV2
+	c.Assert(json.Unmarshal([]byte(`
+{
+  "id": "2",
+  "text": "@TwitterDev look https://t.co/abc",
+  "entities": {
+    "mentions": [{"start": 0, "end": 11, "username": "TwitterDev"}],
+    "urls": [{"start": 17, "end": 33, "url": "https://t.co/abc", "expanded_url": "https://example.com/abc"}]
+  },
+  "note_tweet": {
+    "text": "@TwitterDev look at this long text https://t.co/abc",
+    "entities": {
+      "mentions": [{"start": 0, "end": 11, "username": "TwitterDev"}],
+      "urls": [{"start": 35, "end": 51, "url": "https://t.co/abc", "expanded_url": "https://example.com/abc"}]
+    }
+  }
+}`), &t), IsNil)
+	users := map[string]user{"2244994945": {ScreenName: "TwitterDev", Name: "Twitter Dev"}}
+	st, err := t.status(users)
+	c.Assert(err, IsNil)
+	c.Check(st.body(), Equals, "@Twitter Dev look at this long text https://example.com/abc")
+}
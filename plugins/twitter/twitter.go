@@ -23,15 +23,20 @@ import (
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
-	"launchpad.net/account-polld/gettext"
 	"launchpad.net/account-polld/plugins"
 	"launchpad.net/account-polld/plugins/twitter/oauth" // "github.com/garyburd/go-oauth/oauth"
 )
 
 var baseUrl, _ = url.Parse("https://api.twitter.com/1.1/")
+var baseUrlV2, _ = url.Parse("https://api.twitter.com/2/")
+
+// avatarIcon returns the local copy of a profile image, to be used as the
+// icon of the notifications
+var avatarIcon = plugins.Avatars.Get
 
 const (
 	maxIndividualStatuses               = 2
@@ -40,11 +45,33 @@ const (
 	consolidatedDirectMessageIndexStart = maxIndividualDirectMessages
 	twitterDispatchUrlBase              = "https://mobile.twitter.com"
 	pluginName                          = "twitter"
+	// apiVersion1 selects the legacy v1.1 API in twitterConfig
+	apiVersion1 = "1.1"
 )
 
 type twitterConfig struct {
 	LastMentionId       int64 `json:"lastMentionId"`
 	LastDirectMessageId int64 `json:"lastDirectMessageId"`
+	// ApiVersion is the version of the API to use: the v2 API is used
+	// unless this is set to "1.1".
+	ApiVersion string `json:"apiVersion,omitempty"`
+	// UserId and UserName identify the account user, as needed by the
+	// v2 API.
+	UserId   string `json:"userId,omitempty"`
+	UserName string `json:"userName,omitempty"`
+	// RateLimits holds the rate limit windows, by endpoint path.
+	RateLimits map[string]rateLimit `json:"rateLimits,omitempty"`
+
+	// The optional notifications, which are only available with the
+	// v2 API, and the most recent items already seen for each of them.
+	Followers      bool           `json:"followers,omitempty"`
+	Likes          bool           `json:"likes,omitempty"`
+	Retweets       bool           `json:"retweets,omitempty"`
+	Quotes         bool           `json:"quotes,omitempty"`
+	LastFollowerId string         `json:"lastFollowerId,omitempty"`
+	LastRetweetId  int64          `json:"lastRetweetId,omitempty"`
+	LastQuoteId    int64          `json:"lastQuoteId,omitempty"`
+	LikeCounts     map[string]int `json:"likeCounts,omitempty"`
 }
 
 type twitterPlugin struct {
@@ -59,12 +86,33 @@ func (p *twitterPlugin) ApplicationId() plugins.ApplicationId {
 	return "com.ubuntu.developer.webapps.webapp-twitter_webapp-twitter"
 }
 
-func (p *twitterPlugin) request(authData *plugins.AuthData, path string) (*http.Response, error) {
+func (p *twitterPlugin) request(authData *plugins.AuthData, base *url.URL, path string) (*http.Response, error) {
 	// Resolve path relative to API base URL.
-	u, err := baseUrl.Parse(path)
+	u, err := base.Parse(path)
 	if err != nil {
 		return nil, err
 	}
+	endpoint := u.Path
+	if err := p.checkRateLimit(endpoint, time.Now()); err != nil {
+		return nil, err
+	}
+	resp, err := p.signedRequest(authData, u)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.updateRateLimit(endpoint, resp, time.Now()); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (p *twitterPlugin) signedRequest(authData *plugins.AuthData, u *url.URL) (*http.Response, error) {
+	// Without a token secret this is an OAuth 2.0 user context token
+	if authData.TokenSecret == "" {
+		return p.bearerRequest(authData, u)
+	}
+
 	query := u.Query()
 	u.RawQuery = ""
 
@@ -103,27 +151,42 @@ func (p *twitterPlugin) parseStatuses(resp *http.Response) (*plugins.PushMessage
 	if err := decoder.Decode(&statuses); err != nil {
 		return nil, err
 	}
+	return p.statusesBatch(statuses), nil
+}
 
+// statusesBatch creates the notifications for the mentions, and remembers
+// the most recent one.
+func (p *twitterPlugin) statusesBatch(statuses []status) *plugins.PushMessageBatch {
 	sort.Sort(sort.Reverse(byStatusId(statuses)))
 	if len(statuses) < 1 {
-		return nil, nil
+		return nil
+	}
+	if statuses[0].Id > p.config.LastMentionId {
+		p.config.LastMentionId = statuses[0].Id
 	}
-	p.config.LastMentionId = statuses[0].Id
 
 	pushMsg := make([]*plugins.PushMessage, len(statuses))
 	for i, s := range statuses {
 		// TRANSLATORS: The first %s refers to the twitter user's Name, the second %s to the username.
-		summary := fmt.Sprintf(gettext.Gettext("%s. @%s"), s.User.Name, s.User.ScreenName)
+		summary := fmt.Sprintf(plugins.Gettext("%s. @%s"), s.User.Name, s.User.ScreenName)
 		action := fmt.Sprintf("%s/%s/statuses/%d", twitterDispatchUrlBase, s.User.ScreenName, s.Id)
 		epoch := toEpoch(s.CreatedAt)
-		pushMsg[i] = plugins.NewStandardPushMessage(summary, s.Text, action, s.User.Image, epoch)
+		body := s.body()
+		pushMsg[i] = plugins.NewStandardPushMessage(summary, body, action, avatarIcon(s.User.Image), epoch)
+		pushMsg[i].Fields = &plugins.MessageFields{
+			Senders: []string{s.User.ScreenName},
+			Body:    body,
+			Thread:  s.thread(),
+			Labels:  []string{"mention"},
+		}
 	}
 	return &plugins.PushMessageBatch{
 		Messages:        pushMsg,
 		Limit:           maxIndividualStatuses,
 		OverflowHandler: p.consolidateStatuses,
 		Tag:             "status",
-	}, nil
+		Priority:        plugins.PRIORITY_DEFAULT,
+	}
 }
 
 func (p *twitterPlugin) consolidateStatuses(pushMsg []*plugins.PushMessage) *plugins.PushMessage {
@@ -131,10 +194,10 @@ func (p *twitterPlugin) consolidateStatuses(pushMsg []*plugins.PushMessage) *plu
 	for i, m := range pushMsg {
 		screennames[i] = m.Notification.Card.Summary
 	}
-	// TRANSLATORS: This represents a notification summary about more twitter mentions available
-	summary := gettext.Gettext("Multiple more mentions")
+	// TRANSLATORS: This represents a notification summary about new twitter mentions, %d is their number
+	summary := fmt.Sprintf(plugins.NGettext("%d new mention", "%d new mentions", uint64(len(pushMsg))), len(pushMsg))
 	// TRANSLATORS: This represents a notification body with the comma separated twitter usernames
-	body := fmt.Sprintf(gettext.Gettext("From %s"), strings.Join(screennames, ", "))
+	body := fmt.Sprintf(plugins.Gettext("From %s"), strings.Join(screennames, ", "))
 	action := fmt.Sprintf("%s/i/connect", twitterDispatchUrlBase)
 	epoch := time.Now().Unix()
 
@@ -163,20 +226,35 @@ func (p *twitterPlugin) parseDirectMessages(resp *http.Response) (*plugins.PushM
 	if err := decoder.Decode(&dms); err != nil {
 		return nil, err
 	}
+	return p.directMessagesBatch(dms), nil
+}
 
+// directMessagesBatch creates the notifications for the direct messages,
+// and remembers the most recent one.
+func (p *twitterPlugin) directMessagesBatch(dms []directMessage) *plugins.PushMessageBatch {
 	sort.Sort(sort.Reverse(byDMId(dms)))
 	if len(dms) < 1 {
-		return nil, nil
+		return nil
+	}
+	if dms[0].Id > p.config.LastDirectMessageId {
+		p.config.LastDirectMessageId = dms[0].Id
 	}
-	p.config.LastDirectMessageId = dms[0].Id
 
 	pushMsg := make([]*plugins.PushMessage, len(dms))
 	for i, m := range dms {
 		// TRANSLATORS: The first %s refers to the twitter user's Name, the second %s to the username.
-		summary := fmt.Sprintf(gettext.Gettext("%s. @%s"), m.Sender.Name, m.Sender.ScreenName)
+		summary := fmt.Sprintf(plugins.Gettext("%s. @%s"), m.Sender.Name, m.Sender.ScreenName)
 		action := fmt.Sprintf("%s/%s/messages", twitterDispatchUrlBase, m.Sender.ScreenName)
 		epoch := toEpoch(m.CreatedAt)
-		pushMsg[i] = plugins.NewStandardPushMessage(summary, m.Text, action, m.Sender.Image, epoch)
+		body := m.body()
+		pushMsg[i] = plugins.NewStandardPushMessage(summary, body, action, avatarIcon(m.Sender.Image), epoch)
+		pushMsg[i].Fields = &plugins.MessageFields{
+			Senders: []string{m.Sender.ScreenName},
+			Body:    body,
+			// The conversation is identified by the other party
+			Thread: m.Sender.ScreenName,
+			Labels: []string{"direct-message"},
+		}
 	}
 
 	return &plugins.PushMessageBatch{
@@ -184,7 +262,8 @@ func (p *twitterPlugin) parseDirectMessages(resp *http.Response) (*plugins.PushM
 		Limit:           maxIndividualDirectMessages,
 		OverflowHandler: p.consolidateDirectMessages,
 		Tag:             "direct-message",
-	}, nil
+		Priority:        plugins.PRIORITY_HIGH,
+	}
 }
 
 func (p *twitterPlugin) consolidateDirectMessages(pushMsg []*plugins.PushMessage) *plugins.PushMessage {
@@ -192,10 +271,10 @@ func (p *twitterPlugin) consolidateDirectMessages(pushMsg []*plugins.PushMessage
 	for i, m := range pushMsg {
 		senders[i] = m.Notification.Card.Summary
 	}
-	// TRANSLATORS: This represents a notification summary about more twitter direct messages available
-	summary := gettext.Gettext("Multiple direct messages available")
+	// TRANSLATORS: This represents a notification summary about new twitter direct messages, %d is their number
+	summary := fmt.Sprintf(plugins.NGettext("%d new direct message", "%d new direct messages", uint64(len(pushMsg))), len(pushMsg))
 	// TRANSLATORS: This represents a notification body with the comma separated twitter usernames
-	body := fmt.Sprintf(gettext.Gettext("From %s"), strings.Join(senders, ", "))
+	body := fmt.Sprintf(plugins.Gettext("From %s"), strings.Join(senders, ", "))
 	action := fmt.Sprintf("%s/messages", twitterDispatchUrlBase)
 	epoch := time.Now().Unix()
 
@@ -203,6 +282,7 @@ func (p *twitterPlugin) consolidateDirectMessages(pushMsg []*plugins.PushMessage
 }
 
 func (p *twitterPlugin) loadPersistentData(accountId uint) error {
+	p.config = twitterConfig{}
 	err := plugins.FromPersist(pluginName, accountId, &p.config)
 	return err
 }
@@ -219,37 +299,21 @@ func (p *twitterPlugin) Poll(authData *plugins.AuthData) (batches []*plugins.Pus
 	defer p.savePersistentData(authData.AccountId)
 	p.loadPersistentData(authData.AccountId)
 
-	url := "statuses/mentions_timeline.json"
-	notifyMentions := false
-	if p.config.LastMentionId > 0 {
-		url = fmt.Sprintf("%s?since_id=%d", url, p.config.LastMentionId)
-		notifyMentions = true
-	}
-	resp, err := p.request(authData, url)
-	if err != nil {
-		return
+	// Don't send any notifications if this is the very first time we run:
+	// otherwise we would emit notifications for all the old messages too
+	notifyMentions := p.config.LastMentionId > 0
+	notifyMessages := p.config.LastDirectMessageId > 0
+
+	var statuses, dms *plugins.PushMessageBatch
+	if p.config.ApiVersion == apiVersion1 {
+		statuses, dms, err = p.pollV1(authData)
+	} else {
+		statuses, dms, err = p.pollV2(authData)
 	}
-	statuses, err := p.parseStatuses(resp)
 	if err != nil {
 		return
 	}
 
-	url = "direct_messages.json"
-	notifyMessages := false
-	if p.config.LastDirectMessageId > 0 {
-		url = fmt.Sprintf("%s?since_id=%d", url, p.config.LastDirectMessageId)
-		notifyMessages = true
-	}
-	resp, err = p.request(authData, url)
-	if err != nil {
-		return
-	}
-	dms, err := p.parseDirectMessages(resp)
-	if err != nil {
-		return
-	}
-	// Don't send any notifications if this is the very first time we run:
-	// otherwise we would emit notifications for all the old messages too
 	if notifyMentions {
 		if statuses != nil && len(statuses.Messages) > 0 {
 			batches = append(batches, statuses)
@@ -260,12 +324,49 @@ func (p *twitterPlugin) Poll(authData *plugins.AuthData) (batches []*plugins.Pus
 			batches = append(batches, dms)
 		}
 	}
+	if p.config.ApiVersion != apiVersion1 {
+		batches = append(batches, p.pollActivity(authData)...)
+	}
+	return
+}
+
+// pollV1 retrieves the new mentions and direct messages with the v1.1 API
+func (p *twitterPlugin) pollV1(authData *plugins.AuthData) (statuses, dms *plugins.PushMessageBatch, err error) {
+	var limited rateLimited
+
+	url := "statuses/mentions_timeline.json?tweet_mode=extended"
+	if p.config.LastMentionId > 0 {
+		url = fmt.Sprintf("%s&since_id=%d", url, p.config.LastMentionId)
+	}
+	resp, err := p.request(authData, baseUrl, url)
+	if err == nil {
+		statuses, err = p.parseStatuses(resp)
+	}
+	if err = limited.skip(err); err != nil {
+		return
+	}
+
+	url = "direct_messages.json?full_text=true"
+	if p.config.LastDirectMessageId > 0 {
+		url = fmt.Sprintf("%s&since_id=%d", url, p.config.LastDirectMessageId)
+	}
+	resp, err = p.request(authData, baseUrl, url)
+	if err == nil {
+		dms, err = p.parseDirectMessages(resp)
+	}
+	if err = limited.skip(err); err != nil {
+		return
+	}
+	err = limited.result()
 	return
 }
 
+// toEpoch parses the timestamps of both the v1.1 and the v2 API
 func toEpoch(timestamp string) int64 {
-	if t, err := time.Parse(time.RubyDate, timestamp); err == nil {
-		return t.Unix()
+	for _, layout := range []string{time.RubyDate, time.RFC3339} {
+		if t, err := time.Parse(layout, timestamp); err == nil {
+			return t.Unix()
+		}
 	}
 	return time.Now().Unix()
 }
@@ -273,10 +374,26 @@ func toEpoch(timestamp string) int64 {
 // Status format is described here:
 // https://dev.twitter.com/docs/api/1.1/get/statuses/mentions_timeline
 type status struct {
-	Id        int64  `json:"id"`
-	CreatedAt string `json:"created_at"`
-	User      user   `json:"user"`
-	Text      string `json:"text"`
+	Id                int64  `json:"id"`
+	CreatedAt         string `json:"created_at"`
+	User              user   `json:"user"`
+	Text              string `json:"text"`
+	InReplyToStatusId int64  `json:"in_reply_to_status_id"`
+	// FullText replaces Text when requesting the extended mode, while
+	// ExtendedTweet holds the full text of long tweets in compatibility
+	// mode.
+	FullText      string         `json:"full_text"`
+	Entities      entities       `json:"entities"`
+	ExtendedTweet *extendedTweet `json:"extended_tweet"`
+}
+
+// thread returns the id of the status this one is replying to, or of the
+// status itself if it's not a reply.
+func (s status) thread() string {
+	if s.InReplyToStatusId != 0 {
+		return strconv.FormatInt(s.InReplyToStatusId, 10)
+	}
+	return strconv.FormatInt(s.Id, 10)
 }
 
 // ByStatusId implements sort.Interface for []status based on
@@ -290,11 +407,12 @@ func (s byStatusId) Less(i, j int) bool { return s[i].Id < s[j].Id }
 // Direct message format is described here:
 // https://dev.twitter.com/docs/api/1.1/get/direct_messages
 type directMessage struct {
-	Id        int64  `json:"id"`
-	CreatedAt string `json:"created_at"`
-	Sender    user   `json:"sender"`
-	Recipient user   `json:"recipient"`
-	Text      string `json:"text"`
+	Id        int64    `json:"id"`
+	CreatedAt string   `json:"created_at"`
+	Sender    user     `json:"sender"`
+	Recipient user     `json:"recipient"`
+	Text      string   `json:"text"`
+	Entities  entities `json:"entities"`
 }
 
 // ByStatusId implements sort.Interface for []status based on
@@ -19,6 +19,8 @@ import (
 	"bytes"
 	"io"
 	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "launchpad.net/gocheck"
@@ -36,6 +38,21 @@ func TestAll(t *testing.T) {
 	TestingT(t)
 }
 
+func (s S) SetUpTest(c *C) {
+	dataDir := c.MkDir()
+	plugins.XdgDataFind = func(p string) (string, error) {
+		p = filepath.Join(dataDir, p)
+		_, err := os.Stat(p)
+		return p, err
+	}
+	plugins.XdgDataEnsure = func(p string) (string, error) {
+		p = filepath.Join(dataDir, p)
+		return p, os.MkdirAll(filepath.Dir(p), 0700)
+	}
+	// Keep the remote icons, the cache is tested in plugins
+	avatarIcon = func(image string) string { return image }
+}
+
 // closeWraper adds a dummy Close() method to a reader
 type closeWrapper struct {
 	io.Reader
@@ -416,12 +433,12 @@ func (s S) TestParseStatuses(c *C) {
 	messages := batch.Messages
 	c.Assert(len(messages), Equals, 2)
 	c.Check(messages[0].Notification.Card.Summary, Equals, "Andrew Spode Miller. @spode")
-	c.Check(messages[0].Notification.Card.Body, Equals, "@jasoncosta @themattharris Hey! Going to be in Frisco in October. Was hoping to have a meeting to talk about @thinkwall if you're around?")
+	c.Check(messages[0].Notification.Card.Body, Equals, "@Jason Costa @Matt Harris Hey! Going to be in Frisco in October. Was hoping to have a meeting to talk about @ThinkWall if you're around?")
 	c.Check(messages[0].Notification.Card.Icon, Equals, "http://a0.twimg.com/profile_images/1227466231/spode-balloon-medium_normal.jpg")
 	c.Assert(len(messages[0].Notification.Card.Actions), Equals, 1)
 	c.Check(messages[0].Notification.Card.Actions[0], Equals, "https://mobile.twitter.com/spode/statuses/242613977966850048")
 	c.Check(messages[1].Notification.Card.Summary, Equals, "Mikey. @mikedroid")
-	c.Check(messages[1].Notification.Card.Body, Equals, "Got the shirt @jasoncosta thanks man! Loving the #twitter bird on the shirt :-)")
+	c.Check(messages[1].Notification.Card.Body, Equals, "Got the shirt @Jason Costa thanks man! Loving the #twitter bird on the shirt :-)")
 	c.Check(messages[1].Notification.Card.Icon, Equals, "http://a0.twimg.com/profile_images/1305509670/chatMikeTwitter_normal.png")
 	c.Assert(len(messages[1].Notification.Card.Actions), Equals, 1)
 	c.Check(messages[1].Notification.Card.Actions[0], Equals, "https://mobile.twitter.com/mikedroid/statuses/242534402280783873")
@@ -498,3 +515,28 @@ func (s S) TestParseDirectMessagesTokenExpiredError(c *C) {
 	c.Check(messages, IsNil)
 	c.Assert(err, Equals, plugins.ErrTokenExpired)
 }
+
+func (s S) TestConsolidateStatuses(c *C) {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       closeWrapper{bytes.NewReader([]byte(statusesBody))},
+	}
+	p := &twitterPlugin{}
+	batch, err := p.parseStatuses(resp)
+	c.Assert(err, IsNil)
+	consolidated := p.consolidateStatuses(batch.Messages)
+	c.Check(consolidated.Notification.Card.Summary, Equals, "2 new mentions")
+	c.Check(consolidated.Notification.Card.Body, Equals, "From Andrew Spode Miller. @spode, Mikey. @mikedroid")
+}
+
+func (s S) TestConsolidateDirectMessages(c *C) {
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       closeWrapper{bytes.NewReader([]byte(directMessagesBody))},
+	}
+	p := &twitterPlugin{}
+	batch, err := p.parseDirectMessages(resp)
+	c.Assert(err, IsNil)
+	consolidated := p.consolidateDirectMessages(batch.Messages)
+	c.Check(consolidated.Notification.Card.Summary, Equals, "1 new direct message")
+}
@@ -0,0 +1,371 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+// maxPagesV2 limits the number of result pages fetched in a single poll
+const maxPagesV2 = 5
+
+const userFieldsV2 = "name,username,profile_image_url"
+
+// pollV2 retrieves the new mentions and direct messages with the v2 API
+func (p *twitterPlugin) pollV2(authData *plugins.AuthData) (statuses, dms *plugins.PushMessageBatch, err error) {
+	if p.config.UserId == "" || p.config.UserName == "" {
+		var me userV2
+		if me, err = p.userV2(authData); err != nil {
+			return
+		}
+		p.config.UserId, p.config.UserName = me.Id, me.Username
+	}
+
+	// Endpoints which are rate limited are skipped until they reset
+	var limited rateLimited
+
+	mentions, err := p.mentionsV2(authData)
+	if err = limited.skip(err); err != nil {
+		return
+	}
+	statuses = p.statusesBatch(mentions)
+
+	messages, err := p.directMessagesV2(authData)
+	if err = limited.skip(err); err != nil {
+		return
+	}
+	dms = p.directMessagesBatch(messages)
+	err = limited.result()
+	return
+}
+
+// userV2 retrieves the authenticated user
+func (p *twitterPlugin) userV2(authData *plugins.AuthData) (userV2, error) {
+	var result struct {
+		Data userV2 `json:"data"`
+	}
+	err := p.getV2(authData, "users/me", nil, &result)
+	return result.Data, err
+}
+
+// mentionsV2 retrieves the mentions newer than the last one seen, following
+// the pagination up to maxPagesV2 pages.
+func (p *twitterPlugin) mentionsV2(authData *plugins.AuthData) ([]status, error) {
+	query := url.Values{}
+	query.Set("expansions", "author_id,entities.mentions.username")
+	query.Set("user.fields", userFieldsV2)
+	query.Set("tweet.fields", "created_at,conversation_id,in_reply_to_user_id,referenced_tweets,entities,note_tweet")
+	if p.config.LastMentionId > 0 {
+		query.Set("since_id", strconv.FormatInt(p.config.LastMentionId, 10))
+	}
+
+	path := fmt.Sprintf("users/%s/mentions", url.QueryEscape(p.config.UserId))
+	var statuses []status
+	for page := 0; page < maxPagesV2; page++ {
+		var result tweetsV2
+		if err := p.getV2(authData, path, query, &result); err != nil {
+			return nil, err
+		}
+		users := result.Includes.usersById()
+		for _, t := range result.Data {
+			s, err := t.status(users)
+			if err != nil {
+				return nil, err
+			}
+			statuses = append(statuses, s)
+		}
+		if result.Meta.NextToken == "" {
+			break
+		}
+		query.Set("pagination_token", result.Meta.NextToken)
+	}
+	return statuses, nil
+}
+
+// directMessagesV2 retrieves the direct messages received after the last
+// one seen. The dm_events endpoint doesn't support since_id, so the events
+// (which are returned newest first) are read until an already seen one is
+// found.
+func (p *twitterPlugin) directMessagesV2(authData *plugins.AuthData) ([]directMessage, error) {
+	query := url.Values{}
+	query.Set("event_types", "MessageCreate")
+	query.Set("expansions", "sender_id")
+	query.Set("user.fields", userFieldsV2)
+	query.Set("dm_event.fields", "id,text,created_at,sender_id,dm_conversation_id,entities")
+
+	last := p.config.LastDirectMessageId
+	var newestSent int64
+	var dms []directMessage
+	defer func() {
+		// Remember the messages we sent, so that we don't go through
+		// them again
+		if newestSent > p.config.LastDirectMessageId {
+			p.config.LastDirectMessageId = newestSent
+		}
+	}()
+	for page := 0; page < maxPagesV2; page++ {
+		var result dmEventsV2
+		if err := p.getV2(authData, "dm_events", query, &result); err != nil {
+			return nil, err
+		}
+		users := result.Includes.usersById()
+		for _, e := range result.Data {
+			dm, err := e.directMessage(users)
+			if err != nil {
+				return nil, err
+			}
+			if dm.Id <= last {
+				return dms, nil
+			}
+			if e.SenderId == p.config.UserId {
+				if dm.Id > newestSent {
+					newestSent = dm.Id
+				}
+				continue
+			}
+			dms = append(dms, dm)
+		}
+		// On the first run the latest page is enough to know where
+		// to start from next time
+		if result.Meta.NextToken == "" || last == 0 {
+			break
+		}
+		query.Set("pagination_token", result.Meta.NextToken)
+	}
+	return dms, nil
+}
+
+// getV2 performs a GET request on the v2 API and decodes the response
+func (p *twitterPlugin) getV2(authData *plugins.AuthData, path string, query url.Values, result interface{}) error {
+	if len(query) > 0 {
+		path = path + "?" + query.Encode()
+	}
+	resp, err := p.request(authData, baseUrlV2, path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decoder := json.NewDecoder(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusUnauthorized {
+			return plugins.ErrTokenExpired
+		}
+		result := TwitterErrorV2{Status: resp.StatusCode}
+		if err := decoder.Decode(&result); err != nil {
+			return err
+		}
+		return &result
+	}
+	return decoder.Decode(result)
+}
+
+// The v2 response formats are described here:
+// https://developer.twitter.com/en/docs/twitter-api/tweets/timelines/api-reference/get-users-id-mentions
+// https://developer.twitter.com/en/docs/twitter-api/direct-messages/lookup/api-reference/get-dm_events
+type userV2 struct {
+	Id       string `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+	Image    string `json:"profile_image_url"`
+}
+
+type includesV2 struct {
+	Users  []userV2  `json:"users"`
+	Tweets []tweetV2 `json:"tweets"`
+}
+
+// usersById converts the expanded users into the v1.1 format
+func (i includesV2) usersById() map[string]user {
+	users := make(map[string]user, len(i.Users))
+	for _, u := range i.Users {
+		id, _ := strconv.ParseInt(u.Id, 10, 64)
+		users[u.Id] = user{
+			Id:         id,
+			ScreenName: u.Username,
+			Name:       u.Name,
+			Image:      u.Image,
+		}
+	}
+	return users
+}
+
+type metaV2 struct {
+	NewestId  string `json:"newest_id"`
+	NextToken string `json:"next_token"`
+}
+
+type referencedTweetV2 struct {
+	Type string `json:"type"`
+	Id   string `json:"id"`
+}
+
+type tweetV2 struct {
+	Id               string              `json:"id"`
+	Text             string              `json:"text"`
+	AuthorId         string              `json:"author_id"`
+	CreatedAt        string              `json:"created_at"`
+	ConversationId   string              `json:"conversation_id"`
+	ReferencedTweets []referencedTweetV2 `json:"referenced_tweets"`
+	Entities         entitiesV2          `json:"entities"`
+	// NoteTweet holds the full text of long tweets
+	NoteTweet *struct {
+		Text     string     `json:"text"`
+		Entities entitiesV2 `json:"entities"`
+	} `json:"note_tweet"`
+	PublicMetrics struct {
+		LikeCount int `json:"like_count"`
+	} `json:"public_metrics"`
+}
+
+// status converts the tweet into the v1.1 format
+func (t tweetV2) status(users map[string]user) (status, error) {
+	s := status{
+		CreatedAt: t.CreatedAt,
+		User:      users[t.AuthorId],
+		Text:      t.Text,
+		Entities:  t.Entities.entities(users),
+	}
+	if t.NoteTweet != nil && t.NoteTweet.Text != "" {
+		s.ExtendedTweet = &extendedTweet{
+			FullText: t.NoteTweet.Text,
+			Entities: t.NoteTweet.Entities.entities(users),
+		}
+	}
+	var err error
+	if s.Id, err = strconv.ParseInt(t.Id, 10, 64); err != nil {
+		return s, err
+	}
+	for _, r := range t.ReferencedTweets {
+		if r.Type == "replied_to" {
+			s.InReplyToStatusId, _ = strconv.ParseInt(r.Id, 10, 64)
+		}
+	}
+	return s, nil
+}
+
+type entitiesV2 struct {
+	Urls []struct {
+		Start       int    `json:"start"`
+		End         int    `json:"end"`
+		Url         string `json:"url"`
+		ExpandedUrl string `json:"expanded_url"`
+		DisplayUrl  string `json:"display_url"`
+	} `json:"urls"`
+	Mentions []struct {
+		Start    int    `json:"start"`
+		End      int    `json:"end"`
+		Username string `json:"username"`
+	} `json:"mentions"`
+}
+
+// entities converts the entities into the v1.1 format, taking the names
+// of the mentioned users from the expanded ones.
+func (e entitiesV2) entities(users map[string]user) entities {
+	var result entities
+	for _, u := range e.Urls {
+		result.Urls = append(result.Urls, urlEntity{
+			Url:         u.Url,
+			ExpandedUrl: u.ExpandedUrl,
+			DisplayUrl:  u.DisplayUrl,
+			Indices:     [2]int{u.Start, u.End},
+		})
+	}
+	for _, m := range e.Mentions {
+		mention := mentionEntity{
+			ScreenName: m.Username,
+			Indices:    [2]int{m.Start, m.End},
+		}
+		for _, u := range users {
+			if strings.EqualFold(u.ScreenName, m.Username) {
+				mention.Name = u.Name
+				break
+			}
+		}
+		result.UserMentions = append(result.UserMentions, mention)
+	}
+	return result
+}
+
+type tweetsV2 struct {
+	Data     []tweetV2  `json:"data"`
+	Includes includesV2 `json:"includes"`
+	Meta     metaV2     `json:"meta"`
+}
+
+type dmEventV2 struct {
+	Id             string     `json:"id"`
+	EventType      string     `json:"event_type"`
+	Text           string     `json:"text"`
+	SenderId       string     `json:"sender_id"`
+	CreatedAt      string     `json:"created_at"`
+	ConversationId string     `json:"dm_conversation_id"`
+	Entities       entitiesV2 `json:"entities"`
+}
+
+// directMessage converts the event into the v1.1 format
+func (e dmEventV2) directMessage(users map[string]user) (directMessage, error) {
+	dm := directMessage{
+		CreatedAt: e.CreatedAt,
+		Sender:    users[e.SenderId],
+		Text:      e.Text,
+		Entities:  e.Entities.entities(users),
+	}
+	var err error
+	dm.Id, err = strconv.ParseInt(e.Id, 10, 64)
+	return dm, err
+}
+
+type dmEventsV2 struct {
+	Data     []dmEventV2 `json:"data"`
+	Includes includesV2  `json:"includes"`
+	Meta     metaV2      `json:"meta"`
+}
+
+// The v2 error response format is described here:
+// https://developer.twitter.com/en/support/twitter-api/error-troubleshooting
+type TwitterErrorV2 struct {
+	Status int    `json:"status"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
+	Errors []struct {
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+func (err *TwitterErrorV2) Error() string {
+	messages := []string{}
+	if err.Detail != "" {
+		messages = append(messages, err.Detail)
+	} else if err.Title != "" {
+		messages = append(messages, err.Title)
+	}
+	for _, e := range err.Errors {
+		messages = append(messages, e.Message)
+	}
+	if len(messages) == 0 {
+		return fmt.Sprintf("twitter: HTTP status %d", err.Status)
+	}
+	return strings.Join(messages, "\n")
+}
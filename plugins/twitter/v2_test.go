@@ -0,0 +1,191 @@
+/*
+ Copyright 2014 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package twitter
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "launchpad.net/gocheck"
+
+	"launchpad.net/account-polld/plugins"
+)
+
+const (
+	meBodyV2 = `{"data": {"id": "2244994945", "name": "Jason Costa", "username": "jasoncosta"}}`
+
+	mentionsPage1BodyV2 = `
+{
+  "data": [
+    {
+      "id": "1375152598945312768",
+      "text": "@jasoncosta are you around?",
+      "author_id": "783214",
+      "created_at": "2021-03-25T18:02:32.000Z",
+      "conversation_id": "1375152449594523649",
+      "referenced_tweets": [{"type": "replied_to", "id": "1375152449594523649"}]
+    }
+  ],
+  "includes": {
+    "users": [
+      {"id": "783214", "name": "Twitter", "username": "Twitter", "profile_image_url": "https://pbs.twimg.com/twitter_normal.jpg"}
+    ]
+  },
+  "meta": {"newest_id": "1375152598945312768", "next_token": "page2"}
+}`
+	mentionsPage2BodyV2 = `
+{
+  "data": [
+    {
+      "id": "1375152449594523649",
+      "text": "Hey @jasoncosta",
+      "author_id": "6253282",
+      "created_at": "2021-03-25T18:01:56.000Z",
+      "conversation_id": "1375152449594523649"
+    }
+  ],
+  "includes": {
+    "users": [
+      {"id": "6253282", "name": "Twitter API", "username": "TwitterAPI", "profile_image_url": "https://pbs.twimg.com/api_normal.jpg"}
+    ]
+  },
+  "meta": {}
+}`
+	dmEventsBodyV2 = `
+{
+  "data": [
+    {"id": "1582838650089136132", "event_type": "MessageCreate", "text": "see you then", "sender_id": "2244994945", "created_at": "2022-10-19T20:58:00.000Z"},
+    {"id": "1582838650089136131", "event_type": "MessageCreate", "text": "booyakasha", "sender_id": "38895958", "created_at": "2022-10-19T20:57:00.000Z"},
+    {"id": "1582838650089136130", "event_type": "MessageCreate", "text": "already seen", "sender_id": "38895958", "created_at": "2022-10-19T20:56:00.000Z"}
+  ],
+  "includes": {
+    "users": [
+      {"id": "38895958", "name": "Sean Cook", "username": "theSeanCook", "profile_image_url": "https://pbs.twimg.com/sean_normal.jpg"},
+      {"id": "2244994945", "name": "Jason Costa", "username": "jasoncosta"}
+    ]
+  },
+  "meta": {"next_token": "older"}
+}`
+	errorBodyV2 = `{"title": "Service Unavailable", "detail": "Service Unavailable", "type": "about:blank", "status": 503}`
+)
+
+// serveV2 points the v2 API to a test server replying with the given
+// bodies, indexed by request path and pagination token.
+func serveV2(c *C, bodies map[string]string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Path
+		if token := r.URL.Query().Get("pagination_token"); token != "" {
+			key += "?" + token
+		}
+		body, ok := bodies[key]
+		if !ok {
+			c.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	baseUrlV2, _ = url.Parse(server.URL + "/2/")
+	return server
+}
+
+func (s S) TestPollV2(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := serveV2(c, map[string]string{
+		"/2/users/me":                        meBodyV2,
+		"/2/users/2244994945/mentions":       mentionsPage1BodyV2,
+		"/2/users/2244994945/mentions?page2": mentionsPage2BodyV2,
+		"/2/dm_events":                       dmEventsBodyV2,
+	})
+	defer server.Close()
+
+	p := &twitterPlugin{}
+	p.config.LastDirectMessageId = 1582838650089136130
+	statuses, dms, err := p.pollV2(&plugins.AuthData{})
+	c.Assert(err, IsNil)
+	c.Check(p.config.UserId, Equals, "2244994945")
+
+	c.Assert(statuses, NotNil)
+	c.Assert(len(statuses.Messages), Equals, 2)
+	card := statuses.Messages[0].Notification.Card
+	c.Check(card.Summary, Equals, "Twitter. @Twitter")
+	c.Check(card.Body, Equals, "@jasoncosta are you around?")
+	c.Check(card.Icon, Equals, "https://pbs.twimg.com/twitter_normal.jpg")
+	c.Check(card.Actions, DeepEquals, []string{"https://mobile.twitter.com/Twitter/statuses/1375152598945312768"})
+	c.Check(card.Timestamp, Equals, int64(1616695352))
+	c.Check(statuses.Messages[0].Fields.Thread, Equals, "1375152449594523649")
+	c.Check(statuses.Messages[1].Notification.Card.Summary, Equals, "Twitter API. @TwitterAPI")
+	c.Check(p.config.LastMentionId, Equals, int64(1375152598945312768))
+
+	// Our own message and the already seen one are skipped
+	c.Assert(dms, NotNil)
+	c.Assert(len(dms.Messages), Equals, 1)
+	card = dms.Messages[0].Notification.Card
+	c.Check(card.Summary, Equals, "Sean Cook. @theSeanCook")
+	c.Check(card.Body, Equals, "booyakasha")
+	c.Check(p.config.LastDirectMessageId, Equals, int64(1582838650089136132))
+}
+
+func (s S) TestPollV2Error(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, errorBodyV2)
+	}))
+	defer server.Close()
+	baseUrlV2, _ = url.Parse(server.URL + "/2/")
+
+	p := &twitterPlugin{config: twitterConfig{UserId: "2244994945", UserName: "jasoncosta"}}
+	statuses, dms, err := p.pollV2(&plugins.AuthData{})
+	c.Check(statuses, IsNil)
+	c.Check(dms, IsNil)
+	c.Assert(err, FitsTypeOf, &TwitterErrorV2{})
+	c.Check(err.(*TwitterErrorV2).Status, Equals, http.StatusServiceUnavailable)
+	c.Check(err.Error(), Equals, "Service Unavailable")
+}
+
+func (s S) TestPollV2TokenExpired(c *C) {
+	defer func(u *url.URL) { baseUrlV2 = u }(baseUrlV2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"title": "Unauthorized", "status": 401}`)
+	}))
+	defer server.Close()
+	baseUrlV2, _ = url.Parse(server.URL + "/2/")
+
+	p := &twitterPlugin{}
+	_, _, err := p.pollV2(&plugins.AuthData{})
+	c.Check(err, Equals, plugins.ErrTokenExpired)
+}
+
+func (s S) TestPersistentDataPerAccount(c *C) {
+	p := &twitterPlugin{}
+	p.loadPersistentData(1)
+	p.config.UserId = "2244994945"
+	p.config.LastMentionId = 1375152598945312768
+	c.Assert(p.savePersistentData(1), IsNil)
+
+	// An account without any state doesn't get the one of the
+	// previous account
+	c.Check(p.loadPersistentData(2), NotNil)
+	c.Check(p.config, DeepEquals, twitterConfig{})
+
+	c.Assert(p.loadPersistentData(1), IsNil)
+	c.Check(p.config.UserId, Equals, "2244994945")
+}
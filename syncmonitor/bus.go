@@ -0,0 +1,114 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package syncmonitor
+
+import (
+	"sync"
+
+	"launchpad.net/go-dbus/v1"
+)
+
+const (
+	busInterface = "com.canonical.SyncMonitor"
+	busPath      = "/com/canonical/SyncMonitor"
+	busName      = "com.canonical.SyncMonitor"
+)
+
+// watchedSignals are the signals of the service after which its state
+// is read again
+var watchedSignals = []string{"stateChanged", "syncFinished"}
+
+// bus is the connection to the SyncMonitor service; it is replaced by a
+// fake service in the tests.
+type bus interface {
+	// Call calls a method of the service, and stores the values it
+	// returns in reply
+	Call(method string, args []interface{}, reply ...interface{}) error
+	// WatchSignals returns a channel receiving the names of the watched
+	// signals emitted by the service, closed with the connection
+	WatchSignals() (<-chan string, error)
+	Close() error
+}
+
+// dbusBus talks to the service on the session bus
+type dbusBus struct {
+	conn    *dbus.Connection
+	obj     *dbus.ObjectProxy
+	watches []*dbus.SignalWatch
+}
+
+func connectBus() (*dbusBus, error) {
+	conn, err := dbus.Connect(dbus.SessionBus)
+	if err != nil {
+		return nil, err
+	}
+	return &dbusBus{
+		conn: conn,
+		obj:  conn.Object(busName, busPath),
+	}, nil
+}
+
+func (b *dbusBus) Call(method string, args []interface{}, reply ...interface{}) error {
+	message, err := b.obj.Call(busInterface, method, args...)
+	if err != nil || len(reply) == 0 {
+		return err
+	}
+	return message.Args(reply...)
+}
+
+func (b *dbusBus) WatchSignals() (<-chan string, error) {
+	var forwarders sync.WaitGroup
+	signals := make(chan string)
+	for _, member := range watchedSignals {
+		watch, err := b.conn.WatchSignal(&dbus.MatchRule{
+			Type:      dbus.TypeSignal,
+			Sender:    busName,
+			Path:      busPath,
+			Interface: busInterface,
+			Member:    member,
+		})
+		if err != nil {
+			b.cancelWatches()
+			return nil, err
+		}
+		b.watches = append(b.watches, watch)
+		forwarders.Add(1)
+		go func() {
+			defer forwarders.Done()
+			for message := range watch.C {
+				signals <- message.Member
+			}
+		}()
+	}
+	go func() {
+		forwarders.Wait()
+		close(signals)
+	}()
+	return signals, nil
+}
+
+func (b *dbusBus) cancelWatches() {
+	for _, watch := range b.watches {
+		watch.Cancel()
+	}
+	b.watches = nil
+}
+
+func (b *dbusBus) Close() error {
+	b.cancelWatches()
+	return b.conn.Close()
+}
@@ -0,0 +1,94 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package syncmonitor
+
+import (
+	"sort"
+	"time"
+)
+
+// Fake is an in-memory SyncMonitor, for the tests of the plugins
+type Fake struct {
+	// Calendars holds the calendars of each account
+	Calendars map[uint]map[string]string
+	// LastSyncDates holds the last sync date of the calendars, keyed by
+	// source id; the missing ones were never synced
+	LastSyncDates map[string]string
+	// CurrentState is returned by State, "idle" if empty
+	CurrentState string
+	// FinishesSync makes the service become idle when waited for
+	FinishesSync bool
+	// Err is returned by all the calls when set
+	Err error
+	// SyncErr is returned by SyncAccount when set
+	SyncErr error
+	// SyncRequests records the sources of each call to SyncAccount,
+	// sorted
+	SyncRequests [][]string
+	// Closed is set once the fake is closed
+	Closed bool
+}
+
+func (f *Fake) ListCalendarsByAccount(accountId uint) (map[string]string, error) {
+	if f.Err != nil {
+		return nil, f.Err
+	}
+	return f.Calendars[accountId], nil
+}
+
+func (f *Fake) LastSyncDate(accountId uint, sourceId string) (string, error) {
+	if f.Err != nil {
+		return "", f.Err
+	}
+	return f.LastSyncDates[sourceId], nil
+}
+
+func (f *Fake) SyncAccount(accountId uint, sources []string) error {
+	if f.Err != nil {
+		return f.Err
+	}
+	if f.SyncErr != nil {
+		return f.SyncErr
+	}
+	sorted := append([]string(nil), sources...)
+	sort.Strings(sorted)
+	f.SyncRequests = append(f.SyncRequests, sorted)
+	return nil
+}
+
+func (f *Fake) State() (string, error) {
+	if f.Err != nil {
+		return "", f.Err
+	}
+	if f.CurrentState == "" {
+		return "idle", nil
+	}
+	return f.CurrentState, nil
+}
+
+func (f *Fake) WaitIdle(timeout time.Duration) (bool, error) {
+	if f.FinishesSync {
+		f.CurrentState = "idle"
+	}
+	state, err := f.State()
+	return state == "idle", err
+}
+
+func (f *Fake) Close() error {
+	f.Closed = true
+	return nil
+}
@@ -18,76 +18,168 @@ package syncmonitor
 
 import (
 	"log"
-	"runtime"
-
-	"launchpad.net/go-dbus/v1"
+	"sync"
+	"time"
 )
 
-const (
-	busInterface = "com.canonical.SyncMonitor"
-	busPath      = "/com/canonical/SyncMonitor"
-	busName      = "com.canonical.SyncMonitor"
-)
+// IdleTimeout is how long the plugins wait for the sync in progress to
+// finish before polling the calendars
+const IdleTimeout = time.Minute
+
+// stateRecheckInterval is how often the state is read again while
+// waiting for the service to be idle, in case a signal was missed
+var stateRecheckInterval = 10 * time.Second
+
+// SyncMonitor is the service syncing the calendars of the accounts with
+// their servers. The calendars are identified by their source id.
+type SyncMonitor interface {
+	// ListCalendarsByAccount returns the calendars of the account,
+	// mapping their source ids to their names or addresses
+	ListCalendarsByAccount(accountId uint) (map[string]string, error)
+	// LastSyncDate returns the time of the last successful sync of the
+	// calendar, or an empty string if it was never synced
+	LastSyncDate(accountId uint, sourceId string) (string, error)
+	// SyncAccount starts the sync of the given calendars of the account
+	SyncAccount(accountId uint, sources []string) error
+	// State returns "idle" when no sync is in progress
+	State() (string, error)
+	// WaitIdle waits up to timeout for the sync in progress to finish,
+	// and tells whether the service is idle
+	WaitIdle(timeout time.Duration) (bool, error)
+	// Close releases the connection to the service
+	Close() error
+}
+
+// dbusSyncMonitor talks to the SyncMonitor service over a connection
+// kept for the life of the plugin. It follows the state of the service
+// through its signals.
+type dbusSyncMonitor struct {
+	bus bus
 
-type SyncMonitor struct {
-	conn *dbus.Connection
-	obj  *dbus.ObjectProxy
+	mu sync.Mutex
+	// watching is set while the signals are received, so that the
+	// cached state can be trusted
+	watching bool
+	// state is the last known state, empty if unknown
+	state string
+	// changed is closed, and replaced, when the state changes
+	changed chan struct{}
 }
 
-func NewSyncMonitor() *SyncMonitor {
-	conn, err := dbus.Connect(dbus.SessionBus)
+// NewSyncMonitor connects to the SyncMonitor service, and returns nil if
+// the session bus is not available.
+func NewSyncMonitor() SyncMonitor {
+	b, err := connectBus()
 	if err != nil {
 		log.Print("Fail to connect with session bus: ", err)
 		return nil
 	}
+	return newSyncMonitor(b)
+}
 
-	p := &SyncMonitor{
-		conn: conn,
-		obj:  conn.Object(busName, busPath),
+func newSyncMonitor(b bus) *dbusSyncMonitor {
+	m := &dbusSyncMonitor{
+		bus:     b,
+		changed: make(chan struct{}),
 	}
-	runtime.SetFinalizer(p, clean)
-	return p
+	signals, err := b.WatchSignals()
+	if err != nil {
+		log.Print("Cannot watch the sync monitor signals: ", err)
+	} else {
+		m.watching = true
+		go m.watch(signals)
+	}
+	return m
 }
 
-func clean(p *SyncMonitor) {
-	if p.conn != nil {
-		p.conn.Close()
+// watch reads the state again after each signal, until the connection
+// is closed
+func (m *dbusSyncMonitor) watch(signals <-chan string) {
+	for signal := range signals {
+		log.Print("Sync monitor signal: ", signal)
+		m.refreshState()
 	}
+	m.forgetState()
 }
 
-func (p *SyncMonitor) ListCalendarsByAccount(accountId uint) (calendars map[string]string, err error) {
-	message, err := p.obj.Call(busInterface, "listCalendarsByAccount", uint32(accountId))
-	if err != nil {
-		var calendars map[string]string
-		return calendars, err
-	} else {
-		err = message.Args(&calendars)
-		return calendars, err
-	}
+// forgetState stops trusting the cached state, once the signals are not
+// received anymore
+func (m *dbusSyncMonitor) forgetState() {
+	m.mu.Lock()
+	m.watching = false
+	m.mu.Unlock()
+	m.setState("")
 }
 
-func (p *SyncMonitor) LastSyncDate(accountId uint, sourceId string) (lastSyncDate string, err error) {
-	message, err := p.obj.Call(busInterface, "lastSuccessfulSyncDate", uint32(accountId), sourceId)
-	if err != nil {
-		return "", err
-	} else {
-		var lastSyncDate string
-		err = message.Args(&lastSyncDate)
-		return lastSyncDate, err
+// refreshState reads the state from the service
+func (m *dbusSyncMonitor) refreshState() (string, error) {
+	var state string
+	err := m.bus.Call("state", nil, &state)
+	m.setState(state)
+	return state, err
+}
+
+func (m *dbusSyncMonitor) setState(state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if state != m.state {
+		m.state = state
+		close(m.changed)
+		m.changed = make(chan struct{})
 	}
 }
 
-func (p *SyncMonitor) SyncAccount(accountId uint, sources []string) (err error) {
-	_, err = p.obj.Call(busInterface, "syncAccount", uint32(accountId), sources)
-	return err
+func (m *dbusSyncMonitor) ListCalendarsByAccount(accountId uint) (map[string]string, error) {
+	var calendars map[string]string
+	err := m.bus.Call("listCalendarsByAccount", []interface{}{uint32(accountId)}, &calendars)
+	return calendars, err
 }
 
-func (p *SyncMonitor) State() (state string, err error) {
-	message, err := p.obj.Call(busInterface, "state")
-	if err != nil {
-		return "", err
-	} else {
-		err = message.Args(&state)
-		return state, err
+func (m *dbusSyncMonitor) LastSyncDate(accountId uint, sourceId string) (string, error) {
+	var lastSyncDate string
+	err := m.bus.Call("lastSuccessfulSyncDate", []interface{}{uint32(accountId), sourceId}, &lastSyncDate)
+	return lastSyncDate, err
+}
+
+func (m *dbusSyncMonitor) SyncAccount(accountId uint, sources []string) error {
+	return m.bus.Call("syncAccount", []interface{}{uint32(accountId), sources})
+}
+
+func (m *dbusSyncMonitor) State() (string, error) {
+	m.mu.Lock()
+	state, watching := m.state, m.watching
+	m.mu.Unlock()
+	if watching && state != "" {
+		return state, nil
 	}
+	return m.refreshState()
+}
+
+func (m *dbusSyncMonitor) WaitIdle(timeout time.Duration) (bool, error) {
+	deadline := time.After(timeout)
+	for {
+		m.mu.Lock()
+		changed := m.changed
+		m.mu.Unlock()
+
+		state, err := m.State()
+		if err != nil {
+			return false, err
+		}
+		if state == "idle" {
+			return true, nil
+		}
+		select {
+		case <-changed:
+		case <-time.After(stateRecheckInterval):
+			m.setState("")
+		case <-deadline:
+			return false, nil
+		}
+	}
+}
+
+func (m *dbusSyncMonitor) Close() error {
+	m.forgetState()
+	return m.bus.Close()
 }
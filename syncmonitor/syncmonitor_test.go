@@ -0,0 +1,200 @@
+/*
+ Copyright 2016 Canonical Ltd.
+
+ This program is free software: you can redistribute it and/or modify it
+ under the terms of the GNU General Public License version 3, as published
+ by the Free Software Foundation.
+
+ This program is distributed in the hope that it will be useful, but
+ WITHOUT ANY WARRANTY; without even the implied warranties of
+ MERCHANTABILITY, SATISFACTORY QUALITY, or FITNESS FOR A PARTICULAR
+ PURPOSE.  See the GNU General Public License for more details.
+
+ You should have received a copy of the GNU General Public License along
+ with this program.  If not, see <http://www.gnu.org/licenses/>.
+*/
+
+package syncmonitor
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "launchpad.net/gocheck"
+)
+
+type S struct{}
+
+func init() {
+	Suite(S{})
+}
+
+func TestAll(t *testing.T) {
+	TestingT(t)
+}
+
+// fakeService stands for the SyncMonitor service on the bus
+type fakeService struct {
+	mu    sync.Mutex
+	state string
+	// calls records the methods called and their arguments
+	calls    [][]interface{}
+	signals  chan string
+	watchErr error
+	closed   bool
+}
+
+func newFakeService(state string) *fakeService {
+	return &fakeService{state: state, signals: make(chan string)}
+}
+
+func (s *fakeService) Call(method string, args []interface{}, reply ...interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("connection closed")
+	}
+	s.calls = append(s.calls, append([]interface{}{method}, args...))
+	switch method {
+	case "state":
+		*reply[0].(*string) = s.state
+	case "listCalendarsByAccount":
+		*reply[0].(*map[string]string) = map[string]string{"work": "Work"}
+	case "lastSuccessfulSyncDate":
+		*reply[0].(*string) = "2017-06-05T10:00:00Z"
+	case "syncAccount":
+	default:
+		return errors.New("unknown method " + method)
+	}
+	return nil
+}
+
+func (s *fakeService) WatchSignals() (<-chan string, error) {
+	if s.watchErr != nil {
+		return nil, s.watchErr
+	}
+	return s.signals, nil
+}
+
+func (s *fakeService) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.signals)
+	}
+	return nil
+}
+
+// setState changes the state of the service, emitting the given signal
+func (s *fakeService) setState(state, signal string) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+	s.signals <- signal
+}
+
+func (s *fakeService) callCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.calls)
+}
+
+func (s S) TestCalls(c *C) {
+	service := newFakeService("idle")
+	m := newSyncMonitor(service)
+	defer m.Close()
+
+	calendars, err := m.ListCalendarsByAccount(3)
+	c.Assert(err, IsNil)
+	c.Check(calendars, DeepEquals, map[string]string{"work": "Work"})
+	date, err := m.LastSyncDate(3, "work")
+	c.Assert(err, IsNil)
+	c.Check(date, Equals, "2017-06-05T10:00:00Z")
+	c.Check(m.SyncAccount(3, []string{"work"}), IsNil)
+	c.Check(service.calls, DeepEquals, [][]interface{}{
+		{"listCalendarsByAccount", uint32(3)},
+		{"lastSuccessfulSyncDate", uint32(3), "work"},
+		{"syncAccount", uint32(3), []string{"work"}},
+	})
+}
+
+func (s S) TestStateFollowsSignals(c *C) {
+	service := newFakeService("idle")
+	m := newSyncMonitor(service)
+	defer m.Close()
+
+	state, err := m.State()
+	c.Assert(err, IsNil)
+	c.Check(state, Equals, "idle")
+	// The state is only read again after a signal
+	state, err = m.State()
+	c.Assert(err, IsNil)
+	c.Check(state, Equals, "idle")
+	c.Check(service.callCount(), Equals, 1)
+
+	service.setState("syncing", "stateChanged")
+	idle, err := m.WaitIdle(10 * time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Check(idle, Equals, false)
+	state, err = m.State()
+	c.Assert(err, IsNil)
+	c.Check(state, Equals, "syncing")
+}
+
+func (s S) TestWaitIdle(c *C) {
+	service := newFakeService("syncing")
+	m := newSyncMonitor(service)
+	defer m.Close()
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		service.setState("idle", "syncFinished")
+	}()
+	start := time.Now()
+	idle, err := m.WaitIdle(5 * time.Second)
+	c.Assert(err, IsNil)
+	c.Check(idle, Equals, true)
+	c.Check(time.Since(start) < time.Second, Equals, true)
+}
+
+func (s S) TestWaitIdleWithoutSignals(c *C) {
+	defer func(interval time.Duration) { stateRecheckInterval = interval }(stateRecheckInterval)
+	stateRecheckInterval = 5 * time.Millisecond
+
+	service := newFakeService("syncing")
+	service.watchErr = errors.New("no match rules")
+	m := newSyncMonitor(service)
+	defer m.Close()
+
+	idle, err := m.WaitIdle(20 * time.Millisecond)
+	c.Assert(err, IsNil)
+	c.Check(idle, Equals, false)
+
+	// The state is read again periodically
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		service.mu.Lock()
+		service.state = "idle"
+		service.mu.Unlock()
+	}()
+	idle, err = m.WaitIdle(5 * time.Second)
+	c.Assert(err, IsNil)
+	c.Check(idle, Equals, true)
+}
+
+func (s S) TestClose(c *C) {
+	service := newFakeService("idle")
+	m := newSyncMonitor(service)
+	state, err := m.State()
+	c.Assert(err, IsNil)
+	c.Check(state, Equals, "idle")
+	c.Check(m.Close(), IsNil)
+
+	// The cached state is forgotten with the connection
+
+	_, err = m.WaitIdle(time.Second)
+	c.Check(err, NotNil)
+}
@@ -13,7 +13,7 @@ xgettext -o $pot_file \
  --add-comments \
  --from-code=UTF-8 \
  --c++ --qt --add-comments=TRANSLATORS \
- --keyword=Gettext --keyword=tr --keyword=tr:1,2 --keyword=N_ --keyword=_description \
+ --keyword=Gettext --keyword=NGettext:1,2 --keyword=tr --keyword=tr:1,2 --keyword=N_ --keyword=_description \
  --package-name=$domain \
  --copyright-holder='Canonical Ltd.' \
  $sources $desktop.tr.h